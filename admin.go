@@ -0,0 +1,167 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/fcavani/e"
+)
+
+// LevelsHandler is a http.Handler that shows and changes the levels of one
+// logger. Mount it with http.StripPrefix, the rest of the path is the
+// scope:
+//
+//	GET    /         the levels and the filters of the backends
+//	GET    /scope    the level of the scope
+//	PUT    /scope    sets the level of the scope, the level is the body or
+//	                 the form value level
+//	DELETE /scope    removes the level of the scope
+//
+// The scope all is the level for all packages.
+type LevelsHandler struct {
+	logger Logger
+}
+
+// NewLevelsHandler creates a handler for the logger l. If l is nil the
+// handler uses the default logger.
+func NewLevelsHandler(l Logger) *LevelsHandler {
+	return &LevelsHandler{
+		logger: l,
+	}
+}
+
+// LevelsState is the response of the LevelsHandler.
+type LevelsState struct {
+	Default  string            `json:"default"`
+	Levels   map[string]string `json:"levels"`
+	Backends []BackendState    `json:"backends,omitempty"`
+}
+
+// BackendState describes one backend and its filter.
+type BackendState struct {
+	Type     string         `json:"type"`
+	Filter   string         `json:"filter,omitempty"`
+	Backends []BackendState `json:"backends,omitempty"`
+}
+
+func (h *LevelsHandler) get() Logger {
+	if h.logger == nil {
+		return Log
+	}
+	return h.logger
+}
+
+func backendState(b LogBackend) BackendState {
+	s := BackendState{
+		Type: fmt.Sprintf("%T", b),
+	}
+	if fg, ok := b.(FilterGetter); ok {
+		if r := fg.GetFilter(); r != nil {
+			f, err := FormatRule(r)
+			if err != nil {
+				f = fmt.Sprintf("%T", r)
+			}
+			s.Filter = f
+		}
+	}
+	if m, ok := b.(Multiplexer); ok {
+		for _, child := range m.Backends() {
+			s.Backends = append(s.Backends, backendState(child))
+		}
+	}
+	return s
+}
+
+func (h *LevelsHandler) state() *LevelsState {
+	l := h.get()
+	def, levels := l.GetLevels()
+	s := &LevelsState{
+		Default: def.String(),
+		Levels:  make(map[string]string, len(levels)),
+	}
+	for scope, level := range levels {
+		s.Levels[scope] = level.String()
+	}
+	if b := l.Store(); b != nil {
+		s.Backends = []BackendState{backendState(b)}
+	}
+	return s
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func (h *LevelsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	scope := strings.Trim(r.URL.Path, "/")
+	switch r.Method {
+	case "GET", "HEAD":
+		s := h.state()
+		if scope == "" {
+			writeJSON(w, http.StatusOK, s)
+			return
+		}
+		if scope == "all" {
+			writeJSON(w, http.StatusOK, map[string]string{"scope": scope, "level": s.Default})
+			return
+		}
+		level, found := s.Levels[scope]
+		if !found {
+			writeError(w, http.StatusNotFound, e.New("scope %v not found", scope))
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"scope": scope, "level": level})
+	case "PUT", "POST":
+		if scope == "" {
+			scope = "all"
+		}
+		name := r.FormValue("level")
+		if name == "" {
+			buf, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, 1024))
+			if err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+			name = strings.TrimSpace(string(buf))
+		}
+		var level Level
+		err := level.Set(name)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, e.New("invalid level %v", name))
+			return
+		}
+		h.get().SetLevel(scope, level)
+		writeJSON(w, http.StatusOK, h.state())
+	case "DELETE":
+		if scope == "" {
+			writeError(w, http.StatusBadRequest, e.New("scope is missing"))
+			return
+		}
+		l := h.get()
+		if scope != "all" {
+			_, levels := l.GetLevels()
+			if _, found := levels[scope]; !found {
+				writeError(w, http.StatusNotFound, e.New("scope %v not found", scope))
+				return
+			}
+		}
+		l.UnsetLevel(scope)
+		writeJSON(w, http.StatusOK, h.state())
+	default:
+		w.Header().Set("Allow", "GET, HEAD, PUT, POST, DELETE")
+		writeError(w, http.StatusMethodNotAllowed, e.New("method %v not allowed", r.Method))
+	}
+}
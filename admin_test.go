@@ -0,0 +1,149 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/fcavani/e"
+)
+
+func adminRequest(t *testing.T, method, url, body string, status int) *LevelsState {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	defer resp.Body.Close()
+	buf, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	if resp.StatusCode != status {
+		t.Fatalf("%v %v: wrong status %v: %s", method, url, resp.StatusCode, buf)
+	}
+	state := new(LevelsState)
+	err = json.Unmarshal(buf, state)
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	return state
+}
+
+func TestLevelsHandler(t *testing.T) {
+	buf := bytes.NewBuffer([]byte{})
+	var lck sync.Mutex
+	m, _ := NewMap(100)
+	logger := New(
+		NewMulti(
+			NewWriter(&lockedWriter{w: buf, lck: &lck}), DefFormatter,
+			NewOutBuffer(NewGeneric(m), 10), DefFormatter,
+		),
+		false,
+	).SetLevel("all", InfoPrio)
+
+	mux := http.NewServeMux()
+	mux.Handle("/debug/levels/", http.StripPrefix("/debug/levels", NewLevelsHandler(logger)))
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	url := ts.URL + "/debug/levels/"
+
+	state := adminRequest(t, "GET", url, "", http.StatusOK)
+	if state.Default != "info" || len(state.Levels) != 0 {
+		t.Fatalf("wrong state %+v", state)
+	}
+	if len(state.Backends) != 1 || state.Backends[0].Type != "*log.MultiLog" ||
+		state.Backends[0].Filter != "select { default => level >= info }" ||
+		len(state.Backends[0].Backends) != 2 ||
+		state.Backends[0].Backends[1].Backends[0].Type != "*log.Generic" {
+		t.Fatalf("wrong backends %+v", state.Backends)
+	}
+
+	// Change the levels while logging.
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					logger.DebugLevel().Println("debug")
+				}
+			}
+		}()
+	}
+	state = adminRequest(t, "PUT", url+"github.com/us/db", "debug", http.StatusOK)
+	if state.Levels["github.com/us/db"] != "debug" {
+		t.Fatalf("wrong state %+v", state)
+	}
+	state = adminRequest(t, "PUT", url+"all?level=error", "", http.StatusOK)
+	if state.Default != "error" {
+		t.Fatalf("wrong state %+v", state)
+	}
+	close(stop)
+	wg.Wait()
+
+	def, levels := logger.GetLevels()
+	if def != ErrorPrio || levels["github.com/us/db"] != DebugPrio {
+		t.Fatal("levels not changed", def, levels)
+	}
+
+	req, err := http.NewRequest("GET", url+"github.com/us/db", nil)
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	var scope map[string]string
+	err = json.NewDecoder(resp.Body).Decode(&scope)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	if scope["level"] != "debug" {
+		t.Fatal("wrong scope", scope)
+	}
+
+	adminRequest(t, "PUT", url+"all", "loud", http.StatusBadRequest)
+	adminRequest(t, "GET", url+"nothing", "", http.StatusNotFound)
+	adminRequest(t, "DELETE", url+"nothing", "", http.StatusNotFound)
+	adminRequest(t, "PATCH", url, "", http.StatusMethodNotAllowed)
+
+	state = adminRequest(t, "DELETE", url+"github.com/us/db", "", http.StatusOK)
+	if len(state.Levels) != 0 {
+		t.Fatalf("wrong state %+v", state)
+	}
+	state = adminRequest(t, "DELETE", url+"all", "", http.StatusOK)
+	if state.Default != "protocol" {
+		t.Fatalf("wrong state %+v", state)
+	}
+}
+
+type lockedWriter struct {
+	w   *bytes.Buffer
+	lck *sync.Mutex
+}
+
+func (l *lockedWriter) Write(p []byte) (int, error) {
+	l.lck.Lock()
+	defer l.lck.Unlock()
+	return l.w.Write(p)
+}
@@ -0,0 +1,187 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fcavani/e"
+)
+
+// subscriber is one channel of the Broadcast.
+type subscriber struct {
+	r  ruleHolder
+	ch chan Entry
+}
+
+// Broadcast sends copies of the entries to the subscribers and commits
+// the entries to another backend, if there is one. The subscribers never
+// block the commit, the entries are dropped if the channel is full.
+type Broadcast struct {
+	bak     LogBackend
+	f       Formatter
+	r       ruleHolder
+	dropped uint64
+
+	lck    sync.RWMutex
+	subs   map[*subscriber]struct{}
+	closed bool
+}
+
+// NewBroadcast creates a Broadcast that commits the entries to bak. bak
+// can be nil.
+func NewBroadcast(bak LogBackend) *Broadcast {
+	return &Broadcast{
+		bak:  bak,
+		subs: make(map[*subscriber]struct{}),
+	}
+}
+
+func (b *Broadcast) F(f Formatter) LogBackend {
+	if b.bak != nil {
+		b.bak.F(f)
+		return b
+	}
+	b.lck.Lock()
+	defer b.lck.Unlock()
+	b.f = f
+	return b
+}
+
+func (b *Broadcast) GetF() Formatter {
+	if b.bak != nil {
+		return b.bak.GetF()
+	}
+	b.lck.RLock()
+	defer b.lck.RUnlock()
+	return b.f
+}
+
+func (b *Broadcast) Filter(r Ruler) LogBackend {
+	b.r.Set(precompile(r))
+	return b
+}
+
+func (b *Broadcast) GetFilter() Ruler {
+	return b.r.Get()
+}
+
+// Backends returns the backend that receives the entries.
+func (b *Broadcast) Backends() []LogBackend {
+	if b.bak == nil {
+		return nil
+	}
+	return []LogBackend{b.bak}
+}
+
+// Subscribe returns a channel with the entries that pass r, nil is all
+// entries, and a function that cancels the subscription and closes the
+// channel. buffer is the size of the channel. The entries of the logger
+// are copies, with the formatter of the Broadcast, and can be kept by the
+// subscriber, other implementations of Entry aren't copied and are shared
+// with the backend.
+func (b *Broadcast) Subscribe(r Ruler, buffer int) (<-chan Entry, func()) {
+	if buffer < 0 {
+		buffer = 0
+	}
+	sub := &subscriber{
+		ch: make(chan Entry, buffer),
+	}
+	sub.r.Set(precompile(r))
+	b.lck.Lock()
+	defer b.lck.Unlock()
+	if b.closed {
+		close(sub.ch)
+		return sub.ch, func() {}
+	}
+	b.subs[sub] = struct{}{}
+	return sub.ch, func() {
+		b.lck.Lock()
+		defer b.lck.Unlock()
+		if _, ok := b.subs[sub]; !ok {
+			return
+		}
+		delete(b.subs, sub)
+		close(sub.ch)
+	}
+}
+
+// Dropped returns the number of entries not delivered because the
+// channel of the subscriber was full.
+func (b *Broadcast) Dropped() uint64 {
+	return atomic.LoadUint64(&b.dropped)
+}
+
+func (b *Broadcast) Commit(entry Entry) {
+	if !b.r.Pass(entry) {
+		broadcastMetrics.filter()
+		return
+	}
+	defer broadcastMetrics.observe(time.Now(), true)
+	f := b.GetF()
+	b.lck.RLock()
+	for sub := range b.subs {
+		if !sub.r.Pass(entry) {
+			continue
+		}
+		if cap(sub.ch) > 0 && len(sub.ch) == cap(sub.ch) {
+			// Don't copy the entries that will be dropped.
+			atomic.AddUint64(&b.dropped, 1)
+			broadcastMetrics.drop()
+			continue
+		}
+		select {
+		case sub.ch <- copyFormatted(entry, f):
+		default:
+			atomic.AddUint64(&b.dropped, 1)
+			broadcastMetrics.drop()
+		}
+	}
+	b.lck.RUnlock()
+	if b.bak != nil {
+		b.bak.Commit(entry)
+	}
+}
+
+// copyFormatted copies the entry with the formatter f, if it isn't nil,
+// so the subscribers can format the copy. The copies without a formatter
+// get DefFormatter.
+func copyFormatted(entry Entry, f Formatter) Entry {
+	l, ok := copyEntry(entry).(*log)
+	if !ok {
+		return entry
+	}
+	if f != nil {
+		l.f = f
+	} else if l.f == nil {
+		l.f = DefFormatter
+	}
+	return l
+}
+
+// Close closes the channels of the subscribers and the backend.
+func (b *Broadcast) Close() error {
+	b.lck.Lock()
+	if b.closed {
+		b.lck.Unlock()
+		return nil
+	}
+	b.closed = true
+	for sub := range b.subs {
+		close(sub.ch)
+	}
+	b.subs = make(map[*subscriber]struct{})
+	b.lck.Unlock()
+	if b.bak == nil {
+		return nil
+	}
+	err := b.bak.Close()
+	if err != nil {
+		return e.Forward(err)
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/fcavani/e"
+)
+
+func TestBroadcast(t *testing.T) {
+	buf := bytes.NewBuffer([]byte{})
+	b := NewBroadcast(NewWriter(buf).F(DefFormatter))
+	errs, cancel := b.Subscribe(Op(Eq, "level", ErrorPrio), 10)
+	all, _ := b.Subscribe(nil, 1)
+
+	logger := New(b, false).Domain("test")
+	logger.Print("one")
+	logger.Error("two")
+	logger.Print("three")
+
+	if n := strings.Count(buf.String(), "\n"); n != 3 {
+		t.Fatal("wrong number of entries committed", n)
+	}
+	entry := <-errs
+	if entry.Message() != "two" || entry.Level() != ErrorPrio || len(errs) != 0 {
+		t.Fatal("wrong entry", entry.Message())
+	}
+	// The copy has the formatter of the backend.
+	if line := entry.String(); !strings.Contains(buf.String(), line) {
+		t.Fatalf("wrong formatted entry %q", line)
+	}
+	entry = <-all
+	if entry.Message() != "one" || len(all) != 0 {
+		t.Fatal("wrong entry", entry.Message())
+	}
+	if n := b.Dropped(); n != 2 {
+		t.Fatal("wrong number of dropped entries", n)
+	}
+
+	cancel()
+	cancel()
+	if _, ok := <-errs; ok {
+		t.Fatal("channel not closed")
+	}
+	logger.Error("four")
+	if entry := <-all; entry.Message() != "four" {
+		t.Fatal("wrong entry", entry.Message())
+	}
+
+	err := b.Close()
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	if _, ok := <-all; ok {
+		t.Fatal("channel not closed")
+	}
+	ch, _ := b.Subscribe(nil, 1)
+	if _, ok := <-ch; ok {
+		t.Fatal("channel not closed")
+	}
+}
+
+func TestBroadcastF(t *testing.T) {
+	form, err := NewStdFormatter("::", "::msg", Log, map[string]interface{}{}, "")
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	w := NewWriter(bytes.NewBuffer([]byte{}))
+	b := NewBroadcast(w)
+	if b.F(form).GetF() != form || w.GetF() != form {
+		t.Fatal("formatter not forwarded")
+	}
+	b = NewBroadcast(nil)
+	if b.F(form).GetF() != form {
+		t.Fatal("formatter not set")
+	}
+}
+
+func TestBroadcastString(t *testing.T) {
+	form, err := NewStdFormatter("::", "::domain - ::msg", Log, map[string]interface{}{}, "")
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	b := NewBroadcast(nil)
+	ch, _ := b.Subscribe(nil, 2)
+	logger := New(b, false).Domain("test")
+	logger.Print("one")
+	b.F(form)
+	logger.Print("two")
+	if entry := <-ch; !strings.Contains(entry.String(), "one") {
+		t.Fatalf("wrong entry %q", entry.String())
+	}
+	if entry := <-ch; entry.String() != "test - two" {
+		t.Fatalf("wrong entry %q", entry.String())
+	}
+}
@@ -0,0 +1,196 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+// Logd receives the entries of the Network backends of many processes
+// and writes them to one store.
+//
+//	logd -listen tcp://:5140,unix:///var/run/logd.sock -store bolt -path /var/log/logd.db -http :5141
+//
+// The processes send to logd with the Network backend:
+//
+//	bak, err := log.NewNetwork("tcp", "localhost:5140", log.NetworkOptions{Sender: "myapp"})
+//
+// The entries are validated, the duplicated ids are dropped and the gaps
+// in the sequence of each sender are reported. With the json encoding the
+// numbers of the fields are stored as float64, gob keeps their types. The health of the server
+// is in /health and the metrics in /debug/vars of the http address.
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"expvar"
+	"flag"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/fcavani/e"
+	"github.com/fcavani/log"
+	"github.com/fcavani/types"
+)
+
+var (
+	listen     = flag.String("listen", "tcp://:5140", "comma separated addresses, like tcp://:5140 or unix:///var/run/logd.sock")
+	encoding   = flag.String("encoding", "json", "encoding of the entries: json or gob, json decodes the numbers of the fields as float64")
+	store      = flag.String("store", "bolt", "store of the entries: bolt, mongo or map")
+	path       = flag.String("path", "logd.db", "file of the bolt store")
+	bucket     = flag.String("bucket", "log", "bucket of the bolt store")
+	mongo      = flag.String("mongo", "mongodb://localhost/log", "url of the mongo store")
+	collection = flag.String("collection", "log", "collection of the mongo store")
+	batch      = flag.Int("batch", 0, "number of entries written in one transaction, zero disables the batch")
+	latency    = flag.Duration("latency", time.Second, "max time one entry waits in the batch")
+	dedup      = flag.Int("dedup", 100000, "number of ids remembered to drop the duplicated entries")
+	httpAddr   = flag.String("http", ":5141", "address of the health endpoint, empty disables it")
+	certFile   = flag.String("cert", "", "certificate of the tcp listeners, enables tls")
+	keyFile    = flag.String("key", "", "key of the certificate")
+	caFile     = flag.String("ca", "", "certificates of the clients, enables mutual tls")
+)
+
+func codec(name string) (log.Encoder, log.Decoder, error) {
+	switch name {
+	case "json":
+		c := &log.JSON{TypeName: types.Name(log.Log)}
+		return c, c, nil
+	case "gob":
+		c := &log.Gob{TypeName: types.Name(log.Log)}
+		return c, c, nil
+	}
+	return nil, nil, e.New("invalid encoding %v", name)
+}
+
+func openStore(name string) (log.Storer, error) {
+	switch name {
+	case "bolt":
+		// The store always uses gob, like the others users of BoltDb.
+		enc, dec, _ := codec("gob")
+		return log.NewBoltDb(*bucket, *path, 0600, nil, enc, dec)
+	case "mongo":
+		return log.NewMongoDb(*mongo, *collection, nil, log.Log, 30*time.Second)
+	case "map":
+		return log.NewMap(0)
+	}
+	return nil, e.New("invalid store %v", name)
+}
+
+func tlsConfig() (*tls.Config, error) {
+	if *certFile == "" {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(*certFile, *keyFile)
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+	}
+	if *caFile != "" {
+		buf, err := ioutil.ReadFile(*caFile)
+		if err != nil {
+			return nil, e.Forward(err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(buf) {
+			return nil, e.New("no certificates in %v", *caFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+// listenAddr listens in addr, in the form network://address.
+func listenAddr(addr string, config *tls.Config) (net.Listener, error) {
+	parts := strings.SplitN(addr, "://", 2)
+	if len(parts) != 2 {
+		return nil, e.New("invalid address %v", addr)
+	}
+	network, address := parts[0], parts[1]
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+		if config != nil {
+			return tls.Listen(network, address, config)
+		}
+	case "unix":
+		// Remove the socket of a previous run.
+		if fi, err := os.Stat(address); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			os.Remove(address)
+		}
+	default:
+		return nil, e.New("invalid network %v", network)
+	}
+	return net.Listen(network, address)
+}
+
+func main() {
+	flag.Parse()
+
+	_, dec, err := codec(*encoding)
+	if err != nil {
+		log.Fatal(err)
+	}
+	config, err := tlsConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	s, err := openStore(*store)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var bak log.LogBackend
+	if *batch > 0 {
+		bak, err = log.NewGenericBatch(s, log.BatchOptions{Size: *batch, Latency: *latency})
+		if err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		bak = log.NewGeneric(s)
+	}
+	bak.F(log.DefFormatter)
+
+	srv, err := NewServer(bak, dec, log.FramingAuto, *dedup)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for _, addr := range strings.Split(*listen, ",") {
+		l, err := listenAddr(strings.TrimSpace(addr), config)
+		if err != nil {
+			log.Fatal(err)
+		}
+		go func(l net.Listener) {
+			err := srv.Serve(l)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}(l)
+		log.Tag("logd").Println("Listening in", addr)
+	}
+
+	if *httpAddr != "" {
+		http.Handle("/health", srv)
+		expvar.Publish("logd", expvar.Func(func() interface{} {
+			return srv.Health()
+		}))
+		go func() {
+			err := http.ListenAndServe(*httpAddr, nil)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}()
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	<-sig
+	err = srv.Close()
+	if err != nil {
+		log.Fatal(err)
+	}
+}
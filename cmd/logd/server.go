@@ -0,0 +1,395 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fcavani/e"
+	"github.com/fcavani/log"
+)
+
+// recent is a bounded set of the last ids received.
+type recent struct {
+	lck  sync.Mutex
+	ids  map[string]struct{}
+	ring []string
+	pos  int
+}
+
+func newRecent(size int) *recent {
+	return &recent{
+		ids:  make(map[string]struct{}, size),
+		ring: make([]string, size),
+	}
+}
+
+// has returns true if id is in the set.
+func (r *recent) has(id string) bool {
+	r.lck.Lock()
+	defer r.lck.Unlock()
+	_, ok := r.ids[id]
+	return ok
+}
+
+// add puts id in the set, the oldest id is forgotten if it is full.
+func (r *recent) add(id string) {
+	if len(r.ring) == 0 {
+		return
+	}
+	r.lck.Lock()
+	defer r.lck.Unlock()
+	if _, ok := r.ids[id]; ok {
+		return
+	}
+	delete(r.ids, r.ring[r.pos])
+	r.ring[r.pos] = id
+	r.ids[id] = struct{}{}
+	r.pos = (r.pos + 1) % len(r.ring)
+}
+
+// remove takes id out of the set and returns true if it was there.
+func (r *recent) remove(id string) bool {
+	r.lck.Lock()
+	defer r.lck.Unlock()
+	if _, ok := r.ids[id]; !ok {
+		return false
+	}
+	delete(r.ids, id)
+	for i := range r.ring {
+		if r.ring[i] == id {
+			r.ring[i] = ""
+		}
+	}
+	return true
+}
+
+// SenderStats are the counters of one sender process.
+type SenderStats struct {
+	Sender string `json:"sender"`
+	Node   string `json:"node"`
+	// Next is the sequence number of the next entry expected.
+	Next uint64 `json:"next"`
+	// Lost is the number of entries never received.
+	Lost     uint64    `json:"lost"`
+	Received uint64    `json:"received"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// Health is the response of the health endpoint.
+type Health struct {
+	Status      string        `json:"status"`
+	Uptime      string        `json:"uptime"`
+	Connections int           `json:"connections"`
+	Received    uint64        `json:"received"`
+	Stored      uint64        `json:"stored"`
+	Duplicated  uint64        `json:"duplicated"`
+	Invalid     uint64        `json:"invalid"`
+	Failed      uint64        `json:"failed"`
+	Lost        uint64        `json:"lost"`
+	Senders     []SenderStats `json:"senders"`
+}
+
+// failureWindow is how long the server is unhealthy after an error of the
+// store.
+const failureWindow = time.Minute
+
+// Server receives the entries of the Network backends and commits them to
+// a backend.
+type Server struct {
+	bak     log.LogBackend
+	dec     log.Decoder
+	framing log.Framing
+	dedup   *recent
+	start   time.Time
+	// pending are the ids being committed, true if the commit failed.
+	pending map[string]bool
+
+	received   uint64
+	stored     uint64
+	duplicated uint64
+	invalid    uint64
+	failed     uint64
+
+	lck         sync.Mutex
+	lastFailure time.Time
+	senders     map[string]*SenderStats
+	listeners   map[net.Listener]struct{}
+	conns       map[net.Conn]struct{}
+	closed      bool
+	wg          sync.WaitGroup
+}
+
+// NewServer creates a server that commits the entries to bak. dec and
+// framing are the same of the backends of the senders and dedup is the
+// number of ids remembered to drop the duplicated entries. Only the
+// entries committed without errors are remembered, so a resend after a
+// failure is stored. The JSON decoder, without a type for the fields,
+// decodes the numbers of the fields as float64, use gob to keep the types.
+func NewServer(bak log.LogBackend, dec log.Decoder, framing log.Framing, dedup int) (*Server, error) {
+	if bak == nil || dedup < 0 {
+		return nil, e.New("invalid server options")
+	}
+	s := &Server{
+		bak:       bak,
+		dec:       dec,
+		framing:   framing,
+		dedup:     newRecent(dedup),
+		pending:   make(map[string]bool),
+		start:     time.Now(),
+		senders:   make(map[string]*SenderStats),
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[net.Conn]struct{}),
+	}
+	if setter, ok := bak.(log.ErrorHandlerSetter); ok {
+		next := setter.GetErrorHandler()
+		setter.SetErrorHandler(log.ErrorHandlerFunc(func(b log.LogBackend, entry log.Entry, err error) {
+			atomic.AddUint64(&s.failed, 1)
+			s.lck.Lock()
+			s.lastFailure = time.Now()
+			if entry != nil {
+				s.unstore(entry.ID())
+			}
+			s.lck.Unlock()
+			h := next
+			if h == nil {
+				h = log.GetErrorHandler()
+			}
+			h.HandleError(b, entry, err)
+		}))
+	}
+	return s, nil
+}
+
+// Serve accepts the connections of l until the server is closed.
+func (s *Server) Serve(l net.Listener) error {
+	s.lck.Lock()
+	if s.closed {
+		s.lck.Unlock()
+		return e.New("server closed")
+	}
+	s.listeners[l] = struct{}{}
+	s.lck.Unlock()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.lck.Lock()
+			closed := s.closed
+			delete(s.listeners, l)
+			s.lck.Unlock()
+			if closed {
+				return nil
+			}
+			return e.Forward(err)
+		}
+		s.lck.Lock()
+		if s.closed {
+			s.lck.Unlock()
+			conn.Close()
+			return nil
+		}
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.lck.Unlock()
+		go s.handle(conn)
+	}
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer func() {
+		conn.Close()
+		s.lck.Lock()
+		delete(s.conns, conn)
+		s.lck.Unlock()
+		s.wg.Done()
+	}()
+	r := log.NewNetworkReader(conn, s.dec, s.framing)
+	for {
+		entry, seq, err := r.Next()
+		if err == io.EOF {
+			return
+		} else if err != nil && e.Equal(err, log.ErrInvalidEntry) {
+			atomic.AddUint64(&s.invalid, 1)
+			s.sequence(r.Hello(), seq)
+			continue
+		} else if err != nil {
+			if !s.isClosed() {
+				log.Tag("logd").Errorf("Connection from %v failed: %v", conn.RemoteAddr(), err)
+			}
+			return
+		}
+		atomic.AddUint64(&s.received, 1)
+		s.sequence(r.Hello(), seq)
+		err = validate(entry)
+		if err != nil {
+			atomic.AddUint64(&s.invalid, 1)
+			continue
+		}
+		if !s.begin(entry.ID()) {
+			atomic.AddUint64(&s.duplicated, 1)
+			continue
+		}
+		s.bak.Commit(entry)
+		s.end(entry.ID())
+	}
+}
+
+// begin marks id as pending and returns false if it is a duplicate.
+func (s *Server) begin(id string) bool {
+	s.lck.Lock()
+	defer s.lck.Unlock()
+	if _, ok := s.pending[id]; ok || s.dedup.has(id) {
+		return false
+	}
+	s.pending[id] = false
+	return true
+}
+
+// end counts id as stored and remembers it, unless its commit failed.
+func (s *Server) end(id string) {
+	s.lck.Lock()
+	defer s.lck.Unlock()
+	failed := s.pending[id]
+	delete(s.pending, id)
+	if failed {
+		return
+	}
+	s.dedup.add(id)
+	atomic.AddUint64(&s.stored, 1)
+}
+
+// unstore is called with the lock held when the commit of id fails. The
+// backends with batches fail after the Commit returns, then the entry
+// was already counted as stored.
+func (s *Server) unstore(id string) {
+	if _, ok := s.pending[id]; ok {
+		s.pending[id] = true
+		return
+	}
+	if s.dedup.remove(id) {
+		atomic.AddUint64(&s.stored, ^uint64(0))
+	}
+}
+
+// validate checks the entries received.
+func validate(entry log.Entry) error {
+	t, _, _, err := log.ParseID(entry.ID())
+	if err != nil {
+		return e.Forward(err)
+	}
+	if !t.Equal(entry.Date().UTC()) {
+		return e.New("date of the entry doesn't match the id")
+	}
+	if entry.Level() < log.ProtoPrio || entry.Level() > log.NoPrio {
+		return e.New("invalid level")
+	}
+	return nil
+}
+
+// sequence checks the sequence number of the entries of one sender. The
+// entries sent again after a reconnection have numbers lower than the
+// next expected, the gaps are the entries lost. The first hello of a sender
+// sets the next number expected, the sender may have started before logd.
+func (s *Server) sequence(hello *log.NetworkHello, seq uint64) {
+	if hello == nil {
+		return
+	}
+	key := hello.Sender + "/" + hello.Node
+	s.lck.Lock()
+	defer s.lck.Unlock()
+	st, ok := s.senders[key]
+	if !ok {
+		st = &SenderStats{
+			Sender: hello.Sender,
+			Node:   hello.Node,
+			Next:   hello.Seq,
+		}
+		s.senders[key] = st
+	}
+	st.Received++
+	st.LastSeen = time.Now()
+	if seq > st.Next {
+		lost := seq - st.Next
+		st.Lost += lost
+		log.Tag("logd", "gap").Errorf("Lost %v entries of %v (%v), from %v to %v.", lost, hello.Sender, hello.Node, st.Next, seq-1)
+	}
+	if seq >= st.Next {
+		st.Next = seq + 1
+	}
+}
+
+func (s *Server) isClosed() bool {
+	s.lck.Lock()
+	defer s.lck.Unlock()
+	return s.closed
+}
+
+// Health returns the state of the server.
+func (s *Server) Health() Health {
+	s.lck.Lock()
+	defer s.lck.Unlock()
+	h := Health{
+		Status:      "ok",
+		Uptime:      time.Since(s.start).String(),
+		Connections: len(s.conns),
+		Received:    atomic.LoadUint64(&s.received),
+		Stored:      atomic.LoadUint64(&s.stored),
+		Duplicated:  atomic.LoadUint64(&s.duplicated),
+		Invalid:     atomic.LoadUint64(&s.invalid),
+		Failed:      atomic.LoadUint64(&s.failed),
+		Senders:     make([]SenderStats, 0, len(s.senders)),
+	}
+	if !s.lastFailure.IsZero() && time.Since(s.lastFailure) < failureWindow {
+		h.Status = "failing"
+	}
+	if s.closed {
+		h.Status = "closed"
+	}
+	for _, st := range s.senders {
+		h.Lost += st.Lost
+		h.Senders = append(h.Senders, *st)
+	}
+	return h
+}
+
+// ServeHTTP serves the health of the server in JSON. The status code is
+// 503 if the store failed recently.
+func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	h := s.Health()
+	w.Header().Set("Content-Type", "application/json")
+	if h.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(h)
+}
+
+// Close stops the listeners, closes the connections and the backend.
+func (s *Server) Close() error {
+	s.lck.Lock()
+	if s.closed {
+		s.lck.Unlock()
+		return nil
+	}
+	s.closed = true
+	for l := range s.listeners {
+		l.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.lck.Unlock()
+	s.wg.Wait()
+	err := s.bak.Close()
+	if err != nil {
+		return e.Forward(err)
+	}
+	return nil
+}
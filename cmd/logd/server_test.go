@@ -0,0 +1,274 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fcavani/e"
+	"github.com/fcavani/log"
+)
+
+func testServer(t *testing.T) (*Server, log.Storer, net.Listener) {
+	s, err := log.NewMap(0)
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	srv, err := NewServer(log.NewGeneric(s).F(log.DefFormatter), nil, log.FramingAuto, 100)
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	go srv.Serve(l)
+	return srv, s, l
+}
+
+func waitStored(t *testing.T, srv *Server, n uint64) Health {
+	for i := 0; ; i++ {
+		h := srv.Health()
+		if h.Stored+h.Duplicated+h.Invalid+h.Failed >= n {
+			return h
+		}
+		if i > 500 {
+			t.Fatalf("timeout waiting the entries %+v", h)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestServer(t *testing.T) {
+	srv, s, l := testServer(t)
+	defer srv.Close()
+
+	bak, err := log.NewNetwork("tcp", l.Addr().String(), log.NetworkOptions{Sender: "test"})
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	logger := log.New(bak, false).Domain("test")
+	for i := 0; i < 10; i++ {
+		logger.Print(i)
+	}
+	err = bak.Close()
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	h := waitStored(t, srv, 10)
+	if h.Stored != 10 || h.Received != 10 || h.Lost != 0 || len(h.Senders) != 1 {
+		t.Fatalf("wrong health %+v", h)
+	}
+	if st := h.Senders[0]; st.Sender != "test" || st.Next != 11 || st.Received != 10 {
+		t.Fatalf("wrong sender %+v", st)
+	}
+	n, err := s.Len()
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	if n != 10 {
+		t.Fatal("wrong number of entries", n)
+	}
+
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest("GET", "/health", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatal("wrong status", rec.Code)
+	}
+	var got Health
+	err = json.Unmarshal(rec.Body.Bytes(), &got)
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	if got.Status != "ok" || got.Stored != 10 {
+		t.Fatalf("wrong health %+v", got)
+	}
+}
+
+// lastEntry keeps the last entry committed.
+type lastEntry struct {
+	entry log.Entry
+}
+
+func (l *lastEntry) Commit(entry log.Entry)            { l.entry = entry }
+func (l *lastEntry) F(f log.Formatter) log.LogBackend  { return l }
+func (l *lastEntry) GetF() log.Formatter               { return nil }
+func (l *lastEntry) Filter(r log.Ruler) log.LogBackend { return l }
+func (l *lastEntry) Close() error                      { return nil }
+
+func TestServerDupAndGaps(t *testing.T) {
+	srv, _, l := testServer(t)
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	defer conn.Close()
+	enc := &log.JSON{}
+	entry := func(msg string) string {
+		last := &lastEntry{}
+		log.New(last, false).Print(msg)
+		buf, err := enc.Encode(last.entry)
+		if err != nil {
+			t.Fatal(e.Trace(e.Forward(err)))
+		}
+		return string(buf)
+	}
+	one := entry("one")
+	fmt.Fprintln(conn, `{"sender":"test","node":"abcd","seq":1}`)
+	fmt.Fprintln(conn, one)
+	// Sent again after a reconnection.
+	fmt.Fprintln(conn, one)
+	fmt.Fprintln(conn, `{"msg":"no id"}`)
+	fmt.Fprintln(conn, `{"msg":1}`)
+	h := waitStored(t, srv, 4)
+	if h.Stored != 1 || h.Duplicated != 1 || h.Invalid != 2 || h.Lost != 0 {
+		t.Fatalf("wrong health %+v", h)
+	}
+
+	// The entries 5 to 9 were lost.
+	conn2, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	defer conn2.Close()
+	fmt.Fprintln(conn2, `{"sender":"test","node":"abcd","seq":10}`)
+	fmt.Fprintln(conn2, entry("two"))
+	h = waitStored(t, srv, 5)
+	if h.Stored != 2 || h.Lost != 5 || len(h.Senders) != 1 || h.Senders[0].Next != 11 {
+		t.Fatalf("wrong health %+v", h)
+	}
+
+	// A sender seen for the first time, like one that was running before
+	// logd started, has no gap before its first entry.
+	conn3, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	defer conn3.Close()
+	fmt.Fprintln(conn3, `{"sender":"test","node":"efgh","seq":1000}`)
+	fmt.Fprintln(conn3, entry("three"))
+	h = waitStored(t, srv, 6)
+	if h.Stored != 3 || h.Lost != 5 || len(h.Senders) != 2 {
+		t.Fatalf("wrong health %+v", h)
+	}
+	for _, st := range h.Senders {
+		if st.Node == "efgh" && (st.Next != 1001 || st.Lost != 0) {
+			t.Fatalf("wrong sender %+v", st)
+		}
+	}
+}
+
+// failStore fails the first fail write transactions.
+type failStore struct {
+	log.Storer
+	fail   int32
+	writes int32
+}
+
+func (f *failStore) Tx(write bool, fn func(tx log.Transaction) error) error {
+	if write && atomic.AddInt32(&f.writes, 1) <= f.fail {
+		return e.New("write failed")
+	}
+	return f.Storer.Tx(write, fn)
+}
+
+func waitHealth(t *testing.T, srv *Server, ok func(h Health) bool) Health {
+	for i := 0; ; i++ {
+		h := srv.Health()
+		if ok(h) {
+			return h
+		}
+		if i > 500 {
+			t.Fatalf("timeout waiting the health %+v", h)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestServerFailure(t *testing.T) {
+	tests := []struct {
+		fail   int32
+		newBak func(s log.Storer) (log.LogBackend, error)
+	}{
+		{1, func(s log.Storer) (log.LogBackend, error) {
+			return log.NewGeneric(s), nil
+		}},
+		// The batch fails after the Commit returns, the batch and the
+		// entry alone.
+		{2, func(s log.Storer) (log.LogBackend, error) {
+			return log.NewGenericBatch(s, log.BatchOptions{Size: 10, Latency: 10 * time.Millisecond})
+		}},
+	}
+	for i, test := range tests {
+		s, err := log.NewMap(0)
+		if err != nil {
+			t.Fatal(e.Trace(e.Forward(err)))
+		}
+		bak, err := test.newBak(&failStore{Storer: s, fail: test.fail})
+		if err != nil {
+			t.Fatal(e.Trace(e.Forward(err)))
+		}
+		bak.F(log.DefFormatter)
+		bak.(log.ErrorHandlerSetter).SetErrorHandler(log.ErrorHandlerFunc(func(log.LogBackend, log.Entry, error) {}))
+		testServerFailure(t, i, bak, s)
+	}
+}
+
+func testServerFailure(t *testing.T, i int, bak log.LogBackend, s log.Storer) {
+	srv, err := NewServer(bak, nil, log.FramingAuto, 100)
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	defer srv.Close()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	go srv.Serve(l)
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	defer conn.Close()
+	last := &lastEntry{}
+	log.New(last, false).Print("one")
+	buf, err := (&log.JSON{}).Encode(last.entry)
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	fmt.Fprintln(conn, string(buf))
+	waitHealth(t, srv, func(h Health) bool {
+		return h.Stored == 0 && h.Failed == 1
+	})
+	// The resend after the failure isn't a duplicate.
+	fmt.Fprintln(conn, string(buf))
+	h := waitHealth(t, srv, func(h Health) bool {
+		return h.Stored == 1
+	})
+	if h.Failed != 1 || h.Duplicated != 0 {
+		t.Fatalf("%v: wrong health %+v", i, h)
+	}
+	err = srv.Close()
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	n, err := s.Len()
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	if n != 1 {
+		t.Fatal("wrong number of entries", i, n)
+	}
+}
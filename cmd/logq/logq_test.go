@@ -0,0 +1,235 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fcavani/e"
+	"github.com/fcavani/log"
+	"github.com/fcavani/types"
+)
+
+// testStores writes the messages alternately to two bolt files.
+func testStores(t *testing.T, dir string, msgs ...string) []string {
+	gob := &log.Gob{TypeName: types.Name(log.Log)}
+	files := []string{filepath.Join(dir, "a.db"), filepath.Join(dir, "b.db")}
+	loggers := make([]log.Logger, len(files))
+	baks := make([]log.LogBackend, len(files))
+	for i, name := range files {
+		s, err := log.NewBoltDb("log", name, 0600, nil, gob, gob)
+		if err != nil {
+			t.Fatal(e.Trace(e.Forward(err)))
+		}
+		baks[i] = log.NewGeneric(s).F(log.DefFormatter)
+		loggers[i] = log.New(baks[i], false).Domain([]string{"testa", "testb"}[i])
+	}
+	for i, msg := range msgs {
+		l := loggers[i%len(loggers)]
+		if strings.HasPrefix(msg, "tagged") {
+			l = l.Tag("audit")
+		}
+		l.ErrorLevel().Print(msg)
+		time.Sleep(time.Millisecond)
+	}
+	for _, bak := range baks {
+		err := bak.Close()
+		if err != nil {
+			t.Fatal(e.Trace(e.Forward(err)))
+		}
+	}
+	return files
+}
+
+func runCSV(t *testing.T, c *config) []string {
+	c.bucket = "log"
+	c.format = "csv"
+	c.timeout = time.Second
+	buf := bytes.NewBuffer(nil)
+	err := run(c, buf)
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	records, err := csv.NewReader(buf).ReadAll()
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	msgs := make([]string, 0, len(records))
+	for i, r := range records {
+		if i == 0 {
+			continue
+		}
+		msgs = append(msgs, r[3]+":"+r[5])
+	}
+	return msgs
+}
+
+func TestLogq(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logq")
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	defer os.RemoveAll(dir)
+	files := testStores(t, dir, "one", "two", "tagged three", "four", "five")
+
+	tests := []struct {
+		c    config
+		want string
+	}{
+		{config{}, "testa:one testb:two testa:tagged three testb:four testa:five"},
+		{config{reverse: true, limit: 2}, "testa:five testb:four"},
+		{config{domain: "testb"}, "testb:two testb:four"},
+		{config{tags: "audit"}, "testa:tagged three"},
+		{config{grep: "^f"}, "testb:four testa:five"},
+		{config{rule: `msg == "two" || msg == "five"`}, "testb:two testa:five"},
+		{config{level: "fatal"}, ""},
+		{config{until: "1h"}, ""},
+	}
+	for i, test := range tests {
+		test.c.files = files
+		got := strings.Join(runCSV(t, &test.c), " ")
+		if got != test.want {
+			t.Fatalf("%v: got %q, want %q", i, got, test.want)
+		}
+	}
+}
+
+func TestLogqErrors(t *testing.T) {
+	for _, c := range []config{
+		{},
+		{files: []string{"/does/not/exist.db"}},
+		{mongo: "mongodb://localhost/log", level: "loud"},
+		{mongo: "mongodb://localhost/log", grep: "("},
+		{mongo: "mongodb://localhost/log", follow: true, reverse: true},
+		{mongo: "mongodb://localhost/log", since: "yesterday"},
+	} {
+		c.format = "csv"
+		err := run(&c, ioutil.Discard)
+		if err == nil {
+			t.Fatalf("nil error for %+v", c)
+		}
+	}
+}
+
+// stopWriter fails when the output has stop.
+type stopWriter struct {
+	lck  sync.Mutex
+	buf  bytes.Buffer
+	stop string
+}
+
+const errStop = "stop"
+
+func (w *stopWriter) Write(p []byte) (int, error) {
+	w.lck.Lock()
+	defer w.lck.Unlock()
+	w.buf.Write(p)
+	if strings.Contains(w.buf.String(), w.stop) {
+		return len(p), e.New(errStop)
+	}
+	return len(p), nil
+}
+
+func (w *stopWriter) String() string {
+	w.lck.Lock()
+	defer w.lck.Unlock()
+	return w.buf.String()
+}
+
+func TestLogqFollowBolt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logq")
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	defer os.RemoveAll(dir)
+	files := testStores(t, dir, "one", "two")
+	c := &config{
+		files:    files,
+		bucket:   "log",
+		format:   "csv",
+		follow:   true,
+		limit:    1,
+		interval: 10 * time.Millisecond,
+		timeout:  50 * time.Millisecond,
+	}
+	w := &stopWriter{stop: "three"}
+	done := make(chan error, 1)
+	go func() {
+		done <- run(c, w)
+	}()
+	for i := 0; !strings.Contains(w.String(), "two"); i++ {
+		if i > 500 {
+			t.Fatal("timeout waiting the last entry")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The writer locks the file for some queries.
+	gob := &log.Gob{TypeName: types.Name(log.Log)}
+	s, err := log.NewBoltDb("log", files[0], 0600, nil, gob, gob)
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	bak := log.NewGeneric(s).F(log.DefFormatter)
+	log.New(bak, false).Domain("testa").ErrorLevel().Print("three")
+	time.Sleep(3 * c.timeout)
+	err = bak.Close()
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+
+	select {
+	case err = <-done:
+		if e.Find(err, errStop) < 0 {
+			t.Fatal("wrong error", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting the new entry")
+	}
+	out := w.String()
+	if strings.Contains(out, "one") || strings.Index(out, "two") > strings.Index(out, "three") {
+		t.Fatalf("wrong output %q", out)
+	}
+}
+
+func TestFollowed(t *testing.T) {
+	entries := make([]log.Entry, 0, 3)
+	last := &lastEntry{}
+	logger := log.New(last, false)
+	for i := 0; i < 3; i++ {
+		logger.Print(i)
+		entries = append(entries, last.entry)
+	}
+	f := &followed{ids: make(map[string]struct{})}
+	for _, entry := range entries {
+		if !f.add(entry) {
+			t.Fatal("new entry not added")
+		}
+	}
+	for _, entry := range entries {
+		if f.add(entry) {
+			t.Fatal("entry added again")
+		}
+	}
+}
+
+// lastEntry keeps the last entry committed.
+type lastEntry struct {
+	entry log.Entry
+}
+
+func (l *lastEntry) Commit(entry log.Entry)            { l.entry = entry }
+func (l *lastEntry) F(f log.Formatter) log.LogBackend  { return l }
+func (l *lastEntry) GetF() log.Formatter               { return nil }
+func (l *lastEntry) Filter(r log.Ruler) log.LogBackend { return l }
+func (l *lastEntry) Close() error                      { return nil }
@@ -0,0 +1,333 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+// Logq reads the entries stored by the Generic backend. The entries of
+// many stores are merged by date.
+//
+//	logq -since 1h -level warning -domain api -grep timeout app1.db app2.db
+//	logq -mongo mongodb://localhost/log -format json -reverse -limit 10
+//	logq -follow -limit 20 -mongo mongodb://localhost/log
+//
+// The bolt files must be written with the Gob codec. BoltDb locks the
+// file while it is open to write, so the queries of a file in use wait up
+// to -timeout. -follow reopens the bolt files in each query, a file still
+// locked by its writer after -timeout is read in the next query.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/fcavani/e"
+	"github.com/fcavani/log"
+	"github.com/fcavani/types"
+)
+
+// config is the command line of logq.
+type config struct {
+	bucket     string
+	mongo      string
+	collection string
+	since      string
+	until      string
+	level      string
+	domain     string
+	tags       string
+	grep       string
+	rule       string
+	format     string
+	template   string
+	timeformat string
+	reverse    bool
+	follow     bool
+	interval   time.Duration
+	limit      int
+	timeout    time.Duration
+	files      []string
+}
+
+// parseTime parses s as a RFC3339 date or as a duration before now.
+func parseTime(s string, now time.Time) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return time.Time{}, e.New("invalid time %v", s)
+	}
+	return t, nil
+}
+
+// spec creates the query from the config.
+func (c *config) spec(now time.Time) (log.QuerySpec, error) {
+	var q log.QuerySpec
+	var err error
+	q.Start, err = parseTime(c.since, now)
+	if err != nil {
+		return q, e.Forward(err)
+	}
+	q.End, err = parseTime(c.until, now)
+	if err != nil {
+		return q, e.Forward(err)
+	}
+	rules := make([]log.Ruler, 0)
+	if c.level != "" {
+		level, err := log.ParseLevel(c.level)
+		if err != nil {
+			return q, e.Push(err, c.level)
+		}
+		rules = append(rules, log.Op(log.Ge, "level", level))
+	}
+	if c.domain != "" {
+		rules = append(rules, log.Op(log.Pr, "domain", c.domain))
+	}
+	for _, tag := range strings.Split(c.tags, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			rules = append(rules, log.Op(log.Ex, "tags", tag))
+		}
+	}
+	if c.grep != "" {
+		_, err := regexp.Compile(c.grep)
+		if err != nil {
+			return q, e.Forward(err)
+		}
+		rules = append(rules, log.Op(log.Re, "msg", c.grep))
+	}
+	if c.rule != "" {
+		r, err := log.ParseRule(c.rule)
+		if err != nil {
+			return q, e.Forward(err)
+		}
+		rules = append(rules, r)
+	}
+	switch len(rules) {
+	case 0:
+	case 1:
+		q.Filter = rules[0]
+	default:
+		q.Filter = log.And(rules...)
+	}
+	q.Limit = c.limit
+	if c.reverse {
+		q.Dir = log.RightToLeft
+	}
+	return q, nil
+}
+
+// sources creates the sources of the config.
+func (c *config) sources() ([]source, error) {
+	sources := make([]source, 0, len(c.files)+1)
+	gob := &log.Gob{TypeName: types.Name(log.Log)}
+	for _, path := range c.files {
+		path := path
+		if _, err := os.Stat(path); err != nil {
+			return nil, e.Forward(err)
+		}
+		sources = append(sources, func() (log.Storer, error) {
+			opts := &bolt.Options{ReadOnly: true, Timeout: c.timeout}
+			s, err := log.NewBoltDb(c.bucket, path, 0600, opts, gob, gob)
+			if err != nil {
+				return nil, e.Push(err, path)
+			}
+			return s, nil
+		})
+	}
+	if c.mongo != "" {
+		sources = append(sources, func() (log.Storer, error) {
+			return log.NewMongoDb(c.mongo, c.collection, nil, log.Log, c.timeout)
+		})
+	}
+	if len(sources) == 0 {
+		return nil, e.New("no stores")
+	}
+	return sources, nil
+}
+
+// followed are the entries already printed with the date of the last one.
+type followed struct {
+	last time.Time
+	ids  map[string]struct{}
+}
+
+// add returns false if the entry was already printed.
+func (f *followed) add(entry log.Entry) bool {
+	if entry.Date().Before(f.last) {
+		return false
+	}
+	if entry.Date().After(f.last) {
+		f.last = entry.Date()
+		f.ids = make(map[string]struct{})
+	}
+	if _, ok := f.ids[entry.ID()]; ok {
+		return false
+	}
+	f.ids[entry.ID()] = struct{}{}
+	return true
+}
+
+func printAll(it log.Iterator, p printer, f *followed) error {
+	defer it.Close()
+	for it.Next() {
+		if f != nil && !f.add(it.Entry()) {
+			continue
+		}
+		err := p.Print(it.Entry())
+		if err != nil {
+			return e.Forward(err)
+		}
+	}
+	err := it.Err()
+	if err != nil {
+		return e.Forward(err)
+	}
+	return p.Flush()
+}
+
+// tail returns the last limit entries in q, from the oldest to the newest.
+func tail(sources []source, q log.QuerySpec) (log.Iterator, error) {
+	q.Dir = log.RightToLeft
+	it, err := query(sources, q)
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	defer it.Close()
+	entries := make([]log.Entry, 0, q.Limit)
+	for it.Next() {
+		entries = append(entries, it.Entry())
+	}
+	if err := it.Err(); err != nil {
+		return nil, e.Forward(err)
+	}
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	return &sliceIter{entries: entries}, nil
+}
+
+// sliceIter iterates over entries already read.
+type sliceIter struct {
+	entries []log.Entry
+	cur     log.Entry
+}
+
+func (s *sliceIter) Next() bool {
+	if len(s.entries) == 0 {
+		s.cur = nil
+		return false
+	}
+	s.cur, s.entries = s.entries[0], s.entries[1:]
+	return true
+}
+
+func (s *sliceIter) Entry() log.Entry { return s.cur }
+func (s *sliceIter) Err() error       { return nil }
+func (s *sliceIter) Close() error     { return nil }
+
+// run prints the entries selected by c to w. With follow it only returns
+// on errors.
+func run(c *config, w io.Writer) error {
+	if c.follow && c.reverse {
+		return e.New("follow and reverse can't be used together")
+	}
+	q, err := c.spec(time.Now())
+	if err != nil {
+		return e.Forward(err)
+	}
+	sources, err := c.sources()
+	if err != nil {
+		return e.Forward(err)
+	}
+	p, err := newPrinter(w, c.format, c.template, c.timeformat)
+	if err != nil {
+		return e.Forward(err)
+	}
+	if !c.follow {
+		it, err := query(sources, q)
+		if err != nil {
+			return e.Forward(err)
+		}
+		return printAll(it, p, nil)
+	}
+	f := &followed{ids: make(map[string]struct{})}
+	tailed := q.Limit == 0
+	for {
+		var it log.Iterator
+		if !tailed {
+			it, err = tail(sources, q)
+		} else {
+			if !f.last.IsZero() {
+				q.Start = f.last
+			}
+			it, err = query(sources, q)
+		}
+		if err != nil && !c.locked(err) {
+			return e.Forward(err)
+		} else if err == nil {
+			tailed = true
+			q.Limit = 0
+			err = printAll(it, p, f)
+			if err != nil {
+				return e.Forward(err)
+			}
+		}
+		time.Sleep(c.interval)
+	}
+}
+
+// locked returns true if err is the timeout of a bolt file locked by its
+// writer.
+func (c *config) locked(err error) bool {
+	return len(c.files) > 0 && e.Find(err, bolt.ErrTimeout) >= 0
+}
+
+func main() {
+	c := &config{}
+	flag.StringVar(&c.bucket, "bucket", "log", "bucket of the bolt files")
+	flag.StringVar(&c.mongo, "mongo", "", "url of a mongo store")
+	flag.StringVar(&c.collection, "collection", "log", "collection of the mongo store")
+	flag.StringVar(&c.since, "since", "", "first date, in RFC3339 or a duration before now, like 1h")
+	flag.StringVar(&c.until, "until", "", "end date, in RFC3339 or a duration before now")
+	flag.StringVar(&c.level, "level", "", "minimum level, like warning")
+	flag.StringVar(&c.domain, "domain", "", "prefix of the domain")
+	flag.StringVar(&c.tags, "tags", "", "comma separated tags that the entries must have")
+	flag.StringVar(&c.grep, "grep", "", "regexp of the message")
+	flag.StringVar(&c.rule, "rule", "", "filter in the rule language, like 'level >= error && domain ^= \"api\"'")
+	flag.StringVar(&c.format, "format", "text", "output format: text, json or csv")
+	flag.StringVar(&c.template, "template", DefTemplate, "template of the text format")
+	flag.StringVar(&c.timeformat, "timeformat", "", "format of the dates")
+	flag.BoolVar(&c.reverse, "reverse", false, "from the newest to the oldest entry")
+	flag.BoolVar(&c.follow, "follow", false, "wait for new entries, with -limit starts with the last entries")
+	flag.DurationVar(&c.interval, "interval", time.Second, "interval between the queries of -follow")
+	flag.IntVar(&c.limit, "limit", 0, "max number of entries, zero is unlimited")
+	flag.DurationVar(&c.timeout, "timeout", 5*time.Second, "timeout to open the stores")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %v [flags] [bolt files]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	c.files = flag.Args()
+
+	out := bufio.NewWriter(os.Stdout)
+	var w io.Writer = out
+	if c.follow {
+		// Print the entries as they arrive.
+		w = os.Stdout
+	}
+	err := run(c, w)
+	out.Flush()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "logq:", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"container/heap"
+
+	"github.com/fcavani/e"
+	"github.com/fcavani/log"
+)
+
+// source opens one store. The store is opened for each query, so the bolt
+// files aren't locked between the queries of -follow.
+type source func() (log.Storer, error)
+
+// before returns true if a comes before b, or after if reverse is true.
+func before(a, b log.Entry, reverse bool) bool {
+	if reverse {
+		a, b = b, a
+	}
+	return a.Date().Before(b.Date()) || (a.Date().Equal(b.Date()) && a.ID() < b.ID())
+}
+
+// mergeIter merges the iterators of many stores by the date of the
+// entries.
+type mergeIter struct {
+	reverse bool
+	limit   int
+	n       int
+	its     []log.Iterator
+	stores  []log.Storer
+	cur     log.Entry
+	err     error
+}
+
+func (m *mergeIter) Len() int { return len(m.its) }
+func (m *mergeIter) Less(i, j int) bool {
+	return before(m.its[i].Entry(), m.its[j].Entry(), m.reverse)
+}
+func (m *mergeIter) Swap(i, j int) { m.its[i], m.its[j] = m.its[j], m.its[i] }
+func (m *mergeIter) Push(x interface{}) {
+	m.its = append(m.its, x.(log.Iterator))
+}
+func (m *mergeIter) Pop() interface{} {
+	it := m.its[len(m.its)-1]
+	m.its = m.its[:len(m.its)-1]
+	return it
+}
+
+// query runs q in all sources and merges the results. The limit of q is
+// applied to the merged entries.
+func query(sources []source, q log.QuerySpec) (log.Iterator, error) {
+	m := &mergeIter{
+		reverse: q.Dir == log.RightToLeft,
+	}
+	limit := q.Limit
+	// The offset only can be applied after the merge.
+	if q.Offset > 0 && q.Limit > 0 {
+		q.Limit += q.Offset
+	}
+	skip := q.Offset
+	q.Offset = 0
+	for _, open := range sources {
+		s, err := open()
+		if err != nil {
+			m.Close()
+			return nil, e.Forward(err)
+		}
+		m.stores = append(m.stores, s)
+		it, err := log.Query(s, q)
+		if err != nil {
+			m.Close()
+			return nil, e.Forward(err)
+		}
+		if !it.Next() {
+			err = it.Err()
+			it.Close()
+			if err != nil {
+				m.Close()
+				return nil, e.Forward(err)
+			}
+			continue
+		}
+		m.its = append(m.its, it)
+	}
+	heap.Init(m)
+	for ; skip > 0 && m.Next(); skip-- {
+	}
+	m.limit = limit
+	m.n = 0
+	return m, nil
+}
+
+func (m *mergeIter) Next() bool {
+	if m.cur != nil && len(m.its) > 0 {
+		// Advance the iterator of the current entry.
+		it := m.its[0]
+		if it.Next() {
+			heap.Fix(m, 0)
+		} else {
+			heap.Pop(m)
+			m.err = it.Err()
+			it.Close()
+		}
+	}
+	m.cur = nil
+	if m.err != nil || len(m.its) == 0 || (m.limit > 0 && m.n >= m.limit) {
+		return false
+	}
+	m.cur = m.its[0].Entry()
+	m.n++
+	return true
+}
+
+func (m *mergeIter) Entry() log.Entry {
+	return m.cur
+}
+
+func (m *mergeIter) Err() error {
+	return m.err
+}
+
+func (m *mergeIter) Close() error {
+	var err error
+	push := func(er error) {
+		if er == nil {
+			return
+		}
+		if err == nil {
+			err = e.Forward(er)
+			return
+		}
+		err = e.Push(err, er)
+	}
+	for _, it := range m.its {
+		push(it.Close())
+	}
+	m.its = nil
+	for _, s := range m.stores {
+		push(s.Close())
+	}
+	m.stores = nil
+	return err
+}
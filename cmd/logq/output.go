@@ -0,0 +1,111 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/csv"
+	"io"
+	"time"
+
+	"github.com/fcavani/e"
+	"github.com/fcavani/log"
+)
+
+// DefTemplate is the template of the text output. It is the DefTemplate
+// of the log without the host, that isn't stored in the entries.
+const DefTemplate = "::domain - ::date - ::level - ::tags - ::file - ::msg"
+
+// printer writes the entries to the output.
+type printer interface {
+	Print(entry log.Entry) error
+	Flush() error
+}
+
+// formatPrinter prints the entries with a Formatter.
+type formatPrinter struct {
+	w io.Writer
+	f log.Formatter
+}
+
+func (p *formatPrinter) Print(entry log.Entry) error {
+	buf, err := p.f.Format(entry)
+	if err != nil {
+		return e.Forward(err)
+	}
+	if len(buf) == 0 || buf[len(buf)-1] != '\n' {
+		buf = append(buf, '\n')
+	}
+	_, err = p.w.Write(buf)
+	if err != nil {
+		return e.Forward(err)
+	}
+	return nil
+}
+
+func (p *formatPrinter) Flush() error {
+	return nil
+}
+
+// csvHeader are the columns of the csv output.
+var csvHeader = []string{"id", "date", "level", "domain", "tags", "msg"}
+
+// csvPrinter prints the entries as csv, with a header.
+type csvPrinter struct {
+	w          *csv.Writer
+	timeformat string
+	header     bool
+}
+
+func (p *csvPrinter) Print(entry log.Entry) error {
+	if !p.header {
+		p.header = true
+		err := p.w.Write(csvHeader)
+		if err != nil {
+			return e.Forward(err)
+		}
+	}
+	err := p.w.Write([]string{
+		entry.ID(),
+		entry.Date().Format(p.timeformat),
+		entry.Level().String(),
+		entry.GetDomain(),
+		entry.Tags().String(),
+		entry.Message(),
+	})
+	if err != nil {
+		return e.Forward(err)
+	}
+	return nil
+}
+
+func (p *csvPrinter) Flush() error {
+	p.w.Flush()
+	return p.w.Error()
+}
+
+// newPrinter creates the printer for format: text, json or csv. tmpl is
+// the template of the text format.
+func newPrinter(w io.Writer, format, tmpl, timeformat string) (printer, error) {
+	switch format {
+	case "text":
+		f, err := log.NewStdFormatter("::", tmpl, log.Log, map[string]interface{}{}, timeformat)
+		if err != nil {
+			return nil, e.Forward(err)
+		}
+		return &formatPrinter{w: w, f: f}, nil
+	case "json":
+		f, err := log.NewJSONFormatter(log.Log, nil, timeformat)
+		if err != nil {
+			return nil, e.Forward(err)
+		}
+		return &formatPrinter{w: w, f: f}, nil
+	case "csv":
+		if timeformat == "" {
+			timeformat = time.RFC3339Nano
+		}
+		return &csvPrinter{w: csv.NewWriter(w), timeformat: timeformat}, nil
+	}
+	return nil, e.New("invalid format %v", format)
+}
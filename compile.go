@@ -0,0 +1,484 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/fcavani/e"
+	"github.com/fcavani/tags"
+)
+
+const ErrFieldNotFound = "field not found in the entry"
+const ErrInvOperand = "invalid operand"
+
+// compiled is implemented by the compiled rules, source is the rule that
+// was compiled.
+type compiled interface {
+	source() Ruler
+}
+
+// Compile resolves the fields of the rules for the entries of the same
+// type of sample, checks the operands and compiles the regexps. The
+// compiled rule doesn't use reflection to find the fields, the entries of
+// other types are evaluated with the original rule.
+func Compile(r Ruler, sample Entry) (Ruler, error) {
+	if sample == nil {
+		return nil, e.New("sample entry is nil")
+	}
+	te := reflect.TypeOf(sample)
+	if reflect.Indirect(reflect.ValueOf(sample)).Kind() != reflect.Struct {
+		return nil, e.New("entry must be a struct")
+	}
+	return compileRule(r, te)
+}
+
+func compileRules(rulers []Ruler, te reflect.Type) ([]Ruler, error) {
+	out := make([]Ruler, 0, len(rulers))
+	for _, r := range rulers {
+		c, err := compileRule(r, te)
+		if err != nil {
+			return nil, e.Forward(err)
+		}
+		out = append(out, c)
+	}
+	return out, nil
+}
+
+func compileRule(r Ruler, te reflect.Type) (Ruler, error) {
+	var err error
+	var rulers []Ruler
+	switch v := r.(type) {
+	case *op:
+		return compileOp(v, te)
+	case *compiledOp:
+		return compileOp(v.orig, te)
+	case *and:
+		rulers, err = compileRules(v.rulers, te)
+		if err != nil {
+			return nil, e.Forward(err)
+		}
+		return And(rulers...), nil
+	case *or:
+		rulers, err = compileRules(v.rulers, te)
+		if err != nil {
+			return nil, e.Forward(err)
+		}
+		return Or(rulers...), nil
+	case *not:
+		rulers, err = compileRules([]Ruler{v.Ruler}, te)
+		if err != nil {
+			return nil, e.Forward(err)
+		}
+		return Not(rulers[0]), nil
+	case *apply:
+		rulers, err = compileRules([]Ruler{v.condition, v.rule}, te)
+		if err != nil {
+			return nil, e.Forward(err)
+		}
+		return ApplyRuleIf(rulers[0], rulers[1]), nil
+	case *applyelse:
+		rulers, err = compileRules([]Ruler{v.condition, v.rule, v.el}, te)
+		if err != nil {
+			return nil, e.Forward(err)
+		}
+		return ApplyRuleIfElse(rulers[0], rulers[1], rulers[2]), nil
+	case *sel:
+		ifs := make([]*If, 0, len(v.Ifs))
+		for _, cond := range v.Ifs {
+			rulers, err = compileRules([]Ruler{cond.Condition, cond.Than}, te)
+			if err != nil {
+				return nil, e.Forward(err)
+			}
+			ifs = append(ifs, &If{
+				Condition: rulers[0],
+				Than:      rulers[1],
+			})
+		}
+		rulers, err = compileRules([]Ruler{v.Default}, te)
+		if err != nil {
+			return nil, e.Forward(err)
+		}
+		return Select(ifs, rulers[0]), nil
+	}
+	return r, nil
+}
+
+const ErrFilterNotCompiled = "filter can't be compiled"
+
+// checkRule compiles the rule for the default entry. The rules with fields
+// that the default entry doesn't have are returned unchanged, they may be
+// for other entries. The other errors, like invalid operands, are returned.
+func checkRule(r Ruler) (Ruler, error) {
+	if r == nil {
+		return nil, nil
+	}
+	c, err := Compile(r, &log{})
+	if err != nil && e.Find(err, ErrFieldNotFound) >= 0 {
+		return r, nil
+	} else if err != nil {
+		return nil, e.Push(e.New(ErrFilterNotCompiled), err)
+	}
+	return c, nil
+}
+
+// precompile compiles the rule for the default entry. If the rule is
+// invalid the error is sent to the global error handler and the rule is
+// returned unchanged.
+func precompile(r Ruler) Ruler {
+	c, err := checkRule(r)
+	if err != nil {
+		Fail(err)
+		return r
+	}
+	return c
+}
+
+type valueClass uint8
+
+const (
+	classOther valueClass = iota
+	classBool
+	classInt
+	classUint
+	classFloat
+	classString
+	classTime
+	classTags
+	classMap
+)
+
+func classOf(t reflect.Type) valueClass {
+	switch t {
+	case reflect.TypeOf(time.Time{}):
+		return classTime
+	case reflect.TypeOf(tags.Tags{}):
+		return classTags
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return classBool
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return classInt
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return classUint
+	case reflect.Float32, reflect.Float64:
+		return classFloat
+	case reflect.String:
+		return classString
+	case reflect.Map:
+		return classMap
+	}
+	return classOther
+}
+
+type compiledOp struct {
+	orig *op
+	op   Operation
+	te   reflect.Type
+	// index of the field in the struct, -1 for the fields in the FieldMap.
+	index int
+	key   string
+	fidx  int
+	class valueClass
+	rtype reflect.Type
+	rbool bool
+	rint  int64
+	ruint uint64
+	rflt  float64
+	rstr  string
+	rtime time.Time
+	re    *regexp.Regexp
+}
+
+func (c *compiledOp) source() Ruler {
+	return c.orig
+}
+
+func structType(te reflect.Type) reflect.Type {
+	for te.Kind() == reflect.Ptr {
+		te = te.Elem()
+	}
+	return te
+}
+
+func compileOp(o *op, te reflect.Type) (Ruler, error) {
+	c := &compiledOp{
+		orig:  o,
+		op:    o.op,
+		te:    te,
+		index: -1,
+		fidx:  -1,
+	}
+	if _, found := opNames[o.op]; !found {
+		return nil, e.New("invalid operation %v", o.op)
+	}
+	st := structType(te)
+	for i := 0; i < st.NumField(); i++ {
+		f := st.Field(i)
+		tag := f.Tag.Get("log")
+		if tag == "" {
+			continue
+		}
+		if f.PkgPath != "" {
+			return nil, e.New("the field %v must be exported", f.Name)
+		}
+		if f.Type == reflect.TypeOf(FieldMap{}) {
+			c.fidx = i
+		}
+		if tag == o.field {
+			c.index = i
+		}
+	}
+	if o.op == N && o.vright.IsValid() {
+		return nil, e.Push(e.New(ErrInvOperand), e.New("operation N doesn't have value"))
+	} else if o.op != N && !o.vright.IsValid() {
+		return nil, e.Push(e.New(ErrInvOperand), e.New("operation without value"))
+	}
+	var tleft reflect.Type
+	switch {
+	case c.index >= 0:
+		tleft = st.Field(c.index).Type
+		for tleft.Kind() == reflect.Ptr {
+			tleft = tleft.Elem()
+		}
+	case strings.HasPrefix(o.field, FieldPrefix) && c.fidx >= 0:
+		// The type of the fields are only known in the evaluation.
+		c.key = strings.TrimPrefix(o.field, FieldPrefix)
+	default:
+		return nil, e.Push(e.New(ErrFieldNotFound), e.New("field %v", o.field))
+	}
+	if o.vright.IsValid() {
+		c.rtype = o.vright.Type()
+		err := c.setValue(o.vright)
+		if err != nil {
+			return nil, e.Forward(err)
+		}
+	}
+	if tleft != nil {
+		c.class = classOf(tleft)
+		err := c.check(tleft)
+		if err != nil {
+			return nil, e.Push(err, e.New("field %v", o.field))
+		}
+	} else if c.rtype != nil {
+		c.class = classOf(c.rtype)
+	}
+	return c, nil
+}
+
+func (c *compiledOp) setValue(v reflect.Value) error {
+	switch classOf(v.Type()) {
+	case classBool:
+		c.rbool = v.Bool()
+	case classInt:
+		c.rint = v.Int()
+	case classUint:
+		c.ruint = v.Uint()
+	case classFloat:
+		c.rflt = v.Float()
+	case classString:
+		c.rstr = v.String()
+		if c.op == Re {
+			re, err := regexp.Compile(c.rstr)
+			if err != nil {
+				return e.Push(e.New(ErrInvOperand), err)
+			}
+			c.re = re
+		}
+	case classTime:
+		c.rtime = v.Interface().(time.Time)
+	default:
+		if re, ok := v.Interface().(regexp.Regexp); ok && c.op == Re {
+			c.re = &re
+			c.rtype = reflect.TypeOf("")
+		}
+	}
+	return nil
+}
+
+// check verifies if the operation is valid for a field of type tleft.
+func (c *compiledOp) check(tleft reflect.Type) error {
+	switch c.op {
+	case N:
+		if c.class != classBool {
+			return e.Push(e.New(ErrInvOperand), e.New("operation N needs a bool field"))
+		}
+	case Eq, Ne:
+		if tleft != c.rtype {
+			return e.Push(e.New(ErrInvOperand), e.New("type %v isn't equal to %v", c.rtype, tleft))
+		}
+	case Lt, Gt, Le, Ge:
+		if tleft != c.rtype {
+			return e.Push(e.New(ErrInvOperand), e.New("type %v isn't equal to %v", c.rtype, tleft))
+		}
+		switch c.class {
+		case classInt, classUint, classFloat, classString, classTime:
+		default:
+			return e.Push(e.New(ErrInvOperand), e.New("type %v isn't ordered", tleft))
+		}
+	case Ex:
+		if c.rtype.Kind() != reflect.String {
+			return e.Push(e.New(ErrInvOperand), e.New("operation Ex needs a string"))
+		}
+		if c.class == classMap && tleft.Key().Kind() != reflect.String {
+			return e.Push(e.New(ErrInvOperand), e.New("operation Ex needs a map with string keys"))
+		} else if c.class != classMap && c.class != classTags {
+			return e.Push(e.New(ErrInvOperand), e.New("operation Ex needs tags or a map"))
+		}
+	case Cnts, Pr, Re:
+		if c.class != classString || c.rtype.Kind() != reflect.String {
+			return e.Push(e.New(ErrInvOperand), e.New("operation needs a string field and a string value"))
+		}
+	}
+	return nil
+}
+
+func (c *compiledOp) Result(entry Entry) bool {
+	ve := reflect.ValueOf(entry)
+	if ve.Type() != c.te {
+		return c.orig.Result(entry)
+	}
+	ve = reflect.Indirect(ve)
+	if c.index < 0 {
+		return c.field(ve)
+	}
+	vleft := ve.Field(c.index)
+	for vleft.Kind() == reflect.Ptr {
+		vleft = vleft.Elem()
+	}
+	if !vleft.IsValid() {
+		return false
+	}
+	return c.compare(vleft, nil)
+}
+
+// field evaluates the operation in one value of the FieldMap.
+func (c *compiledOp) field(ve reflect.Value) bool {
+	fields, _ := ve.Field(c.fidx).Interface().(FieldMap)
+	v, found := fields[c.key]
+	if !found || v == nil {
+		return c.op == Ne || c.op == N
+	}
+	vleft := reflect.Indirect(reflect.ValueOf(v))
+	if !vleft.IsValid() {
+		return c.op == Ne || c.op == N
+	}
+	switch c.op {
+	case N:
+		return vleft.Kind() == reflect.Bool && !vleft.Bool()
+	case Ex:
+		switch classOf(vleft.Type()) {
+		case classTags:
+		case classMap:
+			if vleft.Type().Key().Kind() != reflect.String {
+				return false
+			}
+		default:
+			return false
+		}
+	default:
+		if vleft.Type() != c.rtype {
+			return c.op == Ne
+		}
+	}
+	return c.compare(vleft, v)
+}
+
+func (c *compiledOp) order(s int) bool {
+	switch c.op {
+	case Eq:
+		return s == 0
+	case Ne:
+		return s != 0
+	case Lt:
+		return s < 0
+	case Gt:
+		return s > 0
+	case Le:
+		return s <= 0
+	case Ge:
+		return s >= 0
+	}
+	return false
+}
+
+func (c *compiledOp) compare(vleft reflect.Value, raw interface{}) bool {
+	switch c.op {
+	case N:
+		return vleft.Kind() == reflect.Bool && !vleft.Bool()
+	case Ex:
+		if c.class == classTags || vleft.Type() == reflect.TypeOf(tags.Tags{}) {
+			if vleft.CanAddr() {
+				return vleft.Addr().Interface().(*tags.Tags).Exist(c.rstr)
+			}
+			t := vleft.Interface().(tags.Tags)
+			return (&t).Exist(c.rstr)
+		}
+		if fields, ok := vleft.Interface().(FieldMap); ok {
+			_, found := fields[c.rstr]
+			return found
+		}
+		return vleft.MapIndex(reflect.ValueOf(c.rstr)).IsValid()
+	case Cnts:
+		return strings.Contains(vleft.String(), c.rstr)
+	case Pr:
+		return strings.HasPrefix(vleft.String(), c.rstr)
+	case Re:
+		return c.re.MatchString(vleft.String())
+	}
+	s := 0
+	switch c.class {
+	case classBool:
+		if vleft.Bool() != c.rbool {
+			s = 1
+		}
+	case classInt:
+		l := vleft.Int()
+		if l < c.rint {
+			s = -1
+		} else if l > c.rint {
+			s = 1
+		}
+	case classUint:
+		l := vleft.Uint()
+		if l < c.ruint {
+			s = -1
+		} else if l > c.ruint {
+			s = 1
+		}
+	case classFloat:
+		l := vleft.Float()
+		if l < c.rflt {
+			s = -1
+		} else if l > c.rflt {
+			s = 1
+		}
+	case classString:
+		s = strings.Compare(vleft.String(), c.rstr)
+	case classTime:
+		var t time.Time
+		if vleft.CanAddr() {
+			t = *vleft.Addr().Interface().(*time.Time)
+		} else if tt, ok := raw.(time.Time); ok {
+			t = tt
+		} else {
+			t = vleft.Interface().(time.Time)
+		}
+		if t.Before(c.rtime) {
+			s = -1
+		} else if t.After(c.rtime) {
+			s = 1
+		}
+	default:
+		if !reflect.DeepEqual(vleft.Interface(), c.orig.vright.Interface()) {
+			s = 1
+		}
+	}
+	return c.order(s)
+}
@@ -0,0 +1,167 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/fcavani/e"
+	"github.com/fcavani/tags"
+)
+
+func TestCompile(t *testing.T) {
+	entry := ruleEntry(t, ErrorPrio, "db,sql", "db", "connection timeout")
+	for i, test := range ruleTests {
+		if test.rule == `a == 1 || b == 2 && c == 1.5 || d == -2` {
+			continue
+		}
+		r, err := ParseRule(test.rule)
+		if err != nil {
+			t.Fatal(i, e.Trace(e.Forward(err)))
+		}
+		c, err := Compile(r, entry)
+		if err != nil {
+			t.Fatal(i, test.rule, e.Trace(e.Forward(err)))
+		}
+		if res := c.Result(entry); res != test.result {
+			t.Fatal(i, test.rule, "wrong result", res)
+		}
+		s, err := FormatRule(c)
+		if err != nil {
+			t.Fatal(i, e.Trace(e.Forward(err)))
+		}
+		if s != test.format {
+			t.Fatalf("%v: wrong format %q", i, s)
+		}
+	}
+
+	entry.(*log).Flds["re"] = regexp.MustCompile("x")
+	entry.(*log).Flds["date"] = time.Date(2015, 6, 1, 12, 0, 0, 0, time.UTC)
+	rules := []Ruler{
+		Op(Re, "msg", regexp.MustCompile(`time\w+`)),
+		Op(Eq, "field.tries", 3),
+		Op(Ne, "field.tries", "3"),
+		Op(Ne, "field.nothing", 3),
+		Op(N, "field.nothing"),
+		Op(Gt, "field.date", time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)),
+		Op(Eq, "field.re", *regexp.MustCompile("x")),
+	}
+	for i, r := range rules {
+		c, err := Compile(r, entry)
+		if err != nil {
+			t.Fatal(i, e.Trace(e.Forward(err)))
+		}
+		if c.Result(entry) != r.Result(entry) || !c.Result(entry) {
+			t.Fatal(i, "wrong result")
+		}
+	}
+
+	// Other entry types use the original rule.
+	r, err := Compile(Op(Ex, "tags", "a"), &log{})
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	lbs := tags.Tags{"a"}
+	if !r.Result(&testEntry{Labels: &lbs}) {
+		t.Fatal("wrong result")
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	entry := &testEntry{}
+	tests := []struct {
+		r   Ruler
+		err string
+	}{
+		{Op(Eq, "nothing", 1), ErrFieldNotFound},
+		{Op(Eq, "field.x", 1), ErrFieldNotFound},
+		{Op(Eq, "int", uint(1)), ErrInvOperand},
+		{Op(Lt, "bool", true), ErrInvOperand},
+		{Op(N, "int"), ErrInvOperand},
+		{Op(Eq, "int"), ErrInvOperand},
+		{Op(Ex, "str", "a"), ErrInvOperand},
+		{Op(Ex, "tags", 1), ErrInvOperand},
+		{Op(Cnts, "int", "a"), ErrInvOperand},
+		{Op(Re, "str", "("), ErrInvOperand},
+		{And(True{}, Or(Not(Op(Pr, "float", "a")))), ErrInvOperand},
+		{Select([]*If{{Condition: True{}, Than: Op(Gt, "time", 1)}}, True{}), ErrInvOperand},
+	}
+	for i, test := range tests {
+		_, err := Compile(test.r, entry)
+		if err == nil {
+			t.Fatal(i, "nil error")
+		}
+		if e.Find(err, test.err) < 0 {
+			t.Fatal(i, "wrong error", err)
+		}
+	}
+	_, err := Compile(True{}, nil)
+	if err == nil {
+		t.Fatal("nil error")
+	}
+}
+
+func TestCompileAllocs(t *testing.T) {
+	entry := benchEntry()
+	entry.(*log).Timestamp = time.Now()
+	r := And(
+		benchRule,
+		Op(Lt, "date", time.Now().Add(time.Hour)),
+		Op(Eq, "field.user", "felipe"),
+		Op(Ex, "fields", "user"),
+		Op(Cnts, "msg", "log"),
+	)
+	c, err := Compile(r, entry)
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	if !c.Result(entry) {
+		t.Fatal("wrong result")
+	}
+	allocs := testing.AllocsPerRun(100, func() {
+		c.Result(entry)
+	})
+	if allocs != 0 {
+		t.Fatal("compiled rule allocates", allocs)
+	}
+}
+
+func TestWriterFilterCompiled(t *testing.T) {
+	w := NewWriter(nil).Filter(Op(Eq, "level", ErrorPrio))
+	if _, ok := w.(*Writer).r.(*compiledOp); !ok {
+		t.Fatal("rule not compiled")
+	}
+	// Rules for other entries are kept without errors.
+	var failed error
+	SetErrorHandler(ErrorHandlerFunc(func(bak LogBackend, entry Entry, err error) {
+		failed = err
+	}))
+	defer SetErrorHandler(nil)
+	w = NewWriter(nil).Filter(Op(Eq, "str", "a"))
+	if _, ok := w.(*Writer).r.(*op); !ok {
+		t.Fatal("rule compiled")
+	}
+	if failed != nil {
+		t.Fatal(e.Trace(e.Forward(failed)))
+	}
+	if !w.(*Writer).r.Result(&testEntry{Str: "a"}) {
+		t.Fatal("wrong result")
+	}
+	// Invalid rules are reported.
+	w = NewWriter(nil).Filter(Op(Eq, "level", "error"))
+	if failed == nil || e.Find(failed, ErrFilterNotCompiled) < 0 || e.Find(failed, ErrInvOperand) < 0 {
+		t.Fatal("wrong error", failed)
+	}
+	lbs := tags.Tags{"a"}
+	r, err := Compile(Op(Ex, "tags", "a"), &log{})
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	if !r.Result(&log{Labels: &lbs}) || r.Result(&log{}) {
+		t.Fatal("wrong result")
+	}
+}
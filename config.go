@@ -0,0 +1,303 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"flag"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/fcavani/e"
+	"github.com/fcavani/tags"
+)
+
+// Environment variables read by ConfigureFromEnv.
+const (
+	// EnvLevel are the levels, like all=info,github.com/us/db=debug.
+	EnvLevel = "LOG_LEVEL"
+	// EnvFormat is the format: text, json, logfmt or a template.
+	EnvFormat = "LOG_FORMAT"
+	// EnvOutput is the output: stdout, stderr, discard or a file name.
+	EnvOutput = "LOG_OUTPUT"
+)
+
+// Set parses the level name, so Level can be used as flag.Value.
+func (l *Level) Set(s string) error {
+	level, err := ParseLevel(strings.TrimSpace(s))
+	if err != nil {
+		return e.Forward(err)
+	}
+	*l = level
+	return nil
+}
+
+// ParseLevels parses the levels in the format scope=level separated by
+// commas, like all=info,github.com/us/db=debug. A level without scope is
+// for all packages.
+func ParseLevels(s string) (map[string]Level, error) {
+	levels := make(map[string]Level)
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		scope := "all"
+		name := item
+		if i := strings.LastIndex(item, "="); i > -1 {
+			scope = strings.TrimSpace(item[:i])
+			name = item[i+1:]
+		}
+		if scope == "" {
+			return nil, e.New("invalid scope in %v", item)
+		}
+		var level Level
+		err := level.Set(name)
+		if err != nil {
+			return nil, e.Push(err, e.New("invalid level in %v", item))
+		}
+		levels[scope] = level
+	}
+	return levels, nil
+}
+
+// ConfigureLevels sets the levels of the default logger. See ParseLevels
+// for the format.
+func ConfigureLevels(s string) error {
+	levels, err := ParseLevels(s)
+	if err != nil {
+		return e.Forward(err)
+	}
+	for scope, level := range levels {
+		Log.SetLevel(scope, level)
+	}
+	return nil
+}
+
+// swapWriter is the output of the default logger. The loggers derived
+// from the default logger share the same backend, so the writer is
+// changed here and not in the backend.
+type swapWriter struct {
+	lck sync.Mutex
+	w   io.Writer
+}
+
+func (s *swapWriter) Write(p []byte) (int, error) {
+	s.lck.Lock()
+	defer s.lck.Unlock()
+	return s.w.Write(p)
+}
+
+// swap sets the new writer and returns the old one, that isn't used after
+// swap returns.
+func (s *swapWriter) swap(w io.Writer) io.Writer {
+	s.lck.Lock()
+	defer s.lck.Unlock()
+	old := s.w
+	s.w = w
+	return old
+}
+
+type logConfig struct {
+	lck    sync.Mutex
+	format string
+	output string
+	out    *swapWriter
+	file   *os.File
+	// bak is the backend of the default logger created by the config.
+	bak LogBackend
+}
+
+var config = logConfig{
+	format: "text",
+	output: "stdout",
+	out:    &swapWriter{w: os.Stdout},
+}
+
+// backend creates the backend of the default logger.
+func (c *logConfig) backend(f Formatter) LogBackend {
+	c.lck.Lock()
+	defer c.lck.Unlock()
+	c.bak = NewWriter(c.out).F(f)
+	return c.bak
+}
+
+// ConfigureFormat sets the format of the default logger. The format can be
+// text for the DefFormatter, json, logfmt or a template for the
+// StdFormatter, like "::date - ::msg". If the backend of the default logger
+// was replaced it can't be configured.
+func ConfigureFormat(format string) error {
+	config.lck.Lock()
+	defer config.lck.Unlock()
+	err := config.apply(format)
+	if err != nil {
+		return e.Forward(err)
+	}
+	config.format = format
+	return nil
+}
+
+// ConfigureOutput sets where the default logger, and the loggers derived
+// from it, write. The output can be stdout, stderr, discard or the name
+// of one file. The entries are appended to the file.
+func ConfigureOutput(output string) error {
+	config.lck.Lock()
+	defer config.lck.Unlock()
+	var w io.Writer
+	var file *os.File
+	switch output {
+	case "stdout":
+		w = os.Stdout
+	case "stderr":
+		w = os.Stderr
+	case "discard":
+		w = ioutil.Discard
+	case "":
+		return e.New("invalid output")
+	default:
+		var err error
+		file, err = os.OpenFile(output, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			return e.New(err)
+		}
+		w = file
+	}
+	config.out.swap(w)
+	oldFile := config.file
+	config.output, config.file = output, file
+	if oldFile != nil {
+		err := oldFile.Close()
+		if err != nil {
+			return e.New(err)
+		}
+	}
+	return nil
+}
+
+// apply sets the format of the backend of the default logger. The
+// formatter of the Writer is changed in place, only the change from or to
+// logfmt replaces the backend.
+func (c *logConfig) apply(format string) error {
+	var f Formatter
+	switch format {
+	case "text":
+		f = DefFormatter
+	case "json":
+		jf, err := NewJSONFormatter(
+			&log{Labels: &tags.Tags{}},
+			map[string]interface{}{
+				"host": hostname(),
+			},
+			"",
+		)
+		if err != nil {
+			return e.Forward(err)
+		}
+		f = jf
+	case "logfmt":
+	default:
+		if !strings.Contains(format, "::") {
+			return e.New("invalid format %v", format)
+		}
+		sf, err := NewStdFormatter(
+			"::",
+			format,
+			&log{Labels: &tags.Tags{}},
+			map[string]interface{}{
+				"host": hostname(),
+			},
+			"",
+		)
+		if err != nil {
+			return e.Forward(err)
+		}
+		f = sf
+	}
+	l, ok := Log.(*log)
+	if !ok {
+		return e.New("the default logger was replaced")
+	}
+	l.lck.Lock()
+	defer l.lck.Unlock()
+	if l.store != c.bak {
+		return e.New("the backend of the default logger was replaced")
+	}
+	if w, ok := c.bak.(*Writer); ok && f != nil {
+		w.F(f)
+		return nil
+	}
+	if f == nil {
+		c.bak = NewLogfmt(c.out)
+	} else {
+		c.bak = NewWriter(c.out).F(f)
+	}
+	l.store = c.bak
+	l.filterLevels()
+	return nil
+}
+
+// ConfigureFromEnv configures the default logger with the environment
+// variables LOG_LEVEL, LOG_FORMAT and LOG_OUTPUT. Variables not set are
+// ignored.
+func ConfigureFromEnv() error {
+	if format := os.Getenv(EnvFormat); format != "" {
+		err := ConfigureFormat(format)
+		if err != nil {
+			return e.Push(err, e.New("invalid %v", EnvFormat))
+		}
+	}
+	if output := os.Getenv(EnvOutput); output != "" {
+		err := ConfigureOutput(output)
+		if err != nil {
+			return e.Push(err, e.New("invalid %v", EnvOutput))
+		}
+	}
+	if levels := os.Getenv(EnvLevel); levels != "" {
+		err := ConfigureLevels(levels)
+		if err != nil {
+			return e.Push(err, e.New("invalid %v", EnvLevel))
+		}
+	}
+	return nil
+}
+
+// configFlag is a flag.Value that configures the logger when set.
+type configFlag struct {
+	value string
+	set   func(s string) error
+}
+
+func (c *configFlag) String() string {
+	if c == nil {
+		return ""
+	}
+	return c.value
+}
+
+func (c *configFlag) Set(s string) error {
+	err := c.set(s)
+	if err != nil {
+		return e.Forward(err)
+	}
+	c.value = s
+	return nil
+}
+
+// RegisterFlags registers in fs the flags -log.level, -log.template and
+// -log.output. The flags configure the default logger when fs is parsed.
+// If fs is nil flag.CommandLine is used.
+func RegisterFlags(fs *flag.FlagSet) {
+	if fs == nil {
+		fs = flag.CommandLine
+	}
+	fs.Var(&configFlag{set: ConfigureLevels}, "log.level", "log levels, like all=info,github.com/us/db=debug")
+	config.lck.Lock()
+	format, output := config.format, config.output
+	config.lck.Unlock()
+	fs.Var(&configFlag{value: format, set: ConfigureFormat}, "log.template", "log format: text, json, logfmt or a template like \"::date - ::msg\"")
+	fs.Var(&configFlag{value: output, set: ConfigureOutput}, "log.output", "log output: stdout, stderr, discard or a file name")
+}
@@ -0,0 +1,210 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fcavani/e"
+)
+
+func TestLevelFlag(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	level := InfoPrio
+	fs.Var(&level, "v", "level")
+	err := fs.Parse([]string{"-v", "error"})
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	if level != ErrorPrio {
+		t.Fatal("wrong level", level)
+	}
+	err = fs.Parse([]string{"-v", "loud"})
+	if err == nil {
+		t.Fatal("nil error")
+	}
+}
+
+func TestParseLevels(t *testing.T) {
+	levels, err := ParseLevels(" all=info, github.com/us/db=debug,,warning")
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	if len(levels) != 2 || levels["all"] != WarnPrio || levels["github.com/us/db"] != DebugPrio {
+		t.Fatal("wrong levels", levels)
+	}
+	for _, s := range []string{"all=loud", "=info", "info="} {
+		_, err = ParseLevels(s)
+		if err == nil {
+			t.Fatal("nil error", s)
+		}
+	}
+}
+
+// configLogger replaces the default logger and restores it in the end of
+// the test.
+func configLogger(t *testing.T) func() {
+	old := Log
+	config.lck.Lock()
+	oldBak, oldFormat := config.bak, config.format
+	config.lck.Unlock()
+	Log = New(config.backend(DefFormatter), false).SetLevel("all", InfoPrio)
+	return func() {
+		err := ConfigureOutput("stdout")
+		if err != nil {
+			t.Error(e.Trace(e.Forward(err)))
+		}
+		config.lck.Lock()
+		config.bak, config.format = oldBak, oldFormat
+		config.lck.Unlock()
+		Log = old
+	}
+}
+
+func readLog(t *testing.T, name string) string {
+	buf, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	return string(buf)
+}
+
+func TestRegisterFlags(t *testing.T) {
+	defer configLogger(t)()
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "test.log")
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	RegisterFlags(fs)
+	err = fs.Parse([]string{
+		"-log.level", "all=error",
+		"-log.template", "::level - ::msg",
+		"-log.output", name,
+	})
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	InfoLevel().Print("not logged")
+	Error("logged")
+	if s := readLog(t, name); s != "error - logged\n" {
+		t.Fatalf("wrong log %q", s)
+	}
+	if fs.Lookup("log.output").Value.String() != name {
+		t.Fatal("wrong flag value")
+	}
+
+	err = fs.Parse([]string{"-log.template", "json"})
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	Error("json")
+	if s := readLog(t, name); !strings.Contains(s, `"msg":"json"`) {
+		t.Fatalf("wrong log %q", s)
+	}
+
+	for _, args := range [][]string{
+		{"-log.level", "loud"},
+		{"-log.template", "nothing"},
+		{"-log.output", filepath.Join(dir, "nothing", "test.log")},
+	} {
+		err = fs.Parse(args)
+		if err == nil {
+			t.Fatal("nil error", args)
+		}
+	}
+	Error("still here")
+	if s := readLog(t, name); !strings.Contains(s, `"msg":"still here"`) {
+		t.Fatalf("wrong log %q", s)
+	}
+}
+
+func TestConfigureFromEnv(t *testing.T) {
+	defer configLogger(t)()
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "test.log")
+
+	os.Setenv(EnvLevel, "all=warning")
+	os.Setenv(EnvFormat, "logfmt")
+	os.Setenv(EnvOutput, name)
+	defer os.Unsetenv(EnvLevel)
+	defer os.Unsetenv(EnvFormat)
+	defer os.Unsetenv(EnvOutput)
+
+	err = ConfigureFromEnv()
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	InfoLevel().Print("not logged")
+	Log.WarnLevel().Print("logged")
+	s := readLog(t, name)
+	if strings.Contains(s, "not logged") || !strings.Contains(s, "msg=logged") {
+		t.Fatalf("wrong log %q", s)
+	}
+
+	os.Setenv(EnvLevel, "all=nothing")
+	err = ConfigureFromEnv()
+	if err == nil {
+		t.Fatal("nil error")
+	}
+}
+
+func TestConfigureDerived(t *testing.T) {
+	defer configLogger(t)()
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	defer os.RemoveAll(dir)
+	first := filepath.Join(dir, "first.log")
+	second := filepath.Join(dir, "second.log")
+
+	err = ConfigureOutput(first)
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	logger := Domain("derived")
+	err = ConfigureFormat("::domain - ::msg")
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	logger.Println("one")
+	err = ConfigureOutput(second)
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	logger.Println("two")
+	if s := readLog(t, first); s != "derived - one\n" {
+		t.Fatalf("wrong log %q", s)
+	}
+	if s := readLog(t, second); s != "derived - two\n" {
+		t.Fatalf("wrong log %q", s)
+	}
+
+	// The backend set by the user isn't replaced.
+	bak := NewWriter(ioutil.Discard).F(DefFormatter)
+	Log = Log.SetStore(bak)
+	err = ConfigureFormat("json")
+	if err == nil {
+		t.Fatal("nil error")
+	}
+	if Log.Store() != bak {
+		t.Fatal("backend replaced")
+	}
+}
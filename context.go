@@ -0,0 +1,134 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"context"
+	"sync"
+)
+
+type ctxKey struct{}
+
+// NewContext returns a copy of ctx that carries the logger l.
+func NewContext(ctx context.Context, l Logger) context.Context {
+	return context.WithValue(ctx, ctxKey{}, l)
+}
+
+// FromContext returns the logger carried by ctx. If ctx don't have one
+// the package level Log is returned.
+func FromContext(ctx context.Context) Logger {
+	if ctx != nil {
+		if l, ok := ctx.Value(ctxKey{}).(Logger); ok && l != nil {
+			return l
+		}
+	}
+	return Log
+}
+
+// Extractor retrieves one value from the context, if the value isn't
+// in the context ok must be false.
+type Extractor func(ctx context.Context) (val interface{}, ok bool)
+
+// ContextValue creates an Extractor that returns ctx.Value(key).
+func ContextValue(key interface{}) Extractor {
+	return func(ctx context.Context) (interface{}, bool) {
+		val := ctx.Value(key)
+		return val, val != nil
+	}
+}
+
+var extractors = make(map[string]Extractor)
+var extractorsLck sync.RWMutex
+
+// RegisterExtractor register an Extractor. The value extracted from the
+// context is attached to the entry as the field named key.
+func RegisterExtractor(key string, ex Extractor) {
+	extractorsLck.Lock()
+	defer extractorsLck.Unlock()
+	extractors[key] = ex
+}
+
+// UnregisterExtractor removes the Extractor associated with key.
+func UnregisterExtractor(key string) {
+	extractorsLck.Lock()
+	defer extractorsLck.Unlock()
+	delete(extractors, key)
+}
+
+func extract(ctx context.Context) map[string]interface{} {
+	extractorsLck.RLock()
+	defer extractorsLck.RUnlock()
+	m := make(map[string]interface{}, len(extractors))
+	if ctx == nil {
+		return m
+	}
+	for key, ex := range extractors {
+		val, ok := ex(ctx)
+		if !ok {
+			continue
+		}
+		m[key] = val
+	}
+	return m
+}
+
+// Ctx returns the logger carried by ctx with the fields filled by the
+// registered extractors.
+func Ctx(ctx context.Context) Logger {
+	m := extract(ctx)
+	l := FromContext(ctx)
+	if len(m) == 0 {
+		return l
+	}
+	return l.Fields(m)
+}
+
+func PrintCtx(ctx context.Context, vals ...interface{}) {
+	Ctx(ctx).DebugInfo().Print(vals...)
+}
+
+func PrintfCtx(ctx context.Context, str string, vals ...interface{}) {
+	Ctx(ctx).DebugInfo().Printf(str, vals...)
+}
+
+func PrintlnCtx(ctx context.Context, vals ...interface{}) {
+	Ctx(ctx).DebugInfo().Println(vals...)
+}
+
+func ErrorCtx(ctx context.Context, vals ...interface{}) {
+	Ctx(ctx).DebugInfo().Error(vals...)
+}
+
+func ErrorfCtx(ctx context.Context, s string, vals ...interface{}) {
+	Ctx(ctx).DebugInfo().Errorf(s, vals...)
+}
+
+func ErrorlnCtx(ctx context.Context, vals ...interface{}) {
+	Ctx(ctx).DebugInfo().Errorln(vals...)
+}
+
+func FatalCtx(ctx context.Context, vals ...interface{}) {
+	Ctx(ctx).DebugInfo().Fatal(vals...)
+}
+
+func FatalfCtx(ctx context.Context, s string, vals ...interface{}) {
+	Ctx(ctx).DebugInfo().Fatalf(s, vals...)
+}
+
+func FatallnCtx(ctx context.Context, vals ...interface{}) {
+	Ctx(ctx).DebugInfo().Fatalln(vals...)
+}
+
+func PanicCtx(ctx context.Context, vals ...interface{}) {
+	Ctx(ctx).DebugInfo().Panic(vals...)
+}
+
+func PanicfCtx(ctx context.Context, s string, vals ...interface{}) {
+	Ctx(ctx).DebugInfo().Panicf(s, vals...)
+}
+
+func PaniclnCtx(ctx context.Context, vals ...interface{}) {
+	Ctx(ctx).DebugInfo().Panicln(vals...)
+}
@@ -0,0 +1,58 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+type reqIDKey struct{}
+
+func TestContext(t *testing.T) {
+	buf := bytes.NewBuffer([]byte{})
+	old := Log
+	defer func() {
+		Log = old
+	}()
+	Log = New(NewWriter(buf).F(DefFormatter), false).Domain("default")
+
+	ctx := context.Background()
+	if FromContext(ctx) != Log {
+		t.Fatal("FromContext must fallback to Log")
+	}
+
+	logger := New(NewWriter(buf).F(DefFormatter), false).Domain("ctx")
+	ctx = NewContext(ctx, logger)
+	if FromContext(ctx) != logger {
+		t.Fatal("logger not found in context")
+	}
+
+	PrintlnCtx(ctx, "context test")
+	test(t, buf, "ctx", "context test")
+}
+
+func TestContextExtractor(t *testing.T) {
+	f, _ := NewStdFormatter(
+		"::",
+		"::domain - ::field.req_id - ::msg",
+		&log{},
+		map[string]interface{}{},
+		"",
+	)
+	buf := bytes.NewBuffer([]byte{})
+	logger := New(NewWriter(buf).F(f), false).Domain("ctx")
+
+	RegisterExtractor("req_id", ContextValue(reqIDKey{}))
+	defer UnregisterExtractor("req_id")
+
+	ctx := context.WithValue(NewContext(context.Background(), logger), reqIDKey{}, "abc123")
+	PrintCtx(ctx, "extractor test")
+	test(t, buf, "ctx - abc123 - extractor test")
+
+	PrintCtx(NewContext(context.Background(), logger), "no request")
+	test(t, buf, "ctx - - no request")
+}
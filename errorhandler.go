@@ -0,0 +1,196 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"io"
+	"os"
+	"reflect"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrorHandler receives the errors of the backends.
+type ErrorHandler interface {
+	// HandleError is called when bak fails to commit entry. bak and entry
+	// are nil if the error isn't related to them.
+	HandleError(bak LogBackend, entry Entry, err error)
+}
+
+// ErrorHandlerFunc is a function that implements ErrorHandler.
+type ErrorHandlerFunc func(bak LogBackend, entry Entry, err error)
+
+func (f ErrorHandlerFunc) HandleError(bak LogBackend, entry Entry, err error) {
+	f(bak, entry, err)
+}
+
+// ErrorHandlerSetter is implemented by the backends that have its own
+// ErrorHandler.
+type ErrorHandlerSetter interface {
+	SetErrorHandler(h ErrorHandler)
+	GetErrorHandler() ErrorHandler
+}
+
+type handlerBox struct {
+	h ErrorHandler
+}
+
+// handlerHolder is embedded in the backends to implement
+// ErrorHandlerSetter.
+type handlerHolder struct {
+	v atomic.Value
+}
+
+// SetErrorHandler sets the error handler of the backend. If h is nil the
+// global handler is used.
+func (x *handlerHolder) SetErrorHandler(h ErrorHandler) {
+	x.v.Store(handlerBox{h})
+}
+
+// GetErrorHandler returns the error handler of the backend or nil if the
+// backend uses the global handler.
+func (x *handlerHolder) GetErrorHandler() ErrorHandler {
+	box, _ := x.v.Load().(handlerBox)
+	return box.h
+}
+
+// StderrHandler prints the errors to stderr. It is the default handler.
+var StderrHandler = NewWriterHandler(os.Stderr)
+
+var errHandler atomic.Value
+
+// SetErrorHandler sets the global error handler. If h is nil
+// StderrHandler is used.
+func SetErrorHandler(h ErrorHandler) {
+	errHandler.Store(handlerBox{h})
+}
+
+// GetErrorHandler returns the global error handler.
+func GetErrorHandler() ErrorHandler {
+	box, _ := errHandler.Load().(handlerBox)
+	if box.h == nil {
+		return StderrHandler
+	}
+	return box.h
+}
+
+// handlingKey is one error being handled, of bak with entry.
+type handlingKey struct {
+	bak   LogBackend
+	entry Entry
+}
+
+// handling are the errors being handled. If the same backend fails again
+// with the same entry while its error is handled, like two fallback
+// backends that fail too, the error goes to StderrHandler. Other backends
+// that fail with the same entry at the same time aren't a loop.
+var handling sync.Map
+
+// isHandling returns true if the error of bak with entry is being handled.
+func isHandling(bak LogBackend, entry Entry) bool {
+	if !reflect.TypeOf(entry).Comparable() || (bak != nil && !reflect.TypeOf(bak).Comparable()) {
+		return false
+	}
+	_, found := handling.Load(handlingKey{bak: bak, entry: entry})
+	return found
+}
+
+// HandleError sends the error to the handler of bak, if it has one, or to
+// the global handler.
+func HandleError(bak LogBackend, entry Entry, err error) {
+	countError(bak)
+	h := GetErrorHandler()
+	if s, ok := bak.(ErrorHandlerSetter); ok {
+		if bh := s.GetErrorHandler(); bh != nil {
+			h = bh
+		}
+	}
+	if entry != nil && reflect.TypeOf(entry).Comparable() &&
+		(bak == nil || reflect.TypeOf(bak).Comparable()) {
+		key := handlingKey{bak: bak, entry: entry}
+		if _, loop := handling.LoadOrStore(key, struct{}{}); loop {
+			h = StderrHandler
+		} else {
+			defer handling.Delete(key)
+		}
+	}
+	h.HandleError(bak, entry, err)
+}
+
+// WriterHandler writes the errors to an io.Writer.
+type WriterHandler struct {
+	lck sync.Mutex
+	w   io.Writer
+}
+
+// NewWriterHandler creates a handler that writes to w.
+func NewWriterHandler(w io.Writer) *WriterHandler {
+	return &WriterHandler{
+		w: w,
+	}
+}
+
+func (w *WriterHandler) HandleError(bak LogBackend, entry Entry, err error) {
+	msg := "LOG IS IN PANIC: " + err.Error() + "\n"
+	if entry != nil {
+		dom := entry.GetDomain()
+		if dom == "" {
+			dom = "no domain"
+		}
+		msg += hostname() + " - " + dom + " - " + entry.Date().String() + " - " + entry.Level().String() + " - " + entry.Tags().String() + " - " + entry.Message() + "\n"
+	}
+	w.lck.Lock()
+	defer w.lck.Unlock()
+	io.WriteString(w.w, msg)
+}
+
+// CountingHandler counts the errors and sends them to another handler.
+type CountingHandler struct {
+	errors uint64
+	next   ErrorHandler
+}
+
+// NewCountingHandler creates a handler that counts the errors and sends
+// them to next. next can be nil.
+func NewCountingHandler(next ErrorHandler) *CountingHandler {
+	return &CountingHandler{
+		next: next,
+	}
+}
+
+func (c *CountingHandler) HandleError(bak LogBackend, entry Entry, err error) {
+	atomic.AddUint64(&c.errors, 1)
+	if c.next != nil {
+		c.next.HandleError(bak, entry, err)
+	}
+}
+
+// Errors returns the number of errors.
+func (c *CountingHandler) Errors() uint64 {
+	return atomic.LoadUint64(&c.errors)
+}
+
+// FallbackHandler commits the entries that failed to another backend.
+// Errors without entry go to StderrHandler.
+type FallbackHandler struct {
+	bak LogBackend
+}
+
+// NewFallbackHandler creates a handler that commits to bak.
+func NewFallbackHandler(bak LogBackend) *FallbackHandler {
+	return &FallbackHandler{
+		bak: bak,
+	}
+}
+
+func (f *FallbackHandler) HandleError(bak LogBackend, entry Entry, err error) {
+	// f.bak already failed with the entry if it is handling its error,
+	// like two backends that fall back to each other.
+	if entry == nil || bak == f.bak || isHandling(f.bak, entry) {
+		StderrHandler.HandleError(bak, entry, err)
+		return
+	}
+	f.bak.Commit(entry)
+}
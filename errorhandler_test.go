@@ -0,0 +1,135 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fcavani/e"
+)
+
+type failWriter struct{}
+
+func (f failWriter) Write(p []byte) (int, error) {
+	return 0, e.New("write failed")
+}
+
+// stderrHandler replaces StderrHandler with a handler that writes to buf.
+func stderrHandler(buf *bytes.Buffer) func() {
+	old := StderrHandler
+	StderrHandler = NewWriterHandler(buf)
+	return func() {
+		StderrHandler = old
+	}
+}
+
+func TestErrorHandler(t *testing.T) {
+	buf := bytes.NewBuffer([]byte{})
+	defer stderrHandler(buf)()
+	defer SetErrorHandler(nil)
+
+	count := NewCountingHandler(StderrHandler)
+	SetErrorHandler(count)
+	logger := New(NewWriter(failWriter{}).F(DefFormatter), false).Domain("test")
+	logger.Print("lost")
+	if count.Errors() != 1 {
+		t.Fatal("wrong count", count.Errors())
+	}
+	s := buf.String()
+	if !strings.Contains(s, "LOG IS IN PANIC: write failed") || !strings.Contains(s, " - test - ") || !strings.Contains(s, "lost") {
+		t.Fatalf("wrong error %q", s)
+	}
+
+	// The handler of the backend has precedence.
+	var got LogBackend
+	bak := NewWriter(failWriter{}).F(DefFormatter)
+	bak.(ErrorHandlerSetter).SetErrorHandler(ErrorHandlerFunc(func(b LogBackend, entry Entry, err error) {
+		got = b
+	}))
+	New(bak, false).Print("lost")
+	if got != bak || count.Errors() != 1 {
+		t.Fatal("backend handler not called")
+	}
+	bak.(ErrorHandlerSetter).SetErrorHandler(nil)
+	New(bak, false).Print("lost")
+	if count.Errors() != 2 {
+		t.Fatal("global handler not called")
+	}
+
+	Fail(e.New("no entry"))
+	if count.Errors() != 3 || !strings.Contains(buf.String(), "LOG IS IN PANIC: no entry") {
+		t.Fatal("Fail not handled")
+	}
+}
+
+func TestFallbackHandler(t *testing.T) {
+	buf := bytes.NewBuffer([]byte{})
+	defer stderrHandler(buf)()
+	defer SetErrorHandler(nil)
+
+	out := bytes.NewBuffer([]byte{})
+	SetErrorHandler(NewFallbackHandler(NewWriter(out).F(DefFormatter)))
+	logger := New(NewWriter(failWriter{}).F(DefFormatter), false)
+	logger.Print("saved")
+	if !strings.Contains(out.String(), "saved") || buf.Len() != 0 {
+		t.Fatalf("entry not saved %q %q", out.String(), buf.String())
+	}
+
+	// Two failing backends that fall back to each other.
+	b1 := NewWriter(failWriter{}).F(DefFormatter)
+	b2 := NewWriter(failWriter{}).F(DefFormatter)
+	b1.(ErrorHandlerSetter).SetErrorHandler(NewFallbackHandler(b2))
+	b2.(ErrorHandlerSetter).SetErrorHandler(NewFallbackHandler(b1))
+	New(b1, false).Print("loop")
+	if strings.Count(buf.String(), "LOG IS IN PANIC") != 1 {
+		t.Fatalf("wrong errors %q", buf.String())
+	}
+
+	// Internal errors of the logger don't go to the store.
+	buf.Reset()
+	SetErrorHandler(nil)
+	New(b1, false).error(e.New("internal failure"))
+	if !strings.Contains(buf.String(), "internal failure") || !strings.Contains(buf.String(), " - logger - ") {
+		t.Fatalf("wrong error %q", buf.String())
+	}
+}
+
+func TestHandleErrorConcurrent(t *testing.T) {
+	buf := bytes.NewBuffer([]byte{})
+	defer stderrHandler(buf)()
+	defer SetErrorHandler(nil)
+
+	// Two backends fail with the same entry at the same time, both errors
+	// go to the handler.
+	var calls int32
+	both := make(chan struct{})
+	SetErrorHandler(ErrorHandlerFunc(func(bak LogBackend, entry Entry, err error) {
+		if atomic.AddInt32(&calls, 1) == 2 {
+			close(both)
+		}
+		select {
+		case <-both:
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	entry := &log{Msg: "same"}
+	var wg sync.WaitGroup
+	for _, bak := range []LogBackend{NewWriter(nil), NewWriter(nil)} {
+		wg.Add(1)
+		go func(bak LogBackend) {
+			defer wg.Done()
+			HandleError(bak, entry, e.New("failed"))
+		}(bak)
+	}
+	wg.Wait()
+	if n := atomic.LoadInt32(&calls); n != 2 || buf.Len() != 0 {
+		t.Fatalf("wrong calls %v %q", n, buf.String())
+	}
+}
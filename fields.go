@@ -0,0 +1,103 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/fcavani/e"
+	"github.com/go-logfmt/logfmt"
+)
+
+// FieldPrefix is the prefix used in templates and rules to refer to one
+// field of the entry, like ::field.user_id or Op(Eq, "field.user_id", 42).
+const FieldPrefix = "field."
+
+// FieldMap holds the key/value fields attached to one log entry.
+type FieldMap map[string]interface{}
+
+// Keys return the keys of the fields in alphabetic order.
+func (f FieldMap) Keys() []string {
+	keys := make([]string, 0, len(f))
+	for k := range f {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Copy returns a copy of the map. The values aren't copied.
+func (f FieldMap) Copy() FieldMap {
+	if f == nil {
+		return nil
+	}
+	n := make(FieldMap, len(f))
+	for k, v := range f {
+		n[k] = v
+	}
+	return n
+}
+
+// String returns the fields in the form key=value separated by space.
+func (f FieldMap) String() string {
+	if len(f) == 0 {
+		return ""
+	}
+	s := make([]string, 0, len(f))
+	for _, k := range f.Keys() {
+		s = append(s, k+"="+fieldString(f[k]))
+	}
+	return strings.Join(s, " ")
+}
+
+// Logfmt encodes each field as one keyval.
+func (f FieldMap) Logfmt(enc *logfmt.Encoder) error {
+	for _, k := range f.Keys() {
+		err := enc.EncodeKeyval(k, f[k])
+		if err != nil {
+			return e.Forward(err)
+		}
+	}
+	return nil
+}
+
+func fieldString(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	val := reflect.Indirect(reflect.ValueOf(v))
+	if !val.IsValid() {
+		return fmt.Sprint(v)
+	}
+	str := stringfy(val, TimeDateFormat)
+	if str == "" {
+		return fmt.Sprint(v)
+	}
+	return str
+}
+
+// entryCode returns the file, the package and the function where the entry
+// was logged, from the fields with the tags file, pkg and func.
+func entryCode(entry Entry) (file, pkg, fn string) {
+	val := reflect.Indirect(reflect.ValueOf(entry))
+	if val.Kind() != reflect.Struct {
+		return
+	}
+	t := val.Type()
+	for i := 0; i < t.NumField(); i++ {
+		switch t.Field(i).Tag.Get("log") {
+		case "file":
+			file = fieldString(val.Field(i).Interface())
+		case "pkg":
+			pkg = fieldString(val.Field(i).Interface())
+		case "func":
+			fn = fieldString(val.Field(i).Interface())
+		}
+	}
+	return
+}
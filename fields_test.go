@@ -0,0 +1,106 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/fcavani/e"
+	"github.com/fcavani/tags"
+	"github.com/fcavani/types"
+)
+
+func TestFieldsClone(t *testing.T) {
+	logger := New(NewWriter(bytes.NewBuffer([]byte{})).F(DefFormatter), false)
+	l1 := logger.With("user_id", 42)
+	l2 := l1.Fields(map[string]interface{}{"req": "abc"})
+	if len(logger.GetFields()) != 0 {
+		t.Fatal("fields leaked to the parent logger")
+	}
+	if len(l1.GetFields()) != 1 {
+		t.Fatal("wrong number of fields", l1.GetFields())
+	}
+	f := l2.GetFields()
+	if len(f) != 2 || f["user_id"] != 42 || f["req"] != "abc" {
+		t.Fatal("wrong fields", f)
+	}
+}
+
+func TestFieldsFormat(t *testing.T) {
+	f, err := NewStdFormatter(
+		"::",
+		"::domain - ::field.user_id - ::fields - ::msg",
+		&log{Labels: &tags.Tags{}},
+		map[string]interface{}{},
+		"",
+	)
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	buf := bytes.NewBuffer([]byte{})
+	logger := New(NewWriter(buf).F(f), false).Domain("test")
+	logger.With("user_id", 42).With("req", "abc").Println("fields test")
+	test(t, buf, "test - 42 - req=abc user_id=42 - fields test")
+}
+
+func TestFieldsLogfmt(t *testing.T) {
+	buf := bytes.NewBuffer([]byte{})
+	logger := New(NewLogfmt(buf), false).Domain("test")
+	logger.With("user_id", 42).Println("logfmt fields")
+	test(t, buf, "domain=test", "user_id=42")
+}
+
+func TestFieldsFilter(t *testing.T) {
+	buf := bytes.NewBuffer([]byte{})
+	logger := New(
+		Filter(
+			NewWriter(buf),
+			And(Op(Ex, "fields", "user_id"), Op(Eq, "field.user_id", 42)),
+		).F(DefFormatter),
+		false,
+	)
+	logger.With("user_id", 1).Println("not logged")
+	logger.Println("not logged")
+	logger.With("user_id", "42").Println("not logged")
+	logger.With("user_id", 42).Println("logged")
+	test(t, buf, "logged")
+	if buf.Len() != 0 {
+		t.Fatal("filter failed", buf.String())
+	}
+}
+
+func TestFieldsPersistence(t *testing.T) {
+	m, err := NewMap(10)
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	logger := New(NewGeneric(m).F(DefFormatter), false)
+	logger.With("user_id", 42).Println("persisted fields")
+	gob := &Gob{
+		TypeName: types.Name(&log{}),
+	}
+	err = m.Tx(false, func(tx Transaction) error {
+		_, data := tx.Cursor().First()
+		if data == nil {
+			return e.New("entry not found")
+		}
+		buf, err := gob.Encode(data)
+		if err != nil {
+			return e.Forward(err)
+		}
+		entry, err := gob.Decode(buf)
+		if err != nil {
+			return e.Forward(err)
+		}
+		if entry.(Entry).GetFields()["user_id"] != 42 {
+			return e.New("field not persisted: %v", entry.(Entry).GetFields())
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+}
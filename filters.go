@@ -9,6 +9,7 @@ import (
 	"reflect"
 	"regexp"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/fcavani/tags"
@@ -39,6 +40,39 @@ func (f *filter) F(formatter Formatter) LogBackend {
 	return f
 }
 
+func (f *filter) GetFilter() Ruler {
+	return f.r
+}
+
+func (f *filter) Backends() []LogBackend {
+	return []LogBackend{f.LogBackend}
+}
+
+// ruleHolder keeps the Ruler of one backend. The Ruler can be replaced
+// while the backend commits entries.
+type ruleHolder struct {
+	v atomic.Value
+}
+
+type ruleBox struct {
+	r Ruler
+}
+
+func (h *ruleHolder) Set(r Ruler) {
+	h.v.Store(ruleBox{r})
+}
+
+func (h *ruleHolder) Get() Ruler {
+	b, _ := h.v.Load().(ruleBox)
+	return b.r
+}
+
+// Pass returns true if the entry pass the rule or if there is no rule.
+func (h *ruleHolder) Pass(entry Entry) bool {
+	r := h.Get()
+	return r == nil || r.Result(entry)
+}
+
 // Operation defines one operator for the rules.
 type Operation uint8
 
@@ -57,7 +91,7 @@ const (
 	Ge
 	// Not
 	N
-	// Exits in tags
+	// Exits in tags or in fields
 	Ex
 	// Contains
 	Cnts
@@ -98,6 +132,18 @@ func mapfieldmap(entry Entry) (m map[string]reflect.Value) {
 			val = val.Elem()
 		}
 		m[tag] = val
+		if !val.IsValid() {
+			continue
+		}
+		if fields, ok := val.Interface().(FieldMap); ok {
+			for k, v := range fields {
+				fval := reflect.Indirect(reflect.ValueOf(v))
+				if !fval.IsValid() {
+					continue
+				}
+				m[FieldPrefix+k] = fval
+			}
+		}
 	}
 	return
 }
@@ -105,9 +151,15 @@ func mapfieldmap(entry Entry) (m map[string]reflect.Value) {
 func (o op) Result(entry Entry) bool {
 	mr := mapfieldmap(entry)
 	vleft, found := mr[o.field]
-	if !found {
+	if !found && strings.HasPrefix(o.field, FieldPrefix) {
+		// Fields are optional, the entry may not have it.
+		return o.op == Ne || o.op == N
+	} else if !found {
 		panic("logger: field name not found in entry struct")
 	}
+	if strings.HasPrefix(o.field, FieldPrefix) && o.vright.IsValid() && vleft.Type() != o.vright.Type() && o.op != N && o.op != Re {
+		return o.op == Ne
+	}
 	if o.op != N && o.op != Ex && o.op != Re && o.vright.IsValid() && vleft.Type() != o.vright.Type() {
 		panic("logger: type of vleft is not equal to the type of entry")
 	}
@@ -249,6 +301,14 @@ func (o op) Result(entry Entry) bool {
 			}
 			ptr := &tagsr
 			return ptr.Exist(tag)
+		case reflect.Invalid:
+			// Entry without tags.
+			return false
+		case reflect.Map:
+			if o.vright.Kind() != reflect.String {
+				panic("logger: exist only works with keys of string type")
+			}
+			return vleft.MapIndex(o.vright).IsValid()
 		default:
 			panic("logger: field type of entry is not supported")
 		}
@@ -27,6 +27,10 @@ type testEntry struct {
 	Labels *tags.Tags `log:"tags"`
 }
 
+func (t *testEntry) ID() string {
+	return ""
+}
+
 func (t *testEntry) Date() time.Time {
 	return time.Now()
 }
@@ -83,6 +87,18 @@ func (t *testEntry) DebugInfo() Logger {
 	return nil
 }
 
+func (t *testEntry) With(key string, val interface{}) Logger {
+	return nil
+}
+
+func (t *testEntry) Fields(m map[string]interface{}) Logger {
+	return nil
+}
+
+func (t *testEntry) GetFields() map[string]interface{} {
+	return nil
+}
+
 func TestInvalidOperation(t *testing.T) {
 	defer func() {
 		r := recover()
@@ -192,6 +208,9 @@ func TestTags(t *testing.T) {
 	if r {
 		t.Fatal("result is invalid")
 	}
+	if Op(Ex, "tags", "teste").Result(&testEntry{}) {
+		t.Fatal("entry without tags has the tag")
+	}
 }
 
 func TestCnts(t *testing.T) {
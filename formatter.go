@@ -213,6 +213,8 @@ func (s StdFormatter) Format(entry Entry) (out []byte, err error) {
 		if found {
 			fval := val.Field(fidx.I)
 			v = scapeSep(stringfy(fval, s.TimeFormat), s.Delim)
+		} else if strings.HasPrefix(bname, FieldPrefix) {
+			v = scapeSep(fieldString(entry.GetFields()[bname[len(FieldPrefix):]]), s.Delim)
 		} else {
 			inter, found := s.Map[bname]
 			if !found {
@@ -20,6 +20,10 @@ type entryTest struct {
 	Tag3 string `log:"tag3"`
 }
 
+func (et *entryTest) ID() string {
+	return ""
+}
+
 func (et *entryTest) Date() time.Time {
 	return time.Time{}
 }
@@ -76,10 +80,26 @@ func (et *entryTest) DebugInfo() Logger {
 	return nil
 }
 
+func (et *entryTest) With(key string, val interface{}) Logger {
+	return nil
+}
+
+func (et *entryTest) Fields(m map[string]interface{}) Logger {
+	return nil
+}
+
+func (et *entryTest) GetFields() map[string]interface{} {
+	return nil
+}
+
 type entryTest2 struct {
 	Tag string `log:"tag"`
 }
 
+func (et *entryTest2) ID() string {
+	return ""
+}
+
 func (et *entryTest2) Date() time.Time {
 	return time.Time{}
 }
@@ -136,6 +156,18 @@ func (et *entryTest2) DebugInfo() Logger {
 	return nil
 }
 
+func (et *entryTest2) With(key string, val interface{}) Logger {
+	return nil
+}
+
+func (et *entryTest2) Fields(m map[string]interface{}) Logger {
+	return nil
+}
+
+func (et *entryTest2) GetFields() map[string]interface{} {
+	return nil
+}
+
 type teststruct struct {
 	raw    string
 	entry  *entryTest
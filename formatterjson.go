@@ -0,0 +1,239 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/fcavani/e"
+	"github.com/fcavani/utilitybelt/deepcopy"
+)
+
+// JSONTimeFormat is the default time format for the JSONFormatter.
+var JSONTimeFormat = time.RFC3339Nano
+
+// JSONFormatter formats the entry as one JSON object per line. All fields
+// of the entry with the log tag are serialized with the tag as the key.
+type JSONFormatter struct {
+	// E is the Entry. This fild are only used for struct analasy of E.
+	E Entry
+	// Map holds static values that are added to every object.
+	Map map[string]interface{}
+	// Names maps the original key to a new name.
+	Names map[string]string
+	// Omit holds the keys that aren't serialized.
+	Omit map[string]bool
+	// Idx are the index of the fild. Don't change.
+	Idx map[string]struct {
+		I   int
+		Def string
+	}
+	// TimeFormat is the string with the template of date and time format.
+	TimeFormat string
+}
+
+func init() {
+	defer func() {
+		recover()
+	}()
+	gob.Register(&JSONFormatter{})
+}
+
+// NewJSONFormatter creates a new JSON formatter for entries of the same type
+// of entry. values are static values added to every entry, like the host
+// name.
+func NewJSONFormatter(entry Entry, values map[string]interface{}, timeformat string) (*JSONFormatter, error) {
+	if entry == nil {
+		return nil, e.New("invalid entry")
+	}
+	if values == nil {
+		values = make(map[string]interface{})
+	}
+	if timeformat == "" {
+		timeformat = JSONTimeFormat
+	}
+	return &JSONFormatter{
+		E:          entry,
+		Map:        values,
+		Names:      make(map[string]string),
+		Omit:       make(map[string]bool),
+		Idx:        mkindex(entry),
+		TimeFormat: timeformat,
+	}, nil
+}
+
+// Rename changes the name of the key from to the name to.
+func (j *JSONFormatter) Rename(from, to string) *JSONFormatter {
+	j.Names[from] = to
+	return j
+}
+
+// Remove don't serialize the keys.
+func (j *JSONFormatter) Remove(keys ...string) *JSONFormatter {
+	for _, k := range keys {
+		j.Omit[k] = true
+	}
+	return j
+}
+
+// Mark: json don't have marks.
+func (j *JSONFormatter) Mark(mark string) {}
+
+// Template: json don't have template.
+func (j *JSONFormatter) Template(t string) {}
+
+func (j *JSONFormatter) name(key string) string {
+	if n, found := j.Names[key]; found {
+		return n
+	}
+	return key
+}
+
+func (j *JSONFormatter) value(val reflect.Value) interface{} {
+	if !val.IsValid() {
+		return nil
+	}
+	if val.Kind() == reflect.Interface {
+		if val.IsNil() {
+			return nil
+		}
+		val = val.Elem()
+	}
+	switch i := val.Interface().(type) {
+	case time.Time:
+		return i.Format(j.TimeFormat)
+	case FieldMap:
+		if len(i) == 0 {
+			return nil
+		}
+		m := make(map[string]interface{}, len(i))
+		for k, v := range i {
+			m[k] = j.value(reflect.ValueOf(v))
+		}
+		return m
+	case json.Marshaler:
+		return i
+	case fmt.Stringer:
+		if val.Kind() == reflect.Ptr && val.IsNil() {
+			return nil
+		}
+		return i.String()
+	case error:
+		return i.Error()
+	}
+	val = reflect.Indirect(val)
+	if !val.IsValid() {
+		return nil
+	}
+	return val.Interface()
+}
+
+func isEmpty(i interface{}) bool {
+	switch v := i.(type) {
+	case nil:
+		return true
+	case string:
+		return v == ""
+	}
+	return false
+}
+
+func writeKeyVal(buf *bytes.Buffer, first bool, key string, val interface{}) error {
+	k, err := json.Marshal(key)
+	if err != nil {
+		return e.New(err)
+	}
+	v, err := json.Marshal(val)
+	if err != nil {
+		v, err = json.Marshal(fmt.Sprint(val))
+		if err != nil {
+			return e.New(err)
+		}
+	}
+	if !first {
+		buf.WriteByte(',')
+	}
+	buf.Write(k)
+	buf.WriteByte(':')
+	buf.Write(v)
+	return nil
+}
+
+// Format serializes the entry in one line with a JSON object.
+func (j *JSONFormatter) Format(entry Entry) (out []byte, err error) {
+	val := reflect.Indirect(reflect.ValueOf(entry))
+	if val.Kind() != reflect.Struct {
+		return nil, e.New("formater only accept entries that are structs ")
+	}
+
+	if val.Type() != reflect.Indirect(reflect.ValueOf(j.E)).Type() {
+		return nil, e.New(ErrNotSupported)
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 0, 256))
+	buf.WriteByte('{')
+	first := true
+	t := val.Type()
+	for i := 0; i < t.NumField(); i++ {
+		key := t.Field(i).Tag.Get("log")
+		if key == "" || j.Omit[key] {
+			continue
+		}
+		v := j.value(val.Field(i))
+		if isEmpty(v) {
+			def := t.Field(i).Tag.Get("def")
+			if def == "" {
+				continue
+			}
+			v = def
+		}
+		err = writeKeyVal(buf, first, j.name(key), v)
+		if err != nil {
+			return nil, e.Forward(err)
+		}
+		first = false
+	}
+
+	keys := make([]string, 0, len(j.Map))
+	for k := range j.Map {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if _, found := j.Idx[k]; found || j.Omit[k] {
+			continue
+		}
+		err = writeKeyVal(buf, first, j.name(k), j.value(reflect.ValueOf(j.Map[k])))
+		if err != nil {
+			return nil, e.Forward(err)
+		}
+		first = false
+	}
+	buf.WriteString("}\n")
+	return buf.Bytes(), nil
+}
+
+func (j *JSONFormatter) Entry(entry Entry) {
+	j.E = entry
+	j.Idx = mkindex(entry)
+}
+
+func (j *JSONFormatter) NewEntry(b LogBackend) Logger {
+	return deepcopy.Iface(j.E).(Logger).SetStore(b)
+}
+
+func (j *JSONFormatter) SetTimeFormat(format string) {
+	if format == "" {
+		j.TimeFormat = JSONTimeFormat
+		return
+	}
+	j.TimeFormat = format
+}
@@ -0,0 +1,94 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	golog "log"
+	"testing"
+	"time"
+
+	"github.com/fcavani/e"
+	"github.com/fcavani/tags"
+)
+
+func TestJSONFormatter(t *testing.T) {
+	f, err := NewJSONFormatter(
+		&log{Labels: &tags.Tags{}},
+		map[string]interface{}{"host": "myhost"},
+		"",
+	)
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	f.Rename("msg", "message").Remove("func", "pkg")
+
+	buf := bytes.NewBuffer([]byte{})
+	logger := New(NewWriter(buf).F(f), false).Domain("test")
+	logger.With("user_id", 42).Print("json test")
+	logger.Tag("tag1").Print("second line")
+
+	line, err := buf.ReadBytes('\n')
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	m := make(map[string]interface{})
+	err = json.Unmarshal(line, &m)
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)), string(line))
+	}
+	if m["message"] != "json test" {
+		t.Fatal("message not renamed", string(line))
+	}
+	if _, found := m["func"]; found {
+		t.Fatal("func not removed", string(line))
+	}
+	if m["domain"] != "test" || m["host"] != "myhost" || m["tags"] != "no tags" {
+		t.Fatal("invalid object", string(line))
+	}
+	if m["level"] != NoPrio.String() {
+		t.Fatal("invalid level", string(line))
+	}
+	if _, err := time.Parse(JSONTimeFormat, m["date"].(string)); err != nil {
+		t.Fatal("invalid date", string(line))
+	}
+	fields, ok := m["fields"].(map[string]interface{})
+	if !ok || fields["user_id"] != float64(42) {
+		t.Fatal("invalid fields", string(line))
+	}
+
+	line, err = buf.ReadBytes('\n')
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	m = make(map[string]interface{})
+	err = json.Unmarshal(line, &m)
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)), string(line))
+	}
+	if m["message"] != "second line" || m["tags"] != "tag1" {
+		t.Fatal("invalid object", string(line))
+	}
+	if buf.Len() != 0 {
+		t.Fatal("more than one line per entry")
+	}
+
+	buf.Reset()
+	New(NewSendToLogger(golog.New(buf, "", 0)).F(f), false).Print("go log")
+	if n := bytes.Count(buf.Bytes(), []byte{'\n'}); n != 1 {
+		t.Fatalf("wrong number of lines %v: %q", n, buf.String())
+	}
+}
+
+func TestJSONFormatterInvalid(t *testing.T) {
+	f, _ := NewJSONFormatter(&entryTest{}, nil, "")
+	_, err := f.Format(&entryTest2{})
+	if err != nil && !e.Equal(err, ErrNotSupported) {
+		t.Fatal(e.Trace(e.Forward(err)))
+	} else if err == nil {
+		t.Fatal("nil")
+	}
+}
@@ -0,0 +1,314 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fcavani/e"
+)
+
+const ErrGELFClosed = "gelf backend is closed"
+
+// GELFCompression is the compression of the GELF messages sent over udp.
+type GELFCompression uint8
+
+const (
+	// GELFGzip compress the messages with gzip.
+	GELFGzip GELFCompression = iota
+	// GELFZlib compress the messages with zlib.
+	GELFZlib
+	// GELFNoCompression sends the messages uncompressed.
+	GELFNoCompression
+)
+
+// GELFChunkSize is the default max size of the datagrams.
+const GELFChunkSize = 1420
+
+const gelfMaxChunks = 128
+
+// gelfChunkHeader is the size of the header of the chunks: the magic
+// bytes, the id of the message, the sequence number and the count.
+const gelfChunkHeader = 12
+
+// GELFOptions configures the GELF backend.
+type GELFOptions struct {
+	// Host is the host of the messages. Default is os.Hostname.
+	Host string
+	// Compression of the messages sent over udp. Messages sent over tcp
+	// are never compressed.
+	Compression GELFCompression
+	// ChunkSize is the max size of the datagrams. Bigger messages are
+	// chunked. Default is GELFChunkSize.
+	ChunkSize int
+	// TLS enables TLS for tcp.
+	TLS *tls.Config
+	// DialTimeout and WriteTimeout are the timeouts of the connection.
+	// Default 5s.
+	DialTimeout  time.Duration
+	WriteTimeout time.Duration
+}
+
+// GELF sends the entries to Graylog, or any server that understands GELF
+// 1.1. With udp each message is compressed and, if needed, chunked. With
+// tcp the messages are uncompressed and delimited by a null byte.
+type GELF struct {
+	opts GELFOptions
+	r    ruleHolder
+	handlerHolder
+
+	lck    sync.Mutex
+	conn   *remoteConn
+	closed bool
+	// id and seq are the id of the chunked messages.
+	id  [4]byte
+	seq uint32
+}
+
+// NewGELF creates a backend that sends the entries to the GELF server in
+// addr. network is udp or tcp, or its variations with 4 and 6. The
+// connection is made in the first commit.
+func NewGELF(network, addr string, opts GELFOptions) (*GELF, error) {
+	switch network {
+	case "udp", "udp4", "udp6", "tcp", "tcp4", "tcp6":
+	default:
+		return nil, e.New("network %v isn't supported", network)
+	}
+	conn, err := newRemoteConn(network, addr, opts.TLS, opts.DialTimeout, opts.WriteTimeout)
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	if opts.Compression > GELFNoCompression || opts.ChunkSize < 0 ||
+		(opts.ChunkSize > 0 && opts.ChunkSize <= gelfChunkHeader) {
+		return nil, e.New("invalid options")
+	}
+	if opts.Host == "" {
+		opts.Host = hostname()
+	}
+	if opts.ChunkSize == 0 {
+		opts.ChunkSize = GELFChunkSize
+	}
+	g := &GELF{
+		opts: opts,
+		conn: conn,
+	}
+	_, err = rand.Read(g.id[:])
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	return g, nil
+}
+
+// F: GELF don't need a formatter.
+func (g *GELF) F(f Formatter) LogBackend {
+	return g
+}
+
+// GetF always return nil, GELF don't need a formatter.
+func (g *GELF) GetF() Formatter {
+	return nil
+}
+
+func (g *GELF) Filter(r Ruler) LogBackend {
+	g.r.Set(precompile(r))
+	return g
+}
+
+func (g *GELF) GetFilter() Ruler {
+	return g.r.Get()
+}
+
+// gelfName converts name to a valid name of an additional field. The
+// field _id is reserved, so the field id is sent as __id.
+func gelfName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9',
+			r == '_', r == '.', r == '-':
+			return r
+		}
+		return '_'
+	}, name)
+	if name == "id" {
+		return "__id"
+	}
+	return "_" + name
+}
+
+// gelfValue returns the value of a field. GELF only have strings and
+// numbers, NaN and the infinities are sent as strings.
+func gelfValue(v interface{}) interface{} {
+	// time.Duration and the levels are numbers with a name.
+	if _, ok := v.(interface {
+		String() string
+	}); ok {
+		return fieldString(v)
+	}
+	val := reflect.Indirect(reflect.ValueOf(v))
+	switch val.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return val.Interface()
+	case reflect.Float32, reflect.Float64:
+		if f := val.Float(); math.IsNaN(f) || math.IsInf(f, 0) {
+			return strconv.FormatFloat(f, 'g', -1, 64)
+		}
+		return val.Interface()
+	}
+	return fieldString(v)
+}
+
+// Message returns the entry encoded in GELF, uncompressed and without
+// the null byte.
+func (g *GELF) Message(entry Entry) ([]byte, error) {
+	msg := make(map[string]interface{})
+	for k, v := range entry.GetFields() {
+		msg[gelfName(k)] = gelfValue(v)
+	}
+	full := strings.TrimRight(entry.Message(), "\r\n")
+	short := full
+	if i := strings.IndexAny(full, "\r\n"); i >= 0 {
+		short = full[:i]
+		msg["full_message"] = full
+	}
+	if short == "" {
+		short = "-"
+	}
+	msg["version"] = "1.1"
+	msg["host"] = g.opts.Host
+	msg["short_message"] = short
+	msg["timestamp"] = float64(entry.Date().UnixNano()/1e3) / 1e6
+	msg["level"] = int(severity(entry.Level()))
+	if dom := entry.GetDomain(); dom != "" {
+		msg["_domain"] = dom
+	}
+	if t := entry.Tags(); t != nil && t.String() != "" {
+		msg["_tags"] = t.String()
+	}
+	file, pkg, fn := entryCode(entry)
+	if file != "" {
+		msg["_file"] = file
+	}
+	if pkg != "" {
+		msg["_pkg"] = pkg
+	}
+	if fn != "" {
+		msg["_func"] = fn
+	}
+	buf, err := json.Marshal(msg)
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	return buf, nil
+}
+
+// compress compress the message for udp.
+func (g *GELF) compress(msg []byte) ([]byte, error) {
+	var w io.WriteCloser
+	buf := bytes.NewBuffer(make([]byte, 0, len(msg)/2))
+	switch g.opts.Compression {
+	case GELFGzip:
+		w = gzip.NewWriter(buf)
+	case GELFZlib:
+		w = zlib.NewWriter(buf)
+	default:
+		return msg, nil
+	}
+	_, err := w.Write(msg)
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	err = w.Close()
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	return buf.Bytes(), nil
+}
+
+// chunks splits the message in datagrams.
+func (g *GELF) chunks(msg []byte) ([][]byte, error) {
+	if len(msg) <= g.opts.ChunkSize {
+		return [][]byte{msg}, nil
+	}
+	size := g.opts.ChunkSize - gelfChunkHeader
+	count := (len(msg) + size - 1) / size
+	if count > gelfMaxChunks {
+		return nil, e.New("message too big: %v bytes", len(msg))
+	}
+	g.seq++
+	id := make([]byte, 8)
+	copy(id, g.id[:])
+	binary.BigEndian.PutUint32(id[4:], g.seq)
+	chunks := make([][]byte, 0, count)
+	for i := 0; i < count; i++ {
+		data := msg[i*size:]
+		if len(data) > size {
+			data = data[:size]
+		}
+		chunk := make([]byte, 0, gelfChunkHeader+len(data))
+		chunk = append(chunk, 0x1e, 0x0f)
+		chunk = append(chunk, id...)
+		chunk = append(chunk, byte(i), byte(count))
+		chunks = append(chunks, append(chunk, data...))
+	}
+	return chunks, nil
+}
+
+func (g *GELF) Commit(entry Entry) {
+	if !g.r.Pass(entry) {
+		gelfMetrics.filter()
+		return
+	}
+	var err error
+	defer gelfMetrics.commit(time.Now(), &err)
+	msg, err := g.Message(entry)
+	if err != nil {
+		HandleError(g, entry, err)
+		return
+	}
+	g.lck.Lock()
+	if g.closed {
+		err = e.New(ErrGELFClosed)
+	} else if g.conn.packet {
+		var bufs [][]byte
+		msg, err = g.compress(msg)
+		if err == nil {
+			bufs, err = g.chunks(msg)
+		}
+		if err == nil {
+			err = g.conn.write(bufs)
+		}
+	} else {
+		err = g.conn.write([][]byte{append(msg, 0)})
+	}
+	g.lck.Unlock()
+	if err != nil {
+		HandleError(g, entry, err)
+	}
+}
+
+func (g *GELF) Close() error {
+	g.lck.Lock()
+	defer g.lck.Unlock()
+	g.closed = true
+	err := g.conn.close()
+	if err != nil {
+		return e.Forward(err)
+	}
+	return nil
+}
@@ -0,0 +1,229 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"math"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fcavani/e"
+)
+
+func decodeGELF(t *testing.T, r io.Reader) map[string]interface{} {
+	var msg map[string]interface{}
+	err := json.NewDecoder(r).Decode(&msg)
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	return msg
+}
+
+func TestGELFTCP(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	defer l.Close()
+	g, err := NewGELF("tcp", l.Addr().String(), GELFOptions{Host: "host"})
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	defer g.Close()
+	logger := New(g, true).Domain("api").Tag("db").With("user", "bob").With("n", 42).With("id", "x").
+		With("nan", math.NaN()).With("inf", math.Inf(-1))
+	logger.Error("one\ntwo\n")
+	logger.Println("three")
+
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(conn)
+
+	buf, err := r.ReadBytes(0)
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	msg := decodeGELF(t, bytes.NewReader(buf[:len(buf)-1]))
+	want := map[string]interface{}{
+		"version":       "1.1",
+		"host":          "host",
+		"short_message": "one",
+		"full_message":  "one\ntwo",
+		"level":         3.0,
+		"_domain":       "api",
+		"_tags":         "db",
+		"_user":         "bob",
+		"_n":            42.0,
+		"__id":          "x",
+		"_nan":          "NaN",
+		"_inf":          "-Inf",
+		"_func":         "github.com/fcavani/log.TestGELFTCP",
+	}
+	for k, v := range want {
+		if msg[k] != v {
+			t.Fatalf("wrong field %v: %#v, want %#v", k, msg[k], v)
+		}
+	}
+	if file, _ := msg["_file"].(string); !strings.Contains(file, "gelf_test.go:") {
+		t.Fatal("wrong file", msg["_file"])
+	}
+	ts, _ := msg["timestamp"].(float64)
+	if d := time.Since(time.Unix(int64(ts), 0)); d < -time.Second || d > time.Minute {
+		t.Fatal("wrong timestamp", msg["timestamp"])
+	}
+
+	buf, err = r.ReadBytes(0)
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	msg = decodeGELF(t, bytes.NewReader(buf[:len(buf)-1]))
+	if msg["short_message"] != "three" || msg["level"] != 5.0 || msg["full_message"] != nil {
+		t.Fatal("wrong message", msg)
+	}
+
+	var failed error
+	g.SetErrorHandler(ErrorHandlerFunc(func(bak LogBackend, entry Entry, err error) {
+		failed = err
+	}))
+	err = g.Close()
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	logger.Print("closed")
+	if !e.Equal(failed, ErrGELFClosed) {
+		t.Fatal("wrong error", failed)
+	}
+}
+
+func TestGELFUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	defer conn.Close()
+	g, err := NewGELF("udp", conn.LocalAddr().String(), GELFOptions{})
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	defer g.Close()
+	New(g, false).Domain("test").Print("one")
+
+	buf := make([]byte, 65536)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	r, err := gzip.NewReader(bytes.NewReader(buf[:n]))
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	msg := decodeGELF(t, r)
+	if msg["short_message"] != "one" || msg["_domain"] != "test" || msg["host"] != hostname() {
+		t.Fatal("wrong message", msg)
+	}
+
+	for _, network := range []string{"unix", "unixgram", "ip"} {
+		_, err = NewGELF(network, "127.0.0.1:1", GELFOptions{})
+		if err == nil {
+			t.Fatal("nil error for", network)
+		}
+	}
+	for _, opts := range []GELFOptions{
+		{Compression: GELFNoCompression + 1},
+		{ChunkSize: 12},
+		{TLS: &tls.Config{}},
+	} {
+		_, err = NewGELF("udp", "127.0.0.1:1", opts)
+		if err == nil {
+			t.Fatalf("nil error for %+v", opts)
+		}
+	}
+}
+
+func TestGELFChunked(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	defer conn.Close()
+	g, err := NewGELF("udp", conn.LocalAddr().String(), GELFOptions{
+		Compression: GELFZlib,
+		ChunkSize:   1000,
+	})
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	defer g.Close()
+	// Random data doesn't compress.
+	data := make([]byte, 6000)
+	_, err = rand.Read(data)
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	big := base64.StdEncoding.EncodeToString(data)
+	New(g, false).Print(big)
+
+	var chunks [][]byte
+	var id []byte
+	for count := 1; len(chunks) < count; {
+		buf := make([]byte, 65536)
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(e.Trace(e.Forward(err)))
+		}
+		buf = buf[:n]
+		if n > 1000 || buf[0] != 0x1e || buf[1] != 0x0f {
+			t.Fatal("wrong chunk", n, buf[:2])
+		}
+		if id == nil {
+			id = buf[2:10]
+			count = int(buf[11])
+			chunks = make([][]byte, 0, count)
+		}
+		if !bytes.Equal(id, buf[2:10]) || int(buf[10]) != len(chunks) || int(buf[11]) != count {
+			t.Fatal("wrong chunk header", buf[:12])
+		}
+		chunks = append(chunks, buf[12:])
+	}
+	if len(chunks) < 7 {
+		t.Fatal("too few chunks", len(chunks))
+	}
+	r, err := zlib.NewReader(bytes.NewReader(bytes.Join(chunks, nil)))
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	msg := decodeGELF(t, r)
+	if msg["short_message"] != big {
+		t.Fatal("wrong message")
+	}
+
+	// Too big for 128 chunks.
+	var failed error
+	g.SetErrorHandler(ErrorHandlerFunc(func(bak LogBackend, entry Entry, err error) {
+		failed = err
+	}))
+	g.opts.ChunkSize = 20
+	New(g, false).Print(big)
+	if failed == nil || !strings.Contains(failed.Error(), "too big") {
+		t.Fatal("wrong error", failed)
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/fcavani/e"
+)
+
+// KeyTimeFormat is the format of the time in the keys of the entries. It
+// has fixed width and it is always in UTC, so the keys sort in the same
+// order of the time.
+const KeyTimeFormat = "2006-01-02T15:04:05.000000000Z"
+
+const ErrInvID = "invalid entry id"
+
+var idSeq uint64
+var idNode string
+
+func init() {
+	buf := make([]byte, 4)
+	_, err := rand.Read(buf)
+	if err != nil {
+		buf = []byte(strconv.FormatInt(time.Now().UnixNano(), 16))[:4]
+	}
+	idNode = hex.EncodeToString(buf)
+}
+
+// timeKey is the key of the entries with date t in the store. Any id of
+// an entry with date t is greater than timeKey(t), so Seek(timeKey(t))
+// finds the first entry at t.
+func timeKey(t time.Time) string {
+	return t.UTC().Format(KeyTimeFormat)
+}
+
+// NewID creates a new unique id for an entry logged at t. The id is
+// composed by the time in UTC, a random identifier of the process and
+// a sequence number, the ids are ordered by time and, for the same
+// process, by the order of creation.
+func NewID(t time.Time) string {
+	seq := atomic.AddUint64(&idSeq, 1)
+	s := strconv.FormatUint(seq, 16)
+	return timeKey(t) + "-" + idNode + "-" + strings.Repeat("0", 16-len(s)) + s
+}
+
+// ParseID splits the id in its parts.
+func ParseID(id string) (t time.Time, node string, seq uint64, err error) {
+	parts := strings.Split(id, "-")
+	// The date have two dashes.
+	if len(parts) != 5 {
+		return time.Time{}, "", 0, e.New(ErrInvID)
+	}
+	t, err = time.Parse(KeyTimeFormat, strings.Join(parts[:3], "-"))
+	if err != nil {
+		return time.Time{}, "", 0, e.Push(e.New(ErrInvID), err)
+	}
+	node = parts[3]
+	seq, err = strconv.ParseUint(parts[4], 16, 64)
+	if err != nil {
+		return time.Time{}, "", 0, e.Push(e.New(ErrInvID), err)
+	}
+	return t, node, seq, nil
+}
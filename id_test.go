@@ -0,0 +1,107 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fcavani/e"
+	"github.com/fcavani/tags"
+)
+
+func TestNewID(t *testing.T) {
+	now := time.Now()
+	ids := make([]string, 0, 1000)
+	var lck sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				id := NewID(now)
+				lck.Lock()
+				ids = append(ids, id)
+				lck.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	seen := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		if seen[id] {
+			t.Fatal("duplicated id", id)
+		}
+		seen[id] = true
+	}
+
+	loc := time.FixedZone("test", -3*3600)
+	id1 := NewID(now.In(loc))
+	id2 := NewID(now.Add(time.Nanosecond))
+	id3 := NewID(now.Add(time.Second).In(time.UTC))
+	if !sort.StringsAreSorted([]string{timeKey(now), id1, id2, id3}) {
+		t.Fatal("ids aren't ordered", id1, id2, id3)
+	}
+
+	date, node, seq, err := ParseID(id2)
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	if !date.Equal(now.Add(time.Nanosecond)) || node != idNode || seq == 0 {
+		t.Fatal("invalid id parts", date, node, seq)
+	}
+	_, _, _, err = ParseID("2019-01-01T00:00:00Z")
+	if err != nil && !e.Equal(err, ErrInvID) {
+		t.Fatal(e.Trace(e.Forward(err)))
+	} else if err == nil {
+		t.Fatal("nil error")
+	}
+}
+
+func TestGenericSameTime(t *testing.T) {
+	m, _ := NewMap(100)
+	g := NewGeneric(m).F(DefFormatter)
+	now := time.Now()
+	for i := 0; i < 100; i++ {
+		g.Commit(&log{
+			Ident:     NewID(now),
+			Timestamp: now,
+			Labels:    &tags.Tags{},
+			Msg:       "same time",
+		})
+	}
+	// Without id.
+	for i := 0; i < 100; i++ {
+		g.Commit(&log{
+			Timestamp: now,
+			Labels:    &tags.Tags{},
+			Msg:       "same time",
+		})
+	}
+	l, err := m.Len()
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	if l != 200 {
+		t.Fatal("entries lost", l)
+	}
+	err = m.Tx(false, func(tx Transaction) error {
+		k, _ := tx.Cursor().Seek(timeKey(now))
+		if k == "" {
+			return e.New("seek failed")
+		}
+		k, _ = tx.Cursor().Seek(timeKey(now.Add(time.Nanosecond)))
+		if k != "" {
+			return e.New("seek after the last entry must fail")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+}
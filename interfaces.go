@@ -13,6 +13,8 @@ import (
 )
 
 type Entry interface {
+	// ID returns the unique id of the log entry
+	ID() string
 	// Date returns the time stamp of the log
 	Date() time.Time
 	// Level return the log level
@@ -43,8 +45,12 @@ type Entry interface {
 	EntryLevel(l Level) Logger
 	// DebugInfo write into the struct debug information.
 	DebugInfo() Logger
-	// TODO: Context info
-	// Context(ctx map[string]interface)
+	// With attach one key/value field to the log entry.
+	With(key string, val interface{}) Logger
+	// Fields attach all key/value pairs in m to the log entry.
+	Fields(m map[string]interface{}) Logger
+	// GetFields returns the key/value fields of the log entry.
+	GetFields() map[string]interface{}
 }
 
 type TemplateSetup interface {
@@ -82,6 +88,18 @@ type LogBackend interface {
 	Close() error
 }
 
+// FilterGetter is implemented by the backends that can return the Ruler
+// set with Filter.
+type FilterGetter interface {
+	GetFilter() Ruler
+}
+
+// Multiplexer is implemented by the backends that commit the entries to
+// others backends.
+type Multiplexer interface {
+	Backends() []LogBackend
+}
+
 type Cursor interface {
 	First() (key string, data interface{})
 	Last() (key string, data interface{})
@@ -179,6 +197,12 @@ type Logger interface {
 	Error(...interface{})
 	Errorf(string, ...interface{})
 	Errorln(...interface{})
+	// UnsetLevel removes the log Level of the package scope. For the scope
+	// all every level is logged.
+	UnsetLevel(scope string) Logger
+	// GetLevels returns the level for all packages and the levels of each
+	// package.
+	GetLevels() (def Level, levels map[string]Level)
 }
 
 // Logfmter encode a log entry in logfmt format.
@@ -0,0 +1,217 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fcavani/e"
+)
+
+const ErrJournaldClosed = "journald backend is closed"
+
+// JournaldSocket is the socket of the native protocol of journald.
+const JournaldSocket = "/run/systemd/journal/socket"
+
+// JournaldOptions configures the Journald backend.
+type JournaldOptions struct {
+	// Path is the socket of journald. Default is JournaldSocket.
+	Path string
+	// Identifier is the SYSLOG_IDENTIFIER. Default is the name of the
+	// program.
+	Identifier string
+}
+
+// Journald sends the entries to journald with its native protocol. The
+// entries have the fields MESSAGE, PRIORITY, SYSLOG_IDENTIFIER,
+// CODE_FILE, CODE_LINE, CODE_FUNC, DOMAIN, TAGS and the fields of the
+// entry with the names in upper case and the prefix F_, so they don't
+// replace the fields of journald. The entries too big for one datagram are
+// sent in a temporary file.
+type Journald struct {
+	opts JournaldOptions
+	r    ruleHolder
+	handlerHolder
+
+	lck    sync.Mutex
+	conn   *net.UnixConn
+	closed bool
+}
+
+// NewJournald creates the backend. The socket is opened in the first
+// commit.
+func NewJournald(opts JournaldOptions) *Journald {
+	if opts.Path == "" {
+		opts.Path = JournaldSocket
+	}
+	if opts.Identifier == "" {
+		opts.Identifier = filepath.Base(os.Args[0])
+	}
+	return &Journald{
+		opts: opts,
+	}
+}
+
+// F: journald don't need a formatter.
+func (j *Journald) F(f Formatter) LogBackend {
+	return j
+}
+
+// GetF always return nil, journald don't need a formatter.
+func (j *Journald) GetF() Formatter {
+	return nil
+}
+
+func (j *Journald) Filter(r Ruler) LogBackend {
+	j.r.Set(precompile(r))
+	return j
+}
+
+func (j *Journald) GetFilter() Ruler {
+	return j.r.Get()
+}
+
+// journaldName converts the name of a field of the entry to a valid field
+// name: upper case letters, digits and underscores, with the prefix F_.
+func journaldName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, name)
+	name = "F_" + name
+	if len(name) > 64 {
+		name = name[:64]
+	}
+	return name
+}
+
+// journaldField writes one field. The values with new lines are written
+// with its size.
+func journaldField(buf *bytes.Buffer, name, val string) {
+	if name == "" {
+		return
+	}
+	buf.WriteString(name)
+	if strings.IndexByte(val, '\n') == -1 {
+		buf.WriteByte('=')
+		buf.WriteString(val)
+		buf.WriteByte('\n')
+		return
+	}
+	buf.WriteByte('\n')
+	size := make([]byte, 8)
+	binary.LittleEndian.PutUint64(size, uint64(len(val)))
+	buf.Write(size)
+	buf.WriteString(val)
+	buf.WriteByte('\n')
+}
+
+// Payload returns the entry encoded in the native protocol.
+func (j *Journald) Payload(entry Entry) []byte {
+	buf := bytes.NewBuffer(make([]byte, 0, 256))
+	journaldField(buf, "MESSAGE", strings.TrimRight(entry.Message(), "\n"))
+	journaldField(buf, "PRIORITY", strconv.Itoa(int(severity(entry.Level()))))
+	journaldField(buf, "SYSLOG_IDENTIFIER", j.opts.Identifier)
+	file, _, fn := entryCode(entry)
+	// The file has the line number, like file.go:42.
+	if n := strings.LastIndex(file, ":"); n > 0 {
+		if _, err := strconv.Atoi(file[n+1:]); err == nil {
+			journaldField(buf, "CODE_LINE", file[n+1:])
+			file = file[:n]
+		}
+	}
+	if file != "" {
+		journaldField(buf, "CODE_FILE", file)
+	}
+	if fn != "" {
+		journaldField(buf, "CODE_FUNC", fn)
+	}
+	if dom := entry.GetDomain(); dom != "" {
+		journaldField(buf, "DOMAIN", dom)
+	}
+	if t := entry.Tags(); t != nil && t.String() != "" {
+		journaldField(buf, "TAGS", t.String())
+	}
+	fields := entry.GetFields()
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		journaldField(buf, journaldName(k), fieldString(fields[k]))
+	}
+	return buf.Bytes()
+}
+
+// send writes the payload, opening the socket if needed. j.lck must be
+// locked.
+func (j *Journald) send(payload []byte) error {
+	if j.conn == nil {
+		conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: j.opts.Path, Net: "unixgram"})
+		if err != nil {
+			return e.Forward(err)
+		}
+		j.conn = conn
+	}
+	_, err := j.conn.Write(payload)
+	if err != nil && isTooBig(err) {
+		err = sendFile(j.conn, payload)
+	}
+	if err != nil {
+		j.conn.Close()
+		j.conn = nil
+		return e.Forward(err)
+	}
+	return nil
+}
+
+func (j *Journald) Commit(entry Entry) {
+	if !j.r.Pass(entry) {
+		journaldMetrics.filter()
+		return
+	}
+	var err error
+	defer journaldMetrics.commit(time.Now(), &err)
+	j.lck.Lock()
+	if j.closed {
+		err = e.New(ErrJournaldClosed)
+	} else {
+		err = j.send(j.Payload(entry))
+	}
+	j.lck.Unlock()
+	if err != nil {
+		HandleError(j, entry, err)
+	}
+}
+
+func (j *Journald) Close() error {
+	j.lck.Lock()
+	defer j.lck.Unlock()
+	j.closed = true
+	if j.conn == nil {
+		return nil
+	}
+	err := j.conn.Close()
+	j.conn = nil
+	if err != nil {
+		return e.Forward(err)
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"syscall"
+
+	"github.com/fcavani/e"
+)
+
+// isTooBig returns true if the datagram is bigger than the max size of
+// the socket.
+func isTooBig(err error) bool {
+	op, ok := err.(*net.OpError)
+	if !ok {
+		return false
+	}
+	sys, ok := op.Err.(*os.SyscallError)
+	if !ok {
+		return false
+	}
+	return sys.Err == syscall.EMSGSIZE || sys.Err == syscall.ENOBUFS
+}
+
+// sendFile writes the payload in a temporary file, removed before it is
+// sent, and sends its descriptor to journald, like sd_journal_send does
+// when memfd isn't available.
+func sendFile(conn *net.UnixConn, payload []byte) error {
+	dir := "/dev/shm"
+	if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+		dir = os.TempDir()
+	}
+	f, err := ioutil.TempFile(dir, "journal.")
+	if err != nil {
+		return e.Forward(err)
+	}
+	defer f.Close()
+	err = os.Remove(f.Name())
+	if err != nil {
+		return e.Forward(err)
+	}
+	_, err = f.Write(payload)
+	if err != nil {
+		return e.Forward(err)
+	}
+	// WriteMsgUnix doesn't work with connected datagram sockets.
+	rc, err := conn.SyscallConn()
+	if err != nil {
+		return e.Forward(err)
+	}
+	oob := syscall.UnixRights(int(f.Fd()))
+	werr := rc.Write(func(fd uintptr) bool {
+		err = syscall.Sendmsg(int(fd), nil, oob, nil, 0)
+		return err != syscall.EAGAIN
+	})
+	if werr != nil {
+		return e.Forward(werr)
+	}
+	if err != nil {
+		return e.Forward(err)
+	}
+	return nil
+}
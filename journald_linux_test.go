@@ -0,0 +1,132 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/fcavani/e"
+)
+
+// parseJournald decodes the fields of the native protocol.
+func parseJournald(t *testing.T, buf []byte) map[string]string {
+	fields := make(map[string]string)
+	for len(buf) > 0 {
+		i := bytes.IndexAny(buf, "=\n")
+		if i < 0 {
+			t.Fatalf("invalid payload %q", buf)
+		}
+		name := string(buf[:i])
+		if buf[i] == '=' {
+			end := bytes.IndexByte(buf, '\n')
+			fields[name] = string(buf[i+1 : end])
+			buf = buf[end+1:]
+			continue
+		}
+		size := binary.LittleEndian.Uint64(buf[i+1 : i+9])
+		fields[name] = string(buf[i+9 : i+9+int(size)])
+		buf = buf[i+9+int(size)+1:]
+	}
+	return fields
+}
+
+// readJournald reads one datagram, or the file sent with it.
+func readJournald(t *testing.T, conn *net.UnixConn) map[string]string {
+	buf := make([]byte, 1<<20)
+	oob := make([]byte, syscall.CmsgSpace(4))
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, oobn, _, _, err := conn.ReadMsgUnix(buf, oob)
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	if oobn == 0 {
+		return parseJournald(t, buf[:n])
+	}
+	msgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	fds, err := syscall.ParseUnixRights(&msgs[0])
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	f := os.NewFile(uintptr(fds[0]), "journal")
+	defer f.Close()
+	f.Seek(0, 0)
+	payload, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	return parseJournald(t, payload)
+}
+
+func TestJournald(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journald")
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "socket")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: name, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	defer conn.Close()
+
+	j := NewJournald(JournaldOptions{Path: name, Identifier: "test"})
+	defer j.Close()
+	logger := New(j, true).Domain("api").Tag("db").With("user-id", 42).With("message", "field")
+	logger.Error("one\ntwo")
+
+	fields := readJournald(t, conn)
+	want := map[string]string{
+		"MESSAGE":           "one\ntwo",
+		"PRIORITY":          "3",
+		"SYSLOG_IDENTIFIER": "test",
+		"CODE_FUNC":         "github.com/fcavani/log.TestJournald",
+		"DOMAIN":            "api",
+		"TAGS":              "db",
+		"F_USER_ID":         "42",
+		"F_MESSAGE":         "field",
+	}
+	for k, v := range want {
+		if fields[k] != v {
+			t.Fatalf("wrong field %v: %q, want %q", k, fields[k], v)
+		}
+	}
+	if !strings.HasSuffix(fields["CODE_FILE"], "journald_linux_test.go") || fields["CODE_LINE"] == "" {
+		t.Fatalf("wrong code %q %q", fields["CODE_FILE"], fields["CODE_LINE"])
+	}
+
+	// Bigger than the max size of the datagrams.
+	big := strings.Repeat("x", 1<<19)
+	New(j, false).Print(big)
+	fields = readJournald(t, conn)
+	if fields["MESSAGE"] != big || fields["PRIORITY"] != "5" {
+		t.Fatal("wrong big entry", len(fields["MESSAGE"]), fields["PRIORITY"])
+	}
+
+	var failed error
+	j.SetErrorHandler(ErrorHandlerFunc(func(bak LogBackend, entry Entry, err error) {
+		failed = err
+	}))
+	err = j.Close()
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	New(j, false).Print("closed")
+	if !e.Equal(failed, ErrJournaldClosed) {
+		t.Fatal("wrong error", failed)
+	}
+}
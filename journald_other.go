@@ -0,0 +1,22 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+//go:build !linux
+// +build !linux
+
+package log
+
+import (
+	"net"
+
+	"github.com/fcavani/e"
+)
+
+func isTooBig(err error) bool {
+	return false
+}
+
+func sendFile(conn *net.UnixConn, payload []byte) error {
+	return e.New("entry too big")
+}
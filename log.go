@@ -24,42 +24,51 @@ var Log Logger
 
 var DefFormatter Formatter
 
-func init() {
+// DefTemplate is the template of DefFormatter.
+const DefTemplate = "::host - ::domain - ::date - ::level - ::tags - ::file - ::msg"
+
+func hostname() string {
 	hostname, _ := os.Hostname()
 	if hostname == "" {
 		hostname = "no name"
 	}
+	return hostname
+}
+
+func init() {
 	DefFormatter, _ = NewStdFormatter(
 		"::",
-		"::host - ::domain - ::date - ::level - ::tags - ::file - ::msg",
+		DefTemplate,
 		&log{Labels: &tags.Tags{}},
 		map[string]interface{}{
-			"host": hostname,
+			"host": hostname(),
 		},
 		"",
 	)
 	Log = New(
-		NewWriter(os.Stdout).F(DefFormatter),
+		config.backend(DefFormatter),
 		false,
 	).SetLevel("all", InfoPrio)
 
 }
 
 type log struct {
-	Timestamp time.Time  `bson:"key" log:"date"`
-	Priority  Level      `log:"level"`
-	Labels    *tags.Tags `log:"tags" def:"no tags"`
-	Msg       string     `log:"msg"`
-	Dom       string     `log:"domain"`
-	E         error
+	Ident     string     `bson:"id" log:"id" json:"id"`
+	Timestamp time.Time  `bson:"key" log:"date" json:"date"`
+	Priority  Level      `log:"level" json:"level"`
+	Labels    *tags.Tags `log:"tags" def:"no tags" json:"tags,omitempty"`
+	Msg       string     `log:"msg" json:"msg"`
+	Dom       string     `log:"domain" json:"domain,omitempty"`
+	E         error      `json:"-"`
 	f         Formatter
 	store     LogBackend
-	Debug     bool
-	File      string `log:"file"`
-	Pkg       string `log:"pkg"`
-	Func      string `log:"func"`
-	Levels    map[string]*If
-	DefLevel  Ruler
+	Debug     bool           `json:"-"`
+	File      string         `log:"file" json:"file,omitempty"`
+	Pkg       string         `log:"pkg" json:"pkg,omitempty"`
+	Func      string         `log:"func" json:"func,omitempty"`
+	Flds      FieldMap       `bson:"fields,omitempty" log:"fields" json:"fields,omitempty"`
+	Levels    map[string]*If `json:"-"`
+	DefLevel  Ruler          `json:"-"`
 	lck       sync.Mutex
 }
 
@@ -72,6 +81,7 @@ func init() {
 		}()
 		gob.Register(&log{})
 		types.Insert(&log{})
+		gob.Register(time.Time{})
 	})
 }
 
@@ -87,7 +97,11 @@ func New(b LogBackend, debug bool) *log {
 }
 
 func (l *log) Logfmt(enc *logfmt.Encoder) error {
-	err := enc.EncodeKeyval("date", l.Timestamp)
+	err := enc.EncodeKeyval("id", l.Ident)
+	if err != nil {
+		return e.Forward(err)
+	}
+	err = enc.EncodeKeyval("date", l.Timestamp)
 	if err != nil {
 		return e.Forward(err)
 	}
@@ -111,6 +125,10 @@ func (l *log) Logfmt(enc *logfmt.Encoder) error {
 	if err != nil {
 		return e.Forward(err)
 	}
+	err = l.Flds.Logfmt(enc)
+	if err != nil {
+		return e.Forward(err)
+	}
 	err = enc.EndRecord()
 	if err != nil {
 		return e.Forward(err)
@@ -122,17 +140,20 @@ func (l *log) clone() *log {
 	l.lck.Lock()
 	defer l.lck.Unlock()
 	return &log{
+		Ident:     l.Ident,
 		Timestamp: l.Timestamp,
 		Priority:  l.Priority,
 		Labels:    l.Labels.Copy(),
 		Msg:       l.Msg,
 		Dom:       l.Dom,
 		E:         e.Copy(l.E),
+		f:         l.f,
 		store:     l.store,
 		Debug:     l.Debug,
 		File:      l.File,
 		Pkg:       l.Pkg,
 		Func:      l.Func,
+		Flds:      l.Flds.Copy(),
 		Levels:    l.Levels,
 		DefLevel:  l.DefLevel,
 	}
@@ -142,8 +163,15 @@ func (l *log) error(err error) {
 	if err == nil {
 		return
 	}
+	// The error goes to the error handler, not to the store, that can be
+	// the one failing.
 	n := l.clone()
-	n.FatalLevel().Domain("logger").Tag("internal").Tag("error").Println(err)
+	n.Priority = FatalPrio
+	n.Dom = "logger"
+	n.Labels.MergeFromStringSlice([]string{"internal", "error"})
+	n.Msg = err.Error()
+	n.stamp()
+	HandleError(l.store, n, err)
 }
 
 func (l *log) debugInfo(level int) {
@@ -177,6 +205,22 @@ func (l *log) DebugInfo() Logger {
 	return n
 }
 
+// commit counts the level of the entry and sends it to the backend.
+func (l *log) commit() {
+	countLevel(l.Priority)
+	l.store.Commit(l)
+}
+
+// stamp sets the time and the id of a new entry.
+func (l *log) stamp() {
+	l.Timestamp = time.Now()
+	l.Ident = NewID(l.Timestamp)
+}
+
+func (l *log) ID() string {
+	return l.Ident
+}
+
 func (l *log) Err() error {
 	return l.E
 }
@@ -230,6 +274,30 @@ func (l *log) GetDomain() string {
 	return l.Dom
 }
 
+func (l *log) With(key string, val interface{}) Logger {
+	n := l.clone()
+	if n.Flds == nil {
+		n.Flds = make(FieldMap, 1)
+	}
+	n.Flds[key] = val
+	return n
+}
+
+func (l *log) Fields(m map[string]interface{}) Logger {
+	n := l.clone()
+	if n.Flds == nil {
+		n.Flds = make(FieldMap, len(m))
+	}
+	for k, v := range m {
+		n.Flds[k] = v
+	}
+	return n
+}
+
+func (l *log) GetFields() map[string]interface{} {
+	return l.Flds
+}
+
 func (l *log) Store() LogBackend {
 	return l.store
 }
@@ -257,12 +325,53 @@ func (l *log) SetLevel(scope string, level Level) Logger {
 			Than:      Op(Ge, "level", level),
 		}
 	}
+	l.filterLevels()
+	return l
+}
+
+func (l *log) UnsetLevel(scope string) Logger {
+	l.lck.Lock()
+	defer l.lck.Unlock()
+	if scope == "all" {
+		l.DefLevel = True{}
+	} else {
+		delete(l.Levels, scope)
+	}
+	l.filterLevels()
+	return l
+}
+
+func (l *log) GetLevels() (def Level, levels map[string]Level) {
+	l.lck.Lock()
+	defer l.lck.Unlock()
+	levels = make(map[string]Level, len(l.Levels))
+	for scope, cond := range l.Levels {
+		levels[scope] = ruleLevel(cond.Than)
+	}
+	return ruleLevel(l.DefLevel), levels
+}
+
+// ruleLevel returns the level of the rules created by SetLevel.
+func ruleLevel(r Ruler) Level {
+	o, ok := r.(*op)
+	if !ok || o.op != Ge || !o.vright.IsValid() {
+		return ProtoPrio
+	}
+	level, ok := o.vright.Interface().(Level)
+	if !ok {
+		return ProtoPrio
+	}
+	return level
+}
+
+// filterLevels sets the filter of the store with the levels. l.lck must
+// be locked.
+func (l *log) filterLevels() {
 	ifs := make([]*If, 0, len(l.Levels))
 	for _, cond := range l.Levels {
 		ifs = append(ifs, cond)
 	}
 	l.store.Filter(Select(ifs, l.DefLevel))
-	return l
 }
 
 func (l *log) Bytes() []byte {
@@ -288,34 +397,34 @@ func (l *log) Formatter(f Formatter) {
 func (l *log) Print(v ...interface{}) {
 	n := l.clone()
 	n.Msg = fmt.Sprint(v...)
-	n.Timestamp = time.Now()
+	n.stamp()
 	n.debugInfo(2)
-	n.store.Commit(n)
+	n.commit()
 }
 
 func (l *log) Printf(f string, v ...interface{}) {
 	n := l.clone()
 	n.Msg = fmt.Sprintf(f, v...)
-	n.Timestamp = time.Now()
+	n.stamp()
 	n.debugInfo(2)
-	n.store.Commit(n)
+	n.commit()
 }
 
 func (l *log) Println(v ...interface{}) {
 	n := l.clone()
 	n.Msg = fmt.Sprintln(v...)
-	n.Timestamp = time.Now()
+	n.stamp()
 	n.debugInfo(2)
-	n.store.Commit(n)
+	n.commit()
 }
 
 func (l *log) Fatal(v ...interface{}) {
 	n := l.clone()
 	n.Priority = FatalPrio
 	n.Msg = fmt.Sprint(v...)
-	n.Timestamp = time.Now()
+	n.stamp()
 	n.debugInfo(2)
-	n.store.Commit(n)
+	n.commit()
 	n.store.Close()
 	os.Exit(1)
 }
@@ -324,9 +433,9 @@ func (l *log) Fatalf(f string, v ...interface{}) {
 	n := l.clone()
 	n.Priority = FatalPrio
 	n.Msg = fmt.Sprintf(f, v...)
-	n.Timestamp = time.Now()
+	n.stamp()
 	n.debugInfo(2)
-	n.store.Commit(n)
+	n.commit()
 	n.store.Close()
 	os.Exit(1)
 }
@@ -335,9 +444,9 @@ func (l *log) Fatalln(v ...interface{}) {
 	n := l.clone()
 	n.Priority = FatalPrio
 	n.Msg = fmt.Sprintln(v...)
-	n.Timestamp = time.Now()
+	n.stamp()
 	n.debugInfo(2)
-	n.store.Commit(n)
+	n.commit()
 	n.store.Close()
 	os.Exit(1)
 }
@@ -346,9 +455,9 @@ func (l *log) Panic(v ...interface{}) {
 	n := l.clone()
 	n.Priority = PanicPrio
 	n.Msg = fmt.Sprint(v...)
-	n.Timestamp = time.Now()
+	n.stamp()
 	n.debugInfo(2)
-	n.store.Commit(n)
+	n.commit()
 	n.store.Close()
 	panic(n.Msg)
 }
@@ -357,9 +466,9 @@ func (l *log) Panicf(f string, v ...interface{}) {
 	n := l.clone()
 	n.Priority = PanicPrio
 	n.Msg = fmt.Sprintf(f, v...)
-	n.Timestamp = time.Now()
+	n.stamp()
 	n.debugInfo(2)
-	n.store.Commit(n)
+	n.commit()
 	n.store.Close()
 	panic(n.Msg)
 }
@@ -368,9 +477,9 @@ func (l *log) Panicln(v ...interface{}) {
 	n := l.clone()
 	n.Priority = PanicPrio
 	n.Msg = fmt.Sprintln(v...)
-	n.Timestamp = time.Now()
+	n.stamp()
 	n.debugInfo(2)
-	n.store.Commit(n)
+	n.commit()
 	n.store.Close()
 	panic(n.Msg)
 }
@@ -379,33 +488,33 @@ func (l *log) Error(v ...interface{}) {
 	n := l.clone()
 	n.Priority = ErrorPrio
 	n.Msg = fmt.Sprint(v...)
-	n.Timestamp = time.Now()
+	n.stamp()
 	n.debugInfo(2)
-	n.store.Commit(n)
+	n.commit()
 }
 
 func (l *log) Errorf(f string, v ...interface{}) {
 	n := l.clone()
 	n.Priority = ErrorPrio
 	n.Msg = fmt.Sprintf(f, v...)
-	n.Timestamp = time.Now()
+	n.stamp()
 	n.debugInfo(2)
-	n.store.Commit(n)
+	n.commit()
 }
 
 func (l *log) Errorln(v ...interface{}) {
 	n := l.clone()
 	n.Priority = ErrorPrio
 	n.Msg = fmt.Sprintln(v...)
-	n.Timestamp = time.Now()
+	n.stamp()
 	n.debugInfo(2)
-	n.store.Commit(n)
+	n.commit()
 }
 
 func (l *log) GoPanic(r interface{}, stack []byte, cont bool) {
 	n := l.clone()
 	n.Priority = PanicPrio
-	n.Timestamp = time.Now()
+	n.stamp()
 	switch v := r.(type) {
 	case string:
 		n.Msg = v + "\n"
@@ -415,7 +524,7 @@ func (l *log) GoPanic(r interface{}, stack []byte, cont bool) {
 		n.Msg = fmt.Sprintln(r)
 	}
 	n.Msg += "\n" + string(stack)
-	n.store.Commit(n)
+	n.commit()
 	n.store.Close()
 	if !cont {
 		os.Exit(1)
@@ -482,6 +591,14 @@ func Domain(d string) Logger {
 	return Log.Domain(d)
 }
 
+func With(key string, val interface{}) Logger {
+	return Log.With(key, val)
+}
+
+func Fields(m map[string]interface{}) Logger {
+	return Log.Fields(m)
+}
+
 func Print(vals ...interface{}) {
 	Log.DebugInfo().Print(vals...)
 }
@@ -589,6 +706,14 @@ func SetLevel(scope string, l Level) Logger {
 	return Log.SetLevel(scope, l)
 }
 
+func UnsetLevel(scope string) Logger {
+	return Log.UnsetLevel(scope)
+}
+
+func GetLevels() (def Level, levels map[string]Level) {
+	return Log.GetLevels()
+}
+
 func EntryLevel(prio Level) Logger {
 	return Log.EntryLevel(prio)
 }
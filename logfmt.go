@@ -7,13 +7,15 @@ package log
 import (
 	"io"
 	"reflect"
+	"time"
 
 	"github.com/go-logfmt/logfmt"
 )
 
 type Logfmt struct {
 	enc *logfmt.Encoder
-	r   Ruler
+	r   ruleHolder
+	handlerHolder
 }
 
 func NewLogfmt(w io.Writer) *Logfmt {
@@ -32,18 +34,25 @@ func (l *Logfmt) GetF() Formatter {
 }
 
 func (l *Logfmt) Filter(r Ruler) LogBackend {
-	l.r = r
+	l.r.Set(r)
 	return l
 }
 
+func (l *Logfmt) GetFilter() Ruler {
+	return l.r.Get()
+}
+
 func (l *Logfmt) Commit(entry Entry) {
-	if l.r != nil && !l.r.Result(entry) {
+	if !l.r.Pass(entry) {
+		logfmtMetrics.filter()
 		return
 	}
+	var err error
+	defer logfmtMetrics.commit(time.Now(), &err)
 	if lfmt, ok := entry.(Logfmter); ok {
-		err := lfmt.Logfmt(l.enc)
+		err = lfmt.Logfmt(l.enc)
 		if err != nil {
-			Fail(err)
+			HandleError(l, entry, err)
 		}
 		return
 	}
@@ -62,15 +71,24 @@ func (l *Logfmt) Commit(entry Entry) {
 		if !vf.CanSet() {
 			continue
 		}
-		err := l.enc.EncodeKeyval(tag, vf.Interface())
+		if fields, ok := vf.Interface().(FieldMap); ok {
+			err = fields.Logfmt(l.enc)
+			if err != nil {
+				HandleError(l, entry, err)
+				break
+			}
+			continue
+		}
+		err = l.enc.EncodeKeyval(tag, vf.Interface())
 		if err != nil {
-			Fail(err)
+			HandleError(l, entry, err)
 			break
 		}
 	}
-	err := l.enc.EndRecord()
-	if err != nil {
-		Fail(err)
+	errEnd := l.enc.EndRecord()
+	if errEnd != nil {
+		HandleError(l, entry, errEnd)
+		err = errEnd
 	}
 }
 
@@ -0,0 +1,236 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"expvar"
+	"sync/atomic"
+	"time"
+)
+
+// ExpvarName is the name of the metrics published with expvar.
+const ExpvarName = "github.com/fcavani/log"
+
+// latencyBounds are the upper bounds of the buckets of the latency
+// histograms. The last bucket is +Inf.
+var latencyBounds = []time.Duration{
+	time.Microsecond,
+	10 * time.Microsecond,
+	100 * time.Microsecond,
+	time.Millisecond,
+	10 * time.Millisecond,
+	100 * time.Millisecond,
+	time.Second,
+}
+
+type histogram struct {
+	count   uint64
+	sum     int64
+	buckets [8]uint64
+}
+
+func (h *histogram) observe(d time.Duration) {
+	i := 0
+	for i < len(latencyBounds) && d > latencyBounds[i] {
+		i++
+	}
+	atomic.AddUint64(&h.buckets[i], 1)
+	atomic.AddUint64(&h.count, 1)
+	atomic.AddInt64(&h.sum, int64(d))
+}
+
+// HistogramStats is a snapshot of a latency histogram. The keys of
+// Buckets are the upper bounds of the buckets, like 10µs, and +Inf.
+type HistogramStats struct {
+	Count   uint64            `json:"count"`
+	Sum     time.Duration     `json:"sum"`
+	Buckets map[string]uint64 `json:"buckets"`
+}
+
+func (h *histogram) stats() HistogramStats {
+	s := HistogramStats{
+		Count:   atomic.LoadUint64(&h.count),
+		Sum:     time.Duration(atomic.LoadInt64(&h.sum)),
+		Buckets: make(map[string]uint64, len(h.buckets)),
+	}
+	for i := range h.buckets {
+		name := "+Inf"
+		if i < len(latencyBounds) {
+			name = latencyBounds[i].String()
+		}
+		s.Buckets[name] = atomic.LoadUint64(&h.buckets[i])
+	}
+	return s
+}
+
+type backendMetrics struct {
+	committed uint64
+	filtered  uint64
+	dropped   uint64
+	failed    uint64
+	latency   histogram
+}
+
+func (m *backendMetrics) filter() {
+	atomic.AddUint64(&m.filtered, 1)
+}
+
+func (m *backendMetrics) drop() {
+	atomic.AddUint64(&m.dropped, 1)
+}
+
+func (m *backendMetrics) dropN(n uint64) {
+	atomic.AddUint64(&m.dropped, n)
+}
+
+// commit counts one commit that started in start, if *err is nil when the
+// commit returns. Use it with defer.
+func (m *backendMetrics) commit(start time.Time, err *error) {
+	m.observe(start, *err == nil)
+}
+
+// observe records the latency of a commit and counts it if ok.
+func (m *backendMetrics) observe(start time.Time, ok bool) {
+	if ok {
+		atomic.AddUint64(&m.committed, 1)
+	}
+	m.latency.observe(time.Since(start))
+}
+
+// BackendStats are the counters of one type of backend.
+type BackendStats struct {
+	// Committed is the number of entries committed without errors.
+	Committed uint64 `json:"committed"`
+	// Filtered is the number of entries rejected by the filter.
+	Filtered uint64 `json:"filtered"`
+	// Dropped is the number of entries dropped, like by the overflow
+	// policy of the OutBuffer.
+	Dropped uint64 `json:"dropped"`
+	// Failed is the number of errors sent to the error handler.
+	Failed uint64 `json:"failed"`
+	// Latency is the histogram of the time spent in the commit.
+	Latency HistogramStats `json:"latency"`
+}
+
+func (m *backendMetrics) stats() BackendStats {
+	return BackendStats{
+		Committed: atomic.LoadUint64(&m.committed),
+		Filtered:  atomic.LoadUint64(&m.filtered),
+		Dropped:   atomic.LoadUint64(&m.dropped),
+		Failed:    atomic.LoadUint64(&m.failed),
+		Latency:   m.latency.stats(),
+	}
+}
+
+var (
+	writerMetrics    = new(backendMetrics)
+	genericMetrics   = new(backendMetrics)
+	multiMetrics     = new(backendMetrics)
+	outBufferMetrics = new(backendMetrics)
+	syslogMetrics    = new(backendMetrics)
+	logfmtMetrics    = new(backendMetrics)
+	networkMetrics   = new(backendMetrics)
+	broadcastMetrics = new(backendMetrics)
+	journaldMetrics  = new(backendMetrics)
+	gelfMetrics      = new(backendMetrics)
+	samplerMetrics   = new(backendMetrics)
+)
+
+var backendsMetrics = map[string]*backendMetrics{
+	"writer":    writerMetrics,
+	"generic":   genericMetrics,
+	"multi":     multiMetrics,
+	"outbuffer": outBufferMetrics,
+	"syslog":    syslogMetrics,
+	"logfmt":    logfmtMetrics,
+	"network":   networkMetrics,
+	"broadcast": broadcastMetrics,
+	"journald":  journaldMetrics,
+	"gelf":      gelfMetrics,
+	"sampler":   samplerMetrics,
+}
+
+func metricsOf(bak LogBackend) *backendMetrics {
+	switch bak.(type) {
+	case *Writer:
+		return writerMetrics
+	case *Generic:
+		return genericMetrics
+	case *MultiLog:
+		return multiMetrics
+	case *OutBuffer:
+		return outBufferMetrics
+	case *Syslog, *RemoteSyslog:
+		return syslogMetrics
+	case *Logfmt:
+		return logfmtMetrics
+	case *Network:
+		return networkMetrics
+	case *Broadcast:
+		return broadcastMetrics
+	case *Journald:
+		return journaldMetrics
+	case *GELF:
+		return gelfMetrics
+	case *Sampler:
+		return samplerMetrics
+	}
+	return nil
+}
+
+// levelsMetrics counts the entries logged by level.
+var levelsMetrics [NoPrio + 1]uint64
+
+// errorsMetrics counts the errors sent to the error handlers.
+var errorsMetrics uint64
+
+func countLevel(l Level) {
+	if l < ProtoPrio || l > NoPrio {
+		return
+	}
+	atomic.AddUint64(&levelsMetrics[l], 1)
+}
+
+func countError(bak LogBackend) {
+	atomic.AddUint64(&errorsMetrics, 1)
+	if m := metricsOf(bak); m != nil {
+		atomic.AddUint64(&m.failed, 1)
+	}
+}
+
+// Metrics is a snapshot of the metrics of the logger.
+type Metrics struct {
+	// Levels is the number of entries logged by level.
+	Levels map[string]uint64 `json:"levels"`
+	// Errors is the number of errors sent to the error handlers.
+	Errors uint64 `json:"errors"`
+	// Backends are the counters of the built-in backends by type: writer,
+	// generic, multi, outbuffer, syslog, logfmt, network, broadcast,
+	// journald and gelf.
+	Backends map[string]BackendStats `json:"backends"`
+}
+
+// Stats returns a snapshot of the metrics. The same metrics are published
+// with expvar with the name ExpvarName.
+func Stats() Metrics {
+	m := Metrics{
+		Levels:   make(map[string]uint64, len(levelsMetrics)),
+		Errors:   atomic.LoadUint64(&errorsMetrics),
+		Backends: make(map[string]BackendStats, len(backendsMetrics)),
+	}
+	for l := range levelsMetrics {
+		m.Levels[Level(l).String()] = atomic.LoadUint64(&levelsMetrics[l])
+	}
+	for name, bm := range backendsMetrics {
+		m.Backends[name] = bm.stats()
+	}
+	return m
+}
+
+func init() {
+	expvar.Publish(ExpvarName, expvar.Func(func() interface{} {
+		return Stats()
+	}))
+}
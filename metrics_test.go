@@ -0,0 +1,65 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"expvar"
+	"testing"
+
+	"github.com/fcavani/e"
+)
+
+func TestStats(t *testing.T) {
+	buf := bytes.NewBuffer([]byte{})
+	defer stderrHandler(buf)()
+	before := Stats()
+
+	logger := New(NewWriter(buf).F(DefFormatter), false).SetLevel("all", InfoPrio)
+	for i := 0; i < 3; i++ {
+		logger.Error("error")
+	}
+	logger.DebugLevel().Println("filtered")
+	New(NewWriter(failWriter{}).F(DefFormatter), false).Print("failed")
+	o, err := NewOutBufferOpts(NewWriter(buf).F(DefFormatter), OutBufferOptions{Size: 1})
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	o.Close()
+	New(o, false).Print("dropped")
+
+	after := Stats()
+	if n := after.Levels["error"] - before.Levels["error"]; n != 3 {
+		t.Fatal("wrong number of errors", n)
+	}
+	if n := after.Levels["debug"] - before.Levels["debug"]; n != 1 {
+		t.Fatal("wrong number of debugs", n)
+	}
+	if n := after.Errors - before.Errors; n != 1 {
+		t.Fatal("wrong number of errors", n)
+	}
+	w0, w1 := before.Backends["writer"], after.Backends["writer"]
+	// The failed commit isn't counted as committed.
+	if w1.Committed-w0.Committed != 3 || w1.Filtered-w0.Filtered != 1 || w1.Failed-w0.Failed != 1 {
+		t.Fatalf("wrong writer stats %+v %+v", w0, w1)
+	}
+	if w1.Latency.Count-w0.Latency.Count != 4 || len(w1.Latency.Buckets) != 8 || w1.Latency.Sum <= w0.Latency.Sum {
+		t.Fatalf("wrong latency %+v %+v", w0.Latency, w1.Latency)
+	}
+	o0, o1 := before.Backends["outbuffer"], after.Backends["outbuffer"]
+	if o1.Dropped-o0.Dropped != 1 || o1.Committed != o0.Committed {
+		t.Fatalf("wrong outbuffer stats %+v %+v", o0, o1)
+	}
+
+	var m Metrics
+	err = json.Unmarshal([]byte(expvar.Get(ExpvarName).String()), &m)
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	if m.Levels["error"] < after.Levels["error"] || len(m.Backends) != 11 {
+		t.Fatalf("wrong expvar %+v", m)
+	}
+}
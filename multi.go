@@ -0,0 +1,189 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"time"
+
+	"github.com/fcavani/e"
+)
+
+const ErrQueueFull = "backend queue is full"
+
+// DefQueueSize is the size of the queue of the backends added to the
+// MultiBuilder.
+const DefQueueSize = 1000
+
+// multiChild is one backend of the MultiLog. If queue isn't nil the
+// entries are committed by one goroutine.
+type multiChild struct {
+	bak     LogBackend
+	r       ruleHolder
+	size    int
+	timeout time.Duration
+	queue   chan Entry
+	done    chan struct{}
+}
+
+func (c *multiChild) start() {
+	if c.size == 0 {
+		return
+	}
+	c.queue = make(chan Entry, c.size)
+	c.done = make(chan struct{})
+	go func() {
+		defer close(c.done)
+		for entry := range c.queue {
+			c.bak.Commit(entry)
+		}
+	}()
+}
+
+// copyEntry returns a copy of the entry if it is possible. The backends
+// set the formatter of the entry, so the backends running in parallel
+// can't share the same entry. Only the entries of the logger are copied.
+func copyEntry(entry Entry) Entry {
+	if l, ok := entry.(*log); ok {
+		return l.clone()
+	}
+	return entry
+}
+
+// commit commits or enqueues the entry, it returns false if the entry was
+// dropped.
+func (c *multiChild) commit(entry Entry) bool {
+	if !c.r.Pass(entry) {
+		return true
+	}
+	if c.queue == nil {
+		c.bak.Commit(entry)
+		return true
+	}
+	entry = copyEntry(entry)
+	select {
+	case c.queue <- entry:
+		return true
+	default:
+	}
+	if c.timeout == 0 {
+		c.queue <- entry
+		return true
+	}
+	timer := time.NewTimer(c.timeout)
+	defer timer.Stop()
+	select {
+	case c.queue <- entry:
+		return true
+	case <-timer.C:
+		multiMetrics.drop()
+		HandleError(c.bak, entry, e.New(ErrQueueFull))
+		return false
+	}
+}
+
+func (c *multiChild) close() error {
+	if c.queue != nil {
+		close(c.queue)
+		<-c.done
+	}
+	err := c.bak.Close()
+	if err != nil {
+		return e.Forward(err)
+	}
+	return nil
+}
+
+// MultiBuilder builds a MultiLog. Filter, Queue and Timeout configure
+// the last backend added. Each backend has by default a queue of
+// DefQueueSize entries committed by its own goroutine, so a slow backend
+// doesn't delay the others.
+//
+//	multi, err := NewMultiBuilder().
+//		Add(NewWriter(os.Stdout), DefFormatter).Queue(0).
+//		Add(NewGeneric(mongodb), DefFormatter).Timeout(time.Second).
+//		Build()
+type MultiBuilder struct {
+	children []*multiChild
+	err      error
+}
+
+// NewMultiBuilder creates an empty builder.
+func NewMultiBuilder() *MultiBuilder {
+	return &MultiBuilder{
+		children: make([]*multiChild, 0),
+	}
+}
+
+func (b *MultiBuilder) last() *multiChild {
+	if len(b.children) == 0 {
+		if b.err == nil {
+			b.err = e.New("no backend to configure")
+		}
+		return nil
+	}
+	return b.children[len(b.children)-1]
+}
+
+// Add adds the backend bak with the formatter f.
+func (b *MultiBuilder) Add(bak LogBackend, f Formatter) *MultiBuilder {
+	if bak == nil || f == nil {
+		if b.err == nil {
+			b.err = e.New("invalid backend or formatter")
+		}
+		return b
+	}
+	bak.F(f)
+	b.children = append(b.children, &multiChild{bak: bak, size: DefQueueSize})
+	return b
+}
+
+// Filter sets a filter only for the last backend.
+func (b *MultiBuilder) Filter(r Ruler) *MultiBuilder {
+	if c := b.last(); c != nil {
+		c.r.Set(precompile(r))
+	}
+	return b
+}
+
+// Queue sets the size of the queue of the last backend. Zero disables the
+// queue and the entries are committed in the caller goroutine.
+func (b *MultiBuilder) Queue(size int) *MultiBuilder {
+	if c := b.last(); c != nil {
+		if size < 0 && b.err == nil {
+			b.err = e.New("invalid queue size")
+		}
+		c.size = size
+	}
+	return b
+}
+
+// Timeout is how long the commit waits when the queue of the last backend
+// is full. After it the entry is dropped. Zero waits forever.
+func (b *MultiBuilder) Timeout(d time.Duration) *MultiBuilder {
+	if c := b.last(); c != nil {
+		if d < 0 && b.err == nil {
+			b.err = e.New("invalid timeout")
+		}
+		c.timeout = d
+	}
+	return b
+}
+
+// Build creates the MultiLog and starts the queues.
+func (b *MultiBuilder) Build() (*MultiLog, error) {
+	if b.err != nil {
+		return nil, e.Forward(b.err)
+	}
+	mp := &MultiLog{
+		mp:       make([]LogBackend, 0, len(b.children)),
+		children: b.children,
+	}
+	for _, c := range b.children {
+		c.start()
+		mp.mp = append(mp.mp, c.bak)
+	}
+	b.children = make([]*multiChild, 0)
+	return mp, nil
+}
@@ -0,0 +1,128 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fcavani/e"
+)
+
+// closeBackend returns err in the Close.
+type closeBackend struct {
+	LogBackend
+	err    error
+	closed bool
+}
+
+func (c *closeBackend) Close() error {
+	c.closed = true
+	return c.err
+}
+
+func TestMultiBuilder(t *testing.T) {
+	buf := bytes.NewBuffer([]byte{})
+	form, err := NewStdFormatter("::", "::domain - ::msg", Log, map[string]interface{}{}, "")
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	slow := newGateBackend()
+	mp, err := NewMultiBuilder().
+		Add(NewWriter(buf), form).Filter(MustParseRule(`domain == "db"`)).Queue(0).
+		Add(slow, DefFormatter).
+		Build()
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	New(mp, false).Domain("db").Print("one")
+	New(mp, false).Domain("http").Print("two")
+	// The slow backend doesn't block the writer.
+	if buf.String() != "db - one\n" {
+		t.Fatalf("wrong log %q", buf.String())
+	}
+	if len(mp.Backends()) != 2 {
+		t.Fatal("wrong backends")
+	}
+	close(slow.gate)
+	err = mp.Close()
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	if len(slow.messages("db")) != 1 || len(slow.messages("http")) != 1 {
+		t.Fatal("entries lost")
+	}
+	err = mp.Close()
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+
+	_, err = NewMultiBuilder().Queue(10).Add(NewWriter(buf), form).Build()
+	if err == nil {
+		t.Fatal("nil error")
+	}
+	_, err = NewMultiBuilder().Add(nil, form).Build()
+	if err == nil {
+		t.Fatal("nil error")
+	}
+}
+
+func TestMultiTimeout(t *testing.T) {
+	slow := newGateBackend()
+	mp, err := NewMultiBuilder().
+		Add(slow, DefFormatter).Queue(1).Timeout(10 * time.Millisecond).
+		Build()
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	before := Stats().Backends["multi"]
+	logger := New(mp, false)
+	logger.Print("1")
+	<-slow.started
+	logger.Print("2")
+	logger.Print("3")
+	close(slow.gate)
+	err = mp.Close()
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	if msgs := slow.messages(""); len(msgs) != 2 || msgs[1] != "2" {
+		t.Fatal("wrong messages", msgs)
+	}
+	// The entry dropped isn't counted as committed.
+	after := Stats().Backends["multi"]
+	if after.Committed-before.Committed != 2 || after.Dropped-before.Dropped != 1 {
+		t.Fatalf("wrong stats %+v %+v", before, after)
+	}
+}
+
+func TestMultiClose(t *testing.T) {
+	b1 := &closeBackend{LogBackend: NewWriter(bytes.NewBuffer([]byte{})), err: e.New("first failed")}
+	b2 := &closeBackend{LogBackend: NewWriter(bytes.NewBuffer([]byte{}))}
+	b3 := &closeBackend{LogBackend: NewWriter(bytes.NewBuffer([]byte{})), err: e.New("third failed")}
+	mp := NewMulti(b1, DefFormatter, b2, DefFormatter, b3, DefFormatter)
+	err := mp.Close()
+	if err == nil {
+		t.Fatal("nil error")
+	}
+	if !strings.Contains(err.Error(), "first failed") || !strings.Contains(err.Error(), "third failed") {
+		t.Fatal("wrong error", err)
+	}
+	if !b1.closed || !b2.closed || !b3.closed {
+		t.Fatal("backend not closed")
+	}
+}
+
+func TestCopyEntry(t *testing.T) {
+	entry := Log.Domain("test").(*log).clone()
+	entry.Msg = "one"
+	entry.Formatter(DefFormatter)
+	cp := copyEntry(entry)
+	if cp == Entry(entry) || cp.String() != entry.String() {
+		t.Fatalf("wrong copy %q", cp.String())
+	}
+}
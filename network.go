@@ -0,0 +1,446 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/fcavani/e"
+)
+
+const ErrNetworkClosed = "network backend is closed"
+
+// Framing is how the encoded entries are delimited in the stream.
+type Framing uint8
+
+const (
+	// FramingAuto uses FramingLines for JSON and FramingLength for the
+	// others encoders.
+	FramingAuto Framing = iota
+	// FramingLines ends each entry with a new line.
+	FramingLines
+	// FramingLength prefixes each entry with its length in four bytes, big
+	// endian.
+	FramingLength
+)
+
+// NetworkOptions configures the Network backend.
+type NetworkOptions struct {
+	// Encoder encodes the entries. The default is JSON.
+	Encoder Encoder
+	// Framing delimits the entries.
+	Framing Framing
+	// TLS enables TLS for stream networks. Set the Certificates for mutual
+	// TLS.
+	TLS *tls.Config
+	// BatchSize is the max number of entries in one write. Default 100.
+	BatchSize int
+	// FlushInterval is the max time one entry waits for the batch. Zero
+	// sends the entries as soon as possible.
+	FlushInterval time.Duration
+	// BufferSize is the max number of entries waiting to be sent, when
+	// disconnected. The oldest entries are dropped. Default 10000.
+	BufferSize int
+	// DialTimeout is the timeout of the connection. Default 5s.
+	DialTimeout time.Duration
+	// WriteTimeout is the timeout of each write. Default 5s.
+	WriteTimeout time.Duration
+	// MinBackoff and MaxBackoff are the limits of the exponential backoff
+	// between the reconnections. Default 100ms and 30s.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// Sender identifies the process to the collector. If it isn't empty
+	// the first frame of each connection is a NetworkHello, encoded with
+	// JSON, and the collector can detect the lost entries.
+	Sender string
+}
+
+// NetworkHello is the first frame of the connections of a Network backend
+// with Sender set.
+type NetworkHello struct {
+	// Sender is the Sender of the options.
+	Sender string `json:"sender"`
+	// Node is the random identifier of the process, the same of the ids of
+	// the entries.
+	Node string `json:"node"`
+	// Seq is the sequence number of the first entry sent in the
+	// connection. The entries committed to the backend have consecutive
+	// numbers.
+	Seq uint64 `json:"seq"`
+}
+
+// netFrame is one encoded entry.
+type netFrame struct {
+	seq uint64
+	buf []byte
+}
+
+// NetworkStats are the counters of the Network backend.
+type NetworkStats struct {
+	// Sent is the number of entries written to the connection.
+	Sent uint64
+	// Dropped is the number of entries dropped because the buffer was
+	// full.
+	Dropped uint64
+	// Queued is the number of entries waiting to be sent.
+	Queued int
+	// Reconnects is the number of connections made.
+	Reconnects uint64
+}
+
+// Network ships the entries to a collector. The entries are sent at
+// least once, after a write error the whole batch is sent again.
+type Network struct {
+	network string
+	addr    string
+	opts    NetworkOptions
+	packet  bool
+	f       Formatter
+	r       ruleHolder
+	handlerHolder
+
+	lck     sync.Mutex
+	frames  []netFrame
+	seq     uint64
+	closed  bool
+	stats   NetworkStats
+	notify  chan struct{}
+	closing chan struct{}
+	done    chan struct{}
+	once    sync.Once
+
+	conn net.Conn
+}
+
+func isPacket(network string) bool {
+	switch network {
+	case "udp", "udp4", "udp6", "unixgram":
+		return true
+	}
+	return false
+}
+
+// NewNetwork creates a backend that sends the entries to addr. network is
+// one of the networks of net.Dial, like tcp, udp or unix. With packet
+// networks each entry is sent in one datagram.
+func NewNetwork(network, addr string, opts NetworkOptions) (*Network, error) {
+	if addr == "" {
+		return nil, e.New("invalid address")
+	}
+	packet := isPacket(network)
+	if packet && opts.TLS != nil {
+		return nil, e.New("tls isn't supported with %v", network)
+	}
+	if opts.BatchSize < 0 || opts.BufferSize < 0 || opts.FlushInterval < 0 ||
+		opts.DialTimeout < 0 || opts.WriteTimeout < 0 || opts.MinBackoff < 0 ||
+		opts.MaxBackoff < 0 || opts.Framing > FramingLength {
+		return nil, e.New("invalid options")
+	}
+	if opts.Encoder == nil {
+		opts.Encoder = &JSON{}
+	}
+	if opts.Framing == FramingAuto {
+		opts.Framing = FramingLength
+		if _, ok := opts.Encoder.(*JSON); ok {
+			opts.Framing = FramingLines
+		}
+	}
+	if opts.BatchSize == 0 {
+		opts.BatchSize = 100
+	}
+	if opts.BufferSize == 0 {
+		opts.BufferSize = 10000
+	}
+	if opts.DialTimeout == 0 {
+		opts.DialTimeout = 5 * time.Second
+	}
+	if opts.WriteTimeout == 0 {
+		opts.WriteTimeout = 5 * time.Second
+	}
+	if opts.MinBackoff == 0 {
+		opts.MinBackoff = 100 * time.Millisecond
+	}
+	if opts.MaxBackoff == 0 {
+		opts.MaxBackoff = 30 * time.Second
+	}
+	if opts.MaxBackoff < opts.MinBackoff {
+		opts.MaxBackoff = opts.MinBackoff
+	}
+	n := &Network{
+		network: network,
+		addr:    addr,
+		opts:    opts,
+		packet:  packet,
+		frames:  make([]netFrame, 0),
+		notify:  make(chan struct{}, 1),
+		closing: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go n.run()
+	return n, nil
+}
+
+func (n *Network) F(f Formatter) LogBackend {
+	n.lck.Lock()
+	defer n.lck.Unlock()
+	n.f = f
+	return n
+}
+
+func (n *Network) GetF() Formatter {
+	n.lck.Lock()
+	defer n.lck.Unlock()
+	return n.f
+}
+
+func (n *Network) Filter(r Ruler) LogBackend {
+	n.r.Set(precompile(r))
+	return n
+}
+
+func (n *Network) GetFilter() Ruler {
+	return n.r.Get()
+}
+
+func (n *Network) frame(buf []byte) []byte {
+	switch n.opts.Framing {
+	case FramingLines:
+		frame := make([]byte, len(buf), len(buf)+1)
+		copy(frame, buf)
+		if len(frame) == 0 || frame[len(frame)-1] != '\n' {
+			frame = append(frame, '\n')
+		}
+		return frame
+	default:
+		frame := make([]byte, 4+len(buf))
+		binary.BigEndian.PutUint32(frame, uint32(len(buf)))
+		copy(frame[4:], buf)
+		return frame
+	}
+}
+
+// push adds frames to the buffer, in the front if front is true, and
+// drops the oldest frames if the buffer is full. n.lck must be locked.
+func (n *Network) push(front bool, frames ...netFrame) {
+	if front {
+		n.frames = append(frames, n.frames...)
+	} else {
+		n.frames = append(n.frames, frames...)
+	}
+	if over := len(n.frames) - n.opts.BufferSize; over > 0 {
+		n.frames = n.frames[over:]
+		n.stats.Dropped += uint64(over)
+		networkMetrics.dropN(uint64(over))
+	}
+}
+
+func (n *Network) Commit(entry Entry) {
+	if !n.r.Pass(entry) {
+		networkMetrics.filter()
+		return
+	}
+	var err error
+	defer networkMetrics.commit(time.Now(), &err)
+	buf, err := n.opts.Encoder.Encode(entry)
+	if err != nil {
+		HandleError(n, entry, e.Forward(err))
+		return
+	}
+	frame := n.frame(buf)
+	n.lck.Lock()
+	if n.closed {
+		n.lck.Unlock()
+		err = e.New(ErrNetworkClosed)
+		HandleError(n, entry, err)
+		return
+	}
+	n.seq++
+	n.push(false, netFrame{seq: n.seq, buf: frame})
+	n.lck.Unlock()
+	select {
+	case n.notify <- struct{}{}:
+	default:
+	}
+}
+
+// next waits for a batch.
+func (n *Network) next() (frames []netFrame, closing bool) {
+	var timer *time.Timer
+	var timeout <-chan time.Time
+	expired := n.opts.FlushInterval == 0
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+	for {
+		n.lck.Lock()
+		closing = n.closed
+		l := len(n.frames)
+		if l >= n.opts.BatchSize || (l > 0 && (closing || expired)) {
+			if l > n.opts.BatchSize {
+				l = n.opts.BatchSize
+			}
+			frames = n.frames[:l:l]
+			n.frames = n.frames[l:]
+			n.lck.Unlock()
+			return frames, closing
+		}
+		n.lck.Unlock()
+		if l == 0 && closing {
+			return nil, true
+		}
+		if l > 0 && timer == nil {
+			timer = time.NewTimer(n.opts.FlushInterval)
+			timeout = timer.C
+		}
+		select {
+		case <-n.notify:
+		case <-timeout:
+			expired = true
+		case <-n.closing:
+		}
+	}
+}
+
+func (n *Network) dial() (net.Conn, error) {
+	dialer := &net.Dialer{
+		Timeout: n.opts.DialTimeout,
+	}
+	if n.opts.TLS != nil {
+		return tls.DialWithDialer(dialer, n.network, n.addr, n.opts.TLS)
+	}
+	return dialer.Dial(n.network, n.addr)
+}
+
+func (n *Network) hello(seq uint64) ([]byte, error) {
+	buf, err := json.Marshal(&NetworkHello{
+		Sender: n.opts.Sender,
+		Node:   idNode,
+		Seq:    seq,
+	})
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	return n.frame(buf), nil
+}
+
+func (n *Network) send(frames []netFrame) error {
+	bufs := make([][]byte, 0, len(frames)+1)
+	if n.conn == nil {
+		conn, err := n.dial()
+		if err != nil {
+			return e.Forward(err)
+		}
+		n.conn = conn
+		n.lck.Lock()
+		n.stats.Reconnects++
+		n.lck.Unlock()
+		if n.opts.Sender != "" {
+			hello, err := n.hello(frames[0].seq)
+			if err != nil {
+				return e.Forward(err)
+			}
+			bufs = append(bufs, hello)
+		}
+	}
+	for _, frame := range frames {
+		bufs = append(bufs, frame.buf)
+	}
+	err := n.conn.SetWriteDeadline(time.Now().Add(n.opts.WriteTimeout))
+	if err == nil {
+		if n.packet {
+			for _, buf := range bufs {
+				_, err = n.conn.Write(buf)
+				if err != nil {
+					break
+				}
+			}
+		} else {
+			_, err = n.conn.Write(bytes.Join(bufs, nil))
+		}
+	}
+	if err != nil {
+		n.conn.Close()
+		n.conn = nil
+		return e.Forward(err)
+	}
+	n.lck.Lock()
+	n.stats.Sent += uint64(len(frames))
+	n.lck.Unlock()
+	return nil
+}
+
+func (n *Network) run() {
+	defer close(n.done)
+	backoff := n.opts.MinBackoff
+	for {
+		frames, closing := n.next()
+		if len(frames) == 0 {
+			break
+		}
+		err := n.send(frames)
+		if err == nil {
+			backoff = n.opts.MinBackoff
+			continue
+		}
+		n.lck.Lock()
+		n.push(true, frames...)
+		n.lck.Unlock()
+		if backoff == n.opts.MinBackoff {
+			// Only the first error of a sequence is reported.
+			HandleError(n, nil, err)
+		}
+		if closing {
+			break
+		}
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-n.closing:
+		}
+		timer.Stop()
+		backoff *= 2
+		if backoff > n.opts.MaxBackoff {
+			backoff = n.opts.MaxBackoff
+		}
+	}
+	if n.conn != nil {
+		n.conn.Close()
+		n.conn = nil
+	}
+}
+
+// Stats returns the counters of the backend.
+func (n *Network) Stats() NetworkStats {
+	n.lck.Lock()
+	defer n.lck.Unlock()
+	s := n.stats
+	s.Queued = len(n.frames)
+	return s
+}
+
+// Close sends the entries in the buffer and closes the connection. If
+// the collector can't be reached the entries are lost and an error is
+// returned.
+func (n *Network) Close() error {
+	n.once.Do(func() {
+		n.lck.Lock()
+		n.closed = true
+		n.lck.Unlock()
+		close(n.closing)
+	})
+	<-n.done
+	if s := n.Stats(); s.Queued > 0 {
+		return e.New("%v entries not sent", s.Queued)
+	}
+	return nil
+}
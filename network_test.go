@@ -0,0 +1,293 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	crand "crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fcavani/e"
+	"github.com/fcavani/types"
+)
+
+// testCollector accepts the connections of l and sends the messages of the
+// entries received to the channel.
+func testCollector(t *testing.T, l net.Listener, framing Framing) chan string {
+	ch := make(chan string, 100)
+	dec := &Gob{TypeName: types.Name(&log{})}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for {
+					var msg string
+					if framing == FramingLines {
+						line, err := r.ReadBytes('\n')
+						if err != nil {
+							return
+						}
+						var entry map[string]interface{}
+						err = json.Unmarshal(line, &entry)
+						if err != nil {
+							t.Error(e.Trace(e.Forward(err)))
+							return
+						}
+						msg, _ = entry["msg"].(string)
+					} else {
+						size := make([]byte, 4)
+						_, err := io.ReadFull(r, size)
+						if err != nil {
+							return
+						}
+						buf := make([]byte, binary.BigEndian.Uint32(size))
+						_, err = io.ReadFull(r, buf)
+						if err != nil {
+							return
+						}
+						entry, err := dec.Decode(buf)
+						if err != nil {
+							t.Error(e.Trace(e.Forward(err)))
+							return
+						}
+						msg = entry.(Entry).Message()
+					}
+					ch <- msg
+				}
+			}(conn)
+		}
+	}()
+	return ch
+}
+
+func receive(t *testing.T, ch chan string, msgs ...string) {
+	for _, msg := range msgs {
+		select {
+		case got := <-ch:
+			if got != msg {
+				t.Fatalf("wrong message %q, want %q", got, msg)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for", msg)
+		}
+	}
+}
+
+func TestNetworkTCP(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	defer l.Close()
+	ch := testCollector(t, l, FramingLines)
+
+	n, err := NewNetwork("tcp", l.Addr().String(), NetworkOptions{})
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	logger := New(n, false).Domain("test")
+	logger.Print("one")
+	logger.With("n", 2).Print("two")
+	logger.Print("three")
+	receive(t, ch, "one", "two", "three")
+	err = n.Close()
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	if s := n.Stats(); s.Sent != 3 || s.Reconnects != 1 || s.Queued != 0 {
+		t.Fatalf("wrong stats %+v", s)
+	}
+}
+
+func TestNetworkReconnect(t *testing.T) {
+	defer SetErrorHandler(nil)
+	count := NewCountingHandler(nil)
+	SetErrorHandler(count)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	n, err := NewNetwork("tcp", addr, NetworkOptions{
+		BufferSize: 2,
+		MinBackoff: 10 * time.Millisecond,
+		MaxBackoff: 20 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	defer n.Close()
+	logger := New(n, false)
+	for _, msg := range []string{"0", "1", "2", "3", "4"} {
+		logger.Print(msg)
+	}
+	// Wait for the first batch to fail and go back to the buffer.
+	for i := 0; count.Errors() == 0; i++ {
+		if i > 100 {
+			t.Fatal("error not reported")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if s := n.Stats(); s.Dropped != 3 || s.Queued > 2 {
+		t.Fatalf("wrong stats %+v", s)
+	}
+
+	l, err = net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	defer l.Close()
+	ch := testCollector(t, l, FramingLines)
+	receive(t, ch, "3", "4")
+}
+
+func TestNetworkUnixGob(t *testing.T) {
+	dir, err := ioutil.TempDir("", "network")
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "log.sock")
+	l, err := net.Listen("unix", name)
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	defer l.Close()
+	ch := testCollector(t, l, FramingLength)
+
+	n, err := NewNetwork("unix", name, NetworkOptions{
+		Encoder:       &Gob{TypeName: types.Name(&log{})},
+		BatchSize:     10,
+		FlushInterval: 20 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	logger := New(n, false)
+	logger.Print("one")
+	logger.Print("two")
+	receive(t, ch, "one", "two")
+	err = n.Close()
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+}
+
+func TestNetworkUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	defer conn.Close()
+	n, err := NewNetwork("udp", conn.LocalAddr().String(), NetworkOptions{})
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	defer n.Close()
+	New(n, false).Print("one")
+	New(n, false).Print("two")
+	buf := make([]byte, 65536)
+	for _, msg := range []string{"one", "two"} {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		l, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(e.Trace(e.Forward(err)))
+		}
+		var entry map[string]interface{}
+		err = json.Unmarshal(buf[:l], &entry)
+		if err != nil {
+			t.Fatal(e.Trace(e.Forward(err)))
+		}
+		if entry["msg"] != msg {
+			t.Fatal("wrong message", entry)
+		}
+	}
+
+	_, err = NewNetwork("udp", "127.0.0.1:1", NetworkOptions{TLS: &tls.Config{}})
+	if err == nil {
+		t.Fatal("nil error")
+	}
+}
+
+func testCert(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), crand.Reader)
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(crand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
+
+func TestNetworkTLS(t *testing.T) {
+	cert, pool := testCert(t)
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+	})
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	defer l.Close()
+	ch := testCollector(t, l, FramingLines)
+
+	n, err := NewNetwork("tcp", l.Addr().String(), NetworkOptions{
+		TLS: &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			RootCAs:      pool,
+		},
+	})
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	New(n, false).Print("secret")
+	receive(t, ch, "secret")
+	err = n.Close()
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+}
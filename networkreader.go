@@ -0,0 +1,142 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+
+	"github.com/fcavani/e"
+	"github.com/fcavani/types"
+)
+
+const ErrFrameTooBig = "frame too big"
+const ErrInvalidEntry = "invalid entry"
+
+// MaxFrameSize is the max size of the frames read by the NetworkReader.
+var MaxFrameSize = 1 << 20
+
+// NetworkReader reads the entries sent by the Network backend, it is the
+// collector side of the protocol.
+type NetworkReader struct {
+	r       *bufio.Reader
+	dec     Decoder
+	framing Framing
+	first   bool
+	hello   *NetworkHello
+	seq     uint64
+}
+
+// NewNetworkReader creates a reader of the entries in r. dec and framing
+// must match the Encoder and the Framing of the backend. If dec is nil the
+// entries are decoded from JSON.
+func NewNetworkReader(r io.Reader, dec Decoder, framing Framing) *NetworkReader {
+	if dec == nil {
+		dec = &JSON{TypeName: types.Name(&log{})}
+	}
+	if framing == FramingAuto {
+		framing = FramingLength
+		if _, ok := dec.(*JSON); ok {
+			framing = FramingLines
+		}
+	}
+	return &NetworkReader{
+		r:       bufio.NewReader(r),
+		dec:     dec,
+		framing: framing,
+		first:   true,
+	}
+}
+
+func (n *NetworkReader) frame() ([]byte, error) {
+	switch n.framing {
+	case FramingLines:
+		var line []byte
+		for {
+			buf, err := n.r.ReadSlice('\n')
+			line = append(line, buf...)
+			if len(line) > MaxFrameSize {
+				return nil, e.New(ErrFrameTooBig)
+			}
+			if err == bufio.ErrBufferFull {
+				continue
+			}
+			if err == io.EOF && len(line) > 0 {
+				return nil, e.Forward(io.ErrUnexpectedEOF)
+			}
+			if err != nil {
+				return nil, err
+			}
+			return bytes.TrimRight(line, "\r\n"), nil
+		}
+	default:
+		size := make([]byte, 4)
+		_, err := io.ReadFull(n.r, size)
+		if err != nil {
+			return nil, err
+		}
+		l := binary.BigEndian.Uint32(size)
+		if uint64(l) > uint64(MaxFrameSize) {
+			return nil, e.New(ErrFrameTooBig)
+		}
+		buf := make([]byte, l)
+		_, err = io.ReadFull(n.r, buf)
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, e.Forward(err)
+		}
+		return buf, nil
+	}
+}
+
+// Next returns the next entry and its sequence number. The sequence
+// number is zero if the sender didn't send a NetworkHello. At the end of
+// the stream the error is io.EOF. If the error is ErrInvalidEntry the
+// frame can't be decoded, but the stream is still good and the next entry
+// can be read.
+func (n *NetworkReader) Next() (Entry, uint64, error) {
+	buf, err := n.frame()
+	if err != nil {
+		return nil, 0, err
+	}
+	if n.first {
+		n.first = false
+		// The hello is always JSON and an entry never has a sender.
+		var hello NetworkHello
+		if json.Unmarshal(buf, &hello) == nil && hello.Sender != "" {
+			n.hello = &hello
+			n.seq = hello.Seq
+			buf, err = n.frame()
+			if err != nil {
+				return nil, 0, err
+			}
+		}
+	}
+	var seq uint64
+	if n.hello != nil {
+		seq = n.seq
+		n.seq++
+	}
+	val, err := n.dec.Decode(buf)
+	if err != nil {
+		return nil, seq, e.Push(e.New(ErrInvalidEntry), err)
+	}
+	entry, ok := val.(Entry)
+	if !ok {
+		return nil, seq, e.Push(e.New(ErrInvalidEntry), e.New("%T isn't an entry", val))
+	}
+	return entry, seq, nil
+}
+
+// Hello returns the NetworkHello of the connection or nil if the sender
+// didn't send one. It is read with the first entry.
+func (n *NetworkReader) Hello() *NetworkHello {
+	return n.hello
+}
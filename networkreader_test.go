@@ -0,0 +1,97 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/fcavani/e"
+	"github.com/fcavani/types"
+)
+
+func TestNetworkReader(t *testing.T) {
+	codecs := []interface {
+		Encoder
+		Decoder
+	}{
+		&JSON{TypeName: types.Name(&log{})},
+		&Gob{TypeName: types.Name(&log{})},
+	}
+	for _, enc := range codecs {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(e.Trace(e.Forward(err)))
+		}
+		defer l.Close()
+		n, err := NewNetwork("tcp", l.Addr().String(), NetworkOptions{
+			Encoder: enc,
+			Sender:  "test",
+		})
+		if err != nil {
+			t.Fatal(e.Trace(e.Forward(err)))
+		}
+		logger := New(n, false).Domain("test")
+		logger.Print("one")
+		logger.Print("two")
+
+		conn, err := l.Accept()
+		if err != nil {
+			t.Fatal(e.Trace(e.Forward(err)))
+		}
+		r := NewNetworkReader(conn, enc, FramingAuto)
+		for i, msg := range []string{"one", "two"} {
+			entry, seq, err := r.Next()
+			if err != nil {
+				t.Fatal(e.Trace(e.Forward(err)))
+			}
+			if entry.Message() != msg || entry.GetDomain() != "test" || seq != uint64(i+1) {
+				t.Fatal("wrong entry", entry.Message(), entry.GetDomain(), seq)
+			}
+			if _, _, _, err := ParseID(entry.ID()); err != nil {
+				t.Fatal(e.Trace(e.Forward(err)))
+			}
+		}
+		if h := r.Hello(); h == nil || h.Sender != "test" || h.Node != idNode || h.Seq != 1 {
+			t.Fatalf("wrong hello %+v", h)
+		}
+		err = n.Close()
+		if err != nil {
+			t.Fatal(e.Trace(e.Forward(err)))
+		}
+		_, _, err = r.Next()
+		if err != io.EOF {
+			t.Fatal("wrong error", err)
+		}
+		conn.Close()
+	}
+}
+
+func TestNetworkReaderErrors(t *testing.T) {
+	r := NewNetworkReader(bytes.NewBufferString(`{"msg":"one"}`), nil, FramingAuto)
+	_, _, err := r.Next()
+	if err == nil || err == io.EOF {
+		t.Fatal("truncated frame not detected", err)
+	}
+	r = NewNetworkReader(bytes.NewBuffer([]byte{0xff, 0xff, 0xff, 0xff}), &Gob{}, FramingAuto)
+	_, _, err = r.Next()
+	if err == nil || !e.Equal(err, ErrFrameTooBig) {
+		t.Fatal("big frame not detected", err)
+	}
+	r = NewNetworkReader(bytes.NewBufferString("{\"msg\":1}\n{\"msg\":\"one\"}\n"), nil, FramingLines)
+	_, _, err = r.Next()
+	if err == nil || !e.Equal(err, ErrInvalidEntry) {
+		t.Fatal("invalid entry not detected", err)
+	}
+	entry, seq, err := r.Next()
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	if entry.Message() != "one" || seq != 0 || r.Hello() != nil {
+		t.Fatal("wrong entry", entry.Message(), seq)
+	}
+}
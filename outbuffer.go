@@ -4,38 +4,146 @@
 
 package log
 
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fcavani/e"
+)
+
+// OverflowPolicy is what OutBuffer does when the buffer is full.
+type OverflowPolicy uint8
+
+const (
+	// OverflowBlock blocks the commit until there is space in the buffer.
+	OverflowBlock OverflowPolicy = iota
+	// OverflowTimeout blocks the commit until there is space in the buffer
+	// or the timeout expires, in this case the entry is dropped.
+	OverflowTimeout
+	// OverflowDropNewest drops the entry being committed.
+	OverflowDropNewest
+	// OverflowDropOldest drops the oldest entry in the buffer.
+	OverflowDropOldest
+)
+
+var overflowNames = map[OverflowPolicy]string{
+	OverflowBlock:      "block",
+	OverflowTimeout:    "timeout",
+	OverflowDropNewest: "drop-newest",
+	OverflowDropOldest: "drop-oldest",
+}
+
+func (o OverflowPolicy) String() string {
+	if s, found := overflowNames[o]; found {
+		return s
+	}
+	return "invalid"
+}
+
+// OutBufferOptions configures the OutBuffer.
+type OutBufferOptions struct {
+	// Size is the number of entries in the buffer of each worker.
+	Size int
+	// Overflow is what to do when the buffer is full.
+	Overflow OverflowPolicy
+	// Timeout is how long the commit waits with OverflowTimeout.
+	Timeout time.Duration
+	// Workers is the number of goroutines committing to the backend. Zero
+	// is one. The entries of one domain are always committed by the same
+	// worker, so they stay in order.
+	Workers int
+}
+
+// OutBufferStats are the counters of the OutBuffer.
+type OutBufferStats struct {
+	// Enqueued is the number of entries put in the buffer.
+	Enqueued uint64
+	// Dropped is the number of entries dropped by the overflow policy or
+	// committed after the close.
+	Dropped uint64
+	// Committed is the number of entries committed to the backend.
+	Committed uint64
+	// Queued is the number of entries waiting in the buffer.
+	Queued int
+}
+
+type bufferQueue struct {
+	// enqueued and done are used by Flush. done counts the entries removed
+	// from the queue, committed or dropped. They are the first fields to be
+	// aligned for the atomic operations.
+	enqueued uint64
+	done     uint64
+	ch       chan Entry
+}
+
 type OutBuffer struct {
-	bak     LogBackend
-	ch      chan Entry
-	chclose chan chan struct{}
-	closed  chan struct{}
-	r       Ruler
+	enqueued  uint64
+	dropped   uint64
+	committed uint64
+	bak       LogBackend
+	opts      OutBufferOptions
+	queues    []*bufferQueue
+	r         ruleHolder
+	lck       sync.RWMutex
+	closed    bool
+	wg        sync.WaitGroup
 }
 
+// NewOutBuffer creates a buffer with size entries and one worker that
+// blocks the commit when the buffer is full.
 func NewOutBuffer(bak LogBackend, size int) LogBackend {
+	o, err := NewOutBufferOpts(bak, OutBufferOptions{Size: size})
+	if err != nil {
+		panic(err)
+	}
+	return o
+}
+
+// NewOutBufferOpts creates a buffer between the commit and bak configured
+// by opts.
+func NewOutBufferOpts(bak LogBackend, opts OutBufferOptions) (*OutBuffer, error) {
+	if bak == nil {
+		return nil, e.New("invalid backend")
+	}
+	if opts.Size < 0 || opts.Workers < 0 || opts.Timeout < 0 {
+		return nil, e.New("invalid options")
+	}
+	if _, found := overflowNames[opts.Overflow]; !found {
+		return nil, e.New("invalid overflow policy")
+	}
+	if opts.Overflow == OverflowTimeout && opts.Timeout == 0 {
+		return nil, e.New("timeout is missing")
+	}
+	if opts.Overflow == OverflowDropOldest && opts.Size == 0 {
+		return nil, e.New("drop-oldest needs a buffer")
+	}
+	if opts.Workers == 0 {
+		opts.Workers = 1
+	}
 	o := &OutBuffer{
-		bak:     bak,
-		ch:      make(chan Entry, size),
-		chclose: make(chan chan struct{}),
-		closed:  make(chan struct{}),
+		bak:    bak,
+		opts:   opts,
+		queues: make([]*bufferQueue, opts.Workers),
 	}
-	go func() {
-		for {
-			select {
-			case ch := <-o.chclose:
-				ch <- struct{}{}
-				return
-			case entry := <-o.ch:
-				if entry == nil {
-					o.ch = nil
-					o.closed <- struct{}{}
-					return
-				}
+	for i := range o.queues {
+		q := &bufferQueue{
+			ch: make(chan Entry, opts.Size),
+		}
+		o.queues[i] = q
+		o.wg.Add(1)
+		go func() {
+			defer o.wg.Done()
+			for entry := range q.ch {
 				o.bak.Commit(entry)
+				atomic.AddUint64(&o.committed, 1)
+				atomic.AddUint64(&q.done, 1)
 			}
-		}
-	}()
-	return o
+		}()
+	}
+	return o, nil
 }
 
 func (o *OutBuffer) F(f Formatter) LogBackend {
@@ -48,25 +156,150 @@ func (o *OutBuffer) GetF() Formatter {
 }
 
 func (o *OutBuffer) Filter(r Ruler) LogBackend {
-	o.r = r
+	o.r.Set(precompile(r))
 	return o
 }
 
+func (o *OutBuffer) GetFilter() Ruler {
+	return o.r.Get()
+}
+
+func (o *OutBuffer) Backends() []LogBackend {
+	return []LogBackend{o.bak}
+}
+
+func (o *OutBuffer) queue(entry Entry) *bufferQueue {
+	if len(o.queues) == 1 {
+		return o.queues[0]
+	}
+	h := fnv.New32a()
+	h.Write([]byte(entry.GetDomain()))
+	return o.queues[h.Sum32()%uint32(len(o.queues))]
+}
+
 func (o *OutBuffer) Commit(entry Entry) {
-	if o.r != nil && !o.r.Result(entry) {
+	if !o.r.Pass(entry) {
+		outBufferMetrics.filter()
 		return
 	}
-	o.ch <- entry
+	start := time.Now()
+	outBufferMetrics.observe(start, o.enqueue(entry))
+}
+
+// enqueue puts the entry in its queue and reports if it wasn't dropped.
+func (o *OutBuffer) enqueue(entry Entry) bool {
+	o.lck.RLock()
+	defer o.lck.RUnlock()
+	if o.closed {
+		atomic.AddUint64(&o.dropped, 1)
+		outBufferMetrics.drop()
+		return false
+	}
+	q := o.queue(entry)
+	// enqueued is incremented before the send, otherwise the worker can
+	// increment done before it and Flush returns too early.
+	atomic.AddUint64(&q.enqueued, 1)
+	select {
+	case q.ch <- entry:
+		atomic.AddUint64(&o.enqueued, 1)
+		return true
+	default:
+	}
+	switch o.opts.Overflow {
+	case OverflowBlock:
+		q.ch <- entry
+	case OverflowTimeout:
+		timer := time.NewTimer(o.opts.Timeout)
+		defer timer.Stop()
+		select {
+		case q.ch <- entry:
+		case <-timer.C:
+			o.drop(q)
+			return false
+		}
+	case OverflowDropNewest:
+		o.drop(q)
+		return false
+	case OverflowDropOldest:
+		// Try the send first, only drop if the buffer is still full.
+		for {
+			select {
+			case q.ch <- entry:
+				atomic.AddUint64(&o.enqueued, 1)
+				return true
+			default:
+			}
+			select {
+			case <-q.ch:
+				o.drop(q)
+			default:
+			}
+		}
+	}
+	atomic.AddUint64(&o.enqueued, 1)
+	return true
+}
+
+func (o *OutBuffer) drop(q *bufferQueue) {
+	atomic.AddUint64(&o.dropped, 1)
+	outBufferMetrics.drop()
+	atomic.AddUint64(&q.done, 1)
+}
+
+// Flush waits until all entries in the buffer when Flush was called are
+// committed or the context is done.
+func (o *OutBuffer) Flush(ctx context.Context) error {
+	targets := make([]uint64, len(o.queues))
+	for i, q := range o.queues {
+		targets[i] = atomic.LoadUint64(&q.enqueued)
+	}
+	ticker := time.NewTicker(time.Millisecond)
+	defer ticker.Stop()
+	for {
+		flushed := true
+		for i, q := range o.queues {
+			if atomic.LoadUint64(&q.done) < targets[i] {
+				flushed = false
+				break
+			}
+		}
+		if flushed {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return e.Forward(ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// Stats returns the counters of the buffer.
+func (o *OutBuffer) Stats() OutBufferStats {
+	s := OutBufferStats{
+		Enqueued:  atomic.LoadUint64(&o.enqueued),
+		Dropped:   atomic.LoadUint64(&o.dropped),
+		Committed: atomic.LoadUint64(&o.committed),
+	}
+	for _, q := range o.queues {
+		s.Queued += len(q.ch)
+	}
+	return s
 }
 
+// Close commits the entries in the buffer and stops the workers. The
+// backend isn't closed. Entries committed after the close are dropped.
 func (o *OutBuffer) Close() error {
-	if o.ch == nil {
+	o.lck.Lock()
+	if o.closed {
+		o.lck.Unlock()
 		return nil
 	}
-	close(o.ch)
-	<-o.closed
-	// ch := make(chan struct{})
-	// o.chclose <- ch
-	// <-ch
+	o.closed = true
+	for _, q := range o.queues {
+		close(q.ch)
+	}
+	o.lck.Unlock()
+	o.wg.Wait()
 	return nil
 }
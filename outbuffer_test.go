@@ -0,0 +1,194 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fcavani/e"
+)
+
+// gateBackend records the messages of the entries by domain. The commit
+// blocks until gate is closed.
+type gateBackend struct {
+	gate    chan struct{}
+	started chan struct{}
+	lck     sync.Mutex
+	msgs    map[string][]string
+}
+
+func newGateBackend() *gateBackend {
+	return &gateBackend{
+		gate:    make(chan struct{}),
+		started: make(chan struct{}, 1),
+		msgs:    make(map[string][]string),
+	}
+}
+
+func (g *gateBackend) F(f Formatter) LogBackend  { return g }
+func (g *gateBackend) GetF() Formatter           { return nil }
+func (g *gateBackend) Filter(r Ruler) LogBackend { return g }
+func (g *gateBackend) Close() error              { return nil }
+func (g *gateBackend) messages(domain string) []string {
+	g.lck.Lock()
+	defer g.lck.Unlock()
+	return append([]string{}, g.msgs[domain]...)
+}
+
+func (g *gateBackend) Commit(entry Entry) {
+	select {
+	case g.started <- struct{}{}:
+	default:
+	}
+	<-g.gate
+	g.lck.Lock()
+	g.msgs[entry.GetDomain()] = append(g.msgs[entry.GetDomain()], entry.Message())
+	g.lck.Unlock()
+}
+
+func TestOutBufferOverflow(t *testing.T) {
+	tests := []struct {
+		opts    OutBufferOptions
+		msgs    []string
+		dropped uint64
+	}{
+		{OutBufferOptions{Size: 2, Overflow: OverflowBlock}, []string{"0", "1", "2", "3", "4"}, 0},
+		{OutBufferOptions{Size: 2, Overflow: OverflowTimeout, Timeout: 10 * time.Millisecond}, []string{"0", "1", "2"}, 2},
+		{OutBufferOptions{Size: 2, Overflow: OverflowDropNewest}, []string{"0", "1", "2"}, 2},
+		{OutBufferOptions{Size: 2, Overflow: OverflowDropOldest}, []string{"0", "3", "4"}, 2},
+	}
+	for _, test := range tests {
+		bak := newGateBackend()
+		o, err := NewOutBufferOpts(bak, test.opts)
+		if err != nil {
+			t.Fatal(e.Trace(e.Forward(err)))
+		}
+		logger := New(o, false).Domain("test")
+		// The worker holds the first entry.
+		logger.Print("0")
+		<-bak.started
+		done := make(chan struct{})
+		go func() {
+			for i := 1; i < 5; i++ {
+				logger.Print(strconv.Itoa(i))
+			}
+			close(done)
+		}()
+		if test.opts.Overflow == OverflowBlock {
+			select {
+			case <-done:
+				t.Fatal("commit didn't block")
+			case <-time.After(20 * time.Millisecond):
+			}
+		} else {
+			<-done
+		}
+		close(bak.gate)
+		<-done
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		err = o.Flush(ctx)
+		cancel()
+		if err != nil {
+			t.Fatal(e.Trace(e.Forward(err)))
+		}
+		if msgs := bak.messages("test"); !reflect.DeepEqual(msgs, test.msgs) {
+			t.Fatal(test.opts.Overflow, "wrong messages", msgs)
+		}
+		s := o.Stats()
+		if s.Dropped != test.dropped || s.Committed != uint64(len(test.msgs)) || s.Queued != 0 {
+			t.Fatalf("%v: wrong stats %+v", test.opts.Overflow, s)
+		}
+		err = o.Close()
+		if err != nil {
+			t.Fatal(e.Trace(e.Forward(err)))
+		}
+	}
+}
+
+func TestOutBufferWorkers(t *testing.T) {
+	bak := newGateBackend()
+	close(bak.gate)
+	o, err := NewOutBufferOpts(bak, OutBufferOptions{Size: 10, Workers: 4})
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	domains := []string{"a", "b", "c", "d", "e", "f"}
+	var wg sync.WaitGroup
+	for _, d := range domains {
+		wg.Add(1)
+		go func(d string) {
+			defer wg.Done()
+			logger := New(o, false).Domain(d)
+			for i := 0; i < 100; i++ {
+				logger.Print(strconv.Itoa(i))
+			}
+		}(d)
+	}
+	wg.Wait()
+	err = o.Flush(context.Background())
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	for _, d := range domains {
+		msgs := bak.messages(d)
+		if len(msgs) != 100 {
+			t.Fatal("wrong number of messages", d, len(msgs))
+		}
+		for i, msg := range msgs {
+			if msg != strconv.Itoa(i) {
+				t.Fatal("out of order", d, i, msg)
+			}
+		}
+	}
+	if s := o.Stats(); s.Enqueued != 600 || s.Committed != 600 || s.Dropped != 0 {
+		t.Fatalf("wrong stats %+v", s)
+	}
+
+	err = o.Close()
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	err = o.Close()
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	New(o, false).Print("closed")
+	if s := o.Stats(); s.Dropped != 1 {
+		t.Fatalf("wrong stats %+v", s)
+	}
+}
+
+func TestOutBufferFlushTimeout(t *testing.T) {
+	bak := newGateBackend()
+	o, err := NewOutBufferOpts(bak, OutBufferOptions{Size: 10})
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	New(o, false).Print("blocked")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err = o.Flush(ctx)
+	if err == nil {
+		t.Fatal("nil error")
+	}
+	close(bak.gate)
+	err = o.Close()
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	if msgs := bak.messages(""); len(msgs) != 1 {
+		t.Fatal("entry lost", msgs)
+	}
+
+	_, err = NewOutBufferOpts(bak, OutBufferOptions{Overflow: OverflowTimeout})
+	if err == nil {
+		t.Fatal("nil error")
+	}
+}
@@ -4,22 +4,12 @@
 
 package log
 
-import (
-	"os"
-)
-
+// CommitFail sends the error of an entry to the global error handler.
 func CommitFail(entry Entry, err error) {
-	hostname, _ := os.Hostname()
-	if hostname == "" {
-		hostname = "no name"
-	}
-	dom := entry.GetDomain()
-	if dom == "" {
-		dom = "no domain"
-	}
-	println("LOG IS IN PANIC: " + err.Error() + "\n" + hostname + " - " + dom + " - " + entry.Date().String() + " - " + entry.Level().String() + " - " + entry.Tags().String() + " - " + entry.Message())
+	HandleError(nil, entry, err)
 }
 
+// Fail sends the error to the global error handler.
 func Fail(err error) {
-	println("LOG IS IN PANIC: " + err.Error())
+	HandleError(nil, nil, err)
 }
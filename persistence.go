@@ -21,7 +21,8 @@ var DateFormat = time.RFC3339
 type SendToLogger struct {
 	f Formatter
 	*golog.Logger
-	r Ruler
+	r ruleHolder
+	handlerHolder
 }
 
 func (s *SendToLogger) F(f Formatter) LogBackend {
@@ -34,26 +35,31 @@ func (s *SendToLogger) GetF() Formatter {
 }
 
 func (s *SendToLogger) Filter(r Ruler) LogBackend {
-	s.r = r
+	s.r.Set(r)
 	return s
 }
 
+func (s *SendToLogger) GetFilter() Ruler {
+	return s.r.Get()
+}
+
 func (s *SendToLogger) Commit(entry Entry) {
 	var err error
 	defer func() {
 		if err != nil {
-			CommitFail(entry, err)
+			HandleError(s, entry, err)
 		}
 	}()
 	if s.f == nil {
 		err = e.New("formater not set")
 		return
 	}
-	if s.r != nil && !s.r.Result(entry) {
+	if !s.r.Pass(entry) {
 		return
 	}
 	entry.Formatter(s.f)
-	s.Println(entry.String())
+	// Print only adds the new line if the formatter didn't.
+	s.Print(entry.String())
 }
 
 func (s *SendToLogger) Close() error {
@@ -94,20 +100,24 @@ func (o *outer) Write(p []byte) (n int, err error) {
 
 // MultiLog copy the log entry to multiples backends.
 type MultiLog struct {
-	mp      []LogBackend
-	chclose chan chan struct{}
-	r       Ruler
-	chouter chan []byte
-}
-
-//NewMulti creates a MultiLog
+	mp       []LogBackend
+	children []*multiChild
+	chclose  chan chan struct{}
+	r        ruleHolder
+	chouter  chan []byte
+	lck      sync.RWMutex
+	closed   bool
+}
+
+//NewMulti creates a MultiLog. The backends are committed in the caller
+//goroutine, one after the other, use NewMultiBuilder to commit them with
+//queues.
 func NewMulti(vals ...interface{}) LogBackend {
 	if len(vals)%2 != 0 {
 		Fail(e.New("parameters must be in pair of LogBackend and Formatter"))
 		return nil
 	}
-	l := len(vals) / 2
-	mp := make([]LogBackend, 0, l)
+	b := NewMultiBuilder()
 	for i := 0; i < len(vals); i += 2 {
 		bak, ok := vals[i].(LogBackend)
 		if !ok {
@@ -119,12 +129,14 @@ func NewMulti(vals ...interface{}) LogBackend {
 			Fail(e.New("not a Formatter"))
 			return nil
 		}
-		bak.F(f)
-		mp = append(mp, bak)
+		b.Add(bak, f).Queue(0)
 	}
-	return &MultiLog{
-		mp: mp,
+	mp, err := b.Build()
+	if err != nil {
+		Fail(e.Forward(err))
+		return nil
 	}
+	return mp
 }
 
 func (mp *MultiLog) F(f Formatter) LogBackend {
@@ -136,17 +148,37 @@ func (mp *MultiLog) GetF() Formatter {
 }
 
 func (mp *MultiLog) Filter(r Ruler) LogBackend {
-	mp.r = r
+	mp.r.Set(r)
 	return mp
 }
 
+func (mp *MultiLog) GetFilter() Ruler {
+	return mp.r.Get()
+}
+
+func (mp *MultiLog) Backends() []LogBackend {
+	return mp.mp
+}
+
 func (mp *MultiLog) Commit(entry Entry) {
-	if mp.r != nil && !mp.r.Result(entry) {
+	if !mp.r.Pass(entry) {
+		multiMetrics.filter()
+		return
+	}
+	start := time.Now()
+	mp.lck.RLock()
+	defer mp.lck.RUnlock()
+	if mp.closed {
+		multiMetrics.observe(start, false)
 		return
 	}
-	for _, p := range mp.mp {
-		p.Commit(entry)
+	ok := true
+	for _, c := range mp.children {
+		if !c.commit(entry) {
+			ok = false
+		}
 	}
+	multiMetrics.observe(start, ok)
 }
 
 // outerLog is like outers outerLogs but the nem entry is
@@ -182,21 +214,35 @@ func (mp *MultiLog) OuterLog(level Level, tags ...string) io.Writer {
 	}
 }
 
+// Close waits for the queues to be empty and closes all backends. The
+// errors of all backends are returned.
 func (mp *MultiLog) Close() error {
-	for _, p := range mp.mp {
-		err := p.Close()
-		if err != nil {
-			return e.Forward(err)
-		}
-	}
-	if mp.chclose == nil {
+	mp.lck.Lock()
+	if mp.closed {
+		mp.lck.Unlock()
 		return nil
 	}
-	ch := make(chan struct{})
-	mp.chclose <- ch
-	<-ch
-	mp.chclose = nil
-	return nil
+	mp.closed = true
+	mp.lck.Unlock()
+	if mp.chclose != nil {
+		ch := make(chan struct{})
+		mp.chclose <- ch
+		<-ch
+		mp.chclose = nil
+	}
+	var err error
+	for _, c := range mp.children {
+		er := c.close()
+		if er == nil {
+			continue
+		}
+		if err == nil {
+			err = e.Forward(er)
+			continue
+		}
+		err = e.Push(err, er)
+	}
+	return err
 }
 
 // Writer log to an io.Writer
@@ -207,6 +253,7 @@ type Writer struct {
 	chouter chan []byte
 	lck     sync.Mutex
 	r       Ruler
+	handlerHolder
 }
 
 // NewWriter creates a backend that log to w.
@@ -232,10 +279,16 @@ func (w *Writer) GetF() Formatter {
 func (w *Writer) Filter(r Ruler) LogBackend {
 	w.lck.Lock()
 	defer w.lck.Unlock()
-	w.r = r
+	w.r = precompile(r)
 	return w
 }
 
+func (w *Writer) GetFilter() Ruler {
+	w.lck.Lock()
+	defer w.lck.Unlock()
+	return w.r
+}
+
 func (w *Writer) Writer(writter io.Writer) {
 	w.lck.Lock()
 	defer w.lck.Unlock()
@@ -248,12 +301,14 @@ func (w *Writer) Commit(entry Entry) {
 	var err error
 	defer func() {
 		if err != nil {
-			CommitFail(entry, err)
+			HandleError(w, entry, err)
 		}
 	}()
 	if w.r != nil && !w.r.Result(entry) {
+		writerMetrics.filter()
 		return
 	}
+	defer writerMetrics.commit(time.Now(), &err)
 	if w.f == nil {
 		err = e.New("formater not set")
 		return
@@ -318,12 +373,20 @@ func (w *Writer) Close() error {
 	return nil
 }
 
+const ErrGenericClosed = "generic backend is closed"
+
 type Generic struct {
 	f       Formatter
 	s       Storer
 	chclose chan chan struct{}
 	chouter chan []byte
-	r       Ruler
+	r       ruleHolder
+	batch   BatchOptions
+	lck     sync.Mutex
+	pending []Entry
+	timer   *time.Timer
+	closed  bool
+	handlerHolder
 }
 
 func NewGeneric(s Storer) LogBackend {
@@ -333,6 +396,32 @@ func NewGeneric(s Storer) LogBackend {
 	return g
 }
 
+// BatchOptions configures the batch mode of Generic.
+type BatchOptions struct {
+	// Size is the max number of entries written in one transaction.
+	Size int
+	// Latency is the max time one entry waits in the batch. Zero waits
+	// until the batch is full or the backend is closed.
+	Latency time.Duration
+}
+
+// NewGenericBatch creates a Generic that accumulates the entries and
+// writes them in one transaction. If the transaction fails the entries
+// are written one by one.
+func NewGenericBatch(s Storer, opts BatchOptions) (*Generic, error) {
+	if s == nil {
+		return nil, e.New("invalid storer")
+	}
+	if opts.Size <= 0 || opts.Latency < 0 {
+		return nil, e.New("invalid options")
+	}
+	return &Generic{
+		s:       s,
+		batch:   opts,
+		pending: make([]Entry, 0, opts.Size),
+	}, nil
+}
+
 func (g *Generic) F(f Formatter) LogBackend {
 	g.f = f
 	return g
@@ -343,36 +432,116 @@ func (g *Generic) GetF() Formatter {
 }
 
 func (g *Generic) Filter(r Ruler) LogBackend {
-	g.r = r
+	g.r.Set(r)
 	return g
 }
 
+func (g *Generic) GetFilter() Ruler {
+	return g.r.Get()
+}
+
 func (g *Generic) Commit(entry Entry) {
 	var err error
 	defer func() {
 		if err != nil {
-			CommitFail(entry, err)
+			HandleError(g, entry, err)
 		}
 	}()
-	if g.r != nil && !g.r.Result(entry) {
+	if !g.r.Pass(entry) {
+		genericMetrics.filter()
 		return
 	}
+	defer genericMetrics.commit(time.Now(), &err)
 	if g.f == nil {
 		err = e.New("formater not set")
 		return
 	}
-	err = g.s.Tx(true, func(tx Transaction) error {
-		entry.Formatter(g.f)
-		err := tx.Put(entry.Date().Format(time.RFC3339Nano), entry)
-		if err != nil {
-			return e.Forward(err)
+	entry.Formatter(g.f)
+	if g.batch.Size > 0 {
+		err = g.add(entry)
+		return
+	}
+	err = g.put(entry)
+	if err != nil {
+		err = e.Forward(err)
+		return
+	}
+}
+
+func putEntry(tx Transaction, entry Entry) error {
+	key := entry.ID()
+	if key == "" {
+		// Entries without id, like the ones of other Entry
+		// implementations, get an unique one.
+		key = NewID(entry.Date())
+	}
+	err := tx.Put(key, entry)
+	if err != nil {
+		return e.Forward(err)
+	}
+	return nil
+}
+
+func (g *Generic) put(entry Entry) error {
+	err := g.s.Tx(true, func(tx Transaction) error {
+		return putEntry(tx, entry)
+	})
+	if err != nil {
+		return e.Forward(err)
+	}
+	return nil
+}
+
+func (g *Generic) add(entry Entry) error {
+	g.lck.Lock()
+	defer g.lck.Unlock()
+	if g.closed {
+		return e.New(ErrGenericClosed)
+	}
+	g.pending = append(g.pending, entry)
+	if len(g.pending) >= g.batch.Size {
+		g.flush()
+		return nil
+	}
+	if len(g.pending) == 1 && g.batch.Latency > 0 {
+		g.timer = time.AfterFunc(g.batch.Latency, func() {
+			g.lck.Lock()
+			defer g.lck.Unlock()
+			g.flush()
+		})
+	}
+	return nil
+}
+
+// flush writes the pending entries. g.lck must be locked.
+func (g *Generic) flush() {
+	if g.timer != nil {
+		g.timer.Stop()
+		g.timer = nil
+	}
+	if len(g.pending) == 0 {
+		return
+	}
+	entries := g.pending
+	g.pending = make([]Entry, 0, g.batch.Size)
+	err := g.s.Tx(true, func(tx Transaction) error {
+		for _, entry := range entries {
+			err := putEntry(tx, entry)
+			if err != nil {
+				return e.Forward(err)
+			}
 		}
 		return nil
 	})
-	if err != nil {
-		err = e.Forward(err)
+	if err == nil {
 		return
 	}
+	for _, entry := range entries {
+		err = g.put(entry)
+		if err != nil {
+			HandleError(g, entry, e.Forward(err))
+		}
+	}
 }
 
 func (g *Generic) OuterLog(level Level, tags ...string) io.Writer {
@@ -403,6 +572,14 @@ func (g *Generic) OuterLog(level Level, tags ...string) io.Writer {
 }
 
 func (g *Generic) Close() error {
+	g.lck.Lock()
+	if g.closed {
+		g.lck.Unlock()
+		return nil
+	}
+	g.flush()
+	g.closed = true
+	g.lck.Unlock()
 	err := g.s.Close()
 	if err != nil {
 		return e.Forward(err)
@@ -10,7 +10,9 @@ import (
 	"os"
 	"reflect"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/fcavani/e"
 )
@@ -107,3 +109,153 @@ func TestBackends(t *testing.T) {
 		testLogBackend(t, p)
 	}
 }
+
+// countStore counts the transactions and records the messages written by
+// the transactions that succeed. Put fails for the message fail.
+type countStore struct {
+	Storer
+	lck    sync.Mutex
+	txs    int
+	msgs   []string
+	fail   string
+	closes int
+}
+
+type countTx struct {
+	Transaction
+	c    *countStore
+	msgs []string
+}
+
+func (t *countTx) Put(key string, data interface{}) error {
+	msg := data.(Entry).Message()
+	if msg == t.c.fail {
+		return e.New("bad entry")
+	}
+	t.msgs = append(t.msgs, msg)
+	return t.Transaction.Put(key, data)
+}
+
+func (c *countStore) Tx(write bool, f func(tx Transaction) error) error {
+	c.lck.Lock()
+	defer c.lck.Unlock()
+	c.txs++
+	tx := &countTx{c: c}
+	err := c.Storer.Tx(write, func(t Transaction) error {
+		tx.Transaction = t
+		return f(tx)
+	})
+	if err != nil {
+		return err
+	}
+	c.msgs = append(c.msgs, tx.msgs...)
+	return nil
+}
+
+func (c *countStore) Close() error {
+	c.lck.Lock()
+	c.closes++
+	c.lck.Unlock()
+	return c.Storer.Close()
+}
+
+func (c *countStore) closed() int {
+	c.lck.Lock()
+	defer c.lck.Unlock()
+	return c.closes
+}
+
+func (c *countStore) count() (int, []string) {
+	c.lck.Lock()
+	defer c.lck.Unlock()
+	return c.txs, append([]string{}, c.msgs...)
+}
+
+func TestGenericBatch(t *testing.T) {
+	m, err := NewMap(0)
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	s := &countStore{Storer: m, fail: "bad"}
+	g, err := NewGenericBatch(s, BatchOptions{Size: 3})
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	logger := New(g.F(DefFormatter), false)
+	for _, msg := range []string{"1", "2", "3", "4", "bad", "5", "6"} {
+		logger.Print(msg)
+	}
+	txs, msgs := s.count()
+	// One transaction for 1, 2 and 3, one failed for 4, bad and 5 and
+	// one for each of them.
+	if txs != 5 || !reflect.DeepEqual(msgs, []string{"1", "2", "3", "4", "5"}) {
+		t.Fatal("wrong transactions", txs, msgs)
+	}
+	err = g.Close()
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	txs, msgs = s.count()
+	if txs != 6 || len(msgs) != 6 || msgs[5] != "6" {
+		t.Fatal("batch not flushed", txs, msgs)
+	}
+
+	var failed error
+	g.SetErrorHandler(ErrorHandlerFunc(func(bak LogBackend, entry Entry, err error) {
+		failed = err
+	}))
+	logger.Print("7")
+	if failed == nil || !e.Equal(failed, ErrGenericClosed) {
+		t.Fatal("wrong error", failed)
+	}
+	if txs, msgs = s.count(); txs != 6 || len(msgs) != 6 {
+		t.Fatal("entry written after close", txs, msgs)
+	}
+
+	_, err = NewGenericBatch(s, BatchOptions{})
+	if err == nil {
+		t.Fatal("nil error")
+	}
+}
+
+func TestGenericBatchLatency(t *testing.T) {
+	m, err := NewMap(0)
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	s := &countStore{Storer: m}
+	g, err := NewGenericBatch(s, BatchOptions{Size: 100, Latency: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	defer g.Close()
+	logger := New(g.F(DefFormatter), false)
+	logger.Print("1")
+	logger.Print("2")
+	if txs, _ := s.count(); txs != 0 {
+		t.Fatal("batch written too early")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		txs, msgs := s.count()
+		if txs == 1 && len(msgs) == 2 {
+			break
+		}
+		if txs > 1 || time.Now().After(deadline) {
+			t.Fatal("batch not written", txs, msgs)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	// Close is idempotent, the store is closed only once.
+	err = g.Close()
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	err = g.Close()
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	if n := s.closed(); n != 1 {
+		t.Fatal("store closed", n, "times")
+	}
+}
@@ -0,0 +1,224 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"time"
+
+	"github.com/fcavani/e"
+)
+
+const ErrNotEntry = "data isn't a log entry"
+
+// QuerySpec describes which entries are read from the store.
+type QuerySpec struct {
+	// Start is the first instant of the range, if zero starts from the
+	// first entry.
+	Start time.Time
+	// End is the end of the range, entries at End aren't included. If zero
+	// goes until the last entry.
+	End time.Time
+	// Filter selects the entries, if nil all entries are selected.
+	Filter Ruler
+	// Limit is the max number of entries returned, zero is unlimited.
+	Limit int
+	// Offset skips the first entries selected.
+	Offset int
+	// Dir is the direction of the iteration, LeftToRight from the oldest
+	// to the newest or RightToLeft from the newest to the oldest.
+	Dir dir
+}
+
+// inRange returns false if date is before the range, or after it, and
+// stop is true if no more entries will match in the direction of the
+// iteration.
+func (q *QuerySpec) inRange(date time.Time) (in, stop bool) {
+	if !q.Start.IsZero() && date.Before(q.Start) {
+		return false, q.Dir == RightToLeft
+	}
+	if !q.End.IsZero() && !date.Before(q.End) {
+		return false, q.Dir == LeftToRight
+	}
+	return true, false
+}
+
+// Iterator walks through the result of a query.
+type Iterator interface {
+	// Next advances to the next entry. It returns false when there is no
+	// more entries or when an error occurs.
+	Next() bool
+	// Entry returns the current entry.
+	Entry() Entry
+	// Err returns the error, if any, occurred during the iteration.
+	Err() error
+	// Close releases the resources of the iterator.
+	Close() error
+}
+
+// Querier is implemented by the stores that can run the query natively.
+type Querier interface {
+	Query(q QuerySpec) (Iterator, error)
+}
+
+// txIter streams the entries read by a transaction that runs in its own
+// goroutine. The transaction is held until the entries end or Close is
+// called.
+type txIter struct {
+	ch    chan Entry
+	done  chan struct{}
+	errc  chan error
+	entry Entry
+	err   error
+}
+
+func (t *txIter) Next() bool {
+	if t.ch == nil {
+		return false
+	}
+	entry, ok := <-t.ch
+	if !ok {
+		t.err = <-t.errc
+		t.ch = nil
+		t.entry = nil
+		return false
+	}
+	t.entry = entry
+	return true
+}
+
+func (t *txIter) Entry() Entry {
+	return t.entry
+}
+
+func (t *txIter) Err() error {
+	return t.err
+}
+
+func (t *txIter) Close() error {
+	if t.ch == nil {
+		return nil
+	}
+	close(t.done)
+	for range t.ch {
+	}
+	err := <-t.errc
+	t.ch = nil
+	t.entry = nil
+	if err != nil {
+		return e.Forward(err)
+	}
+	return nil
+}
+
+// Query reads the entries stored in s by the Generic backend. If s
+// implements Querier the query is delegated to it. Otherwise the entries
+// are read from a read transaction that is held until the iterator ends
+// or is closed, so Close must always be called. Some stores, like Map,
+// block the writes while the transaction is open.
+func Query(s Storer, q QuerySpec) (Iterator, error) {
+	filter, err := checkRule(q.Filter)
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	if querier, ok := s.(Querier); ok {
+		it, err := querier.Query(q)
+		if err != nil {
+			return nil, e.Forward(err)
+		}
+		return it, nil
+	}
+	if q.Limit < 0 || q.Offset < 0 {
+		return nil, e.New("invalid limit or offset")
+	}
+	q.Filter = filter
+	it := &txIter{
+		ch:   make(chan Entry),
+		done: make(chan struct{}),
+		errc: make(chan error, 1),
+	}
+	go func() {
+		err := s.Tx(false, func(tx Transaction) (err error) {
+			defer func() {
+				// The rules for other entries may panic.
+				if r := recover(); r != nil {
+					err = e.New("filter failed: %v", r)
+				}
+			}()
+			return send(tx.Cursor(), q, it.ch, it.done)
+		})
+		close(it.ch)
+		it.errc <- err
+	}()
+	return it, nil
+}
+
+// send sends the entries selected by q to ch until the entries end or
+// done is closed.
+func send(c Cursor, q QuerySpec, ch chan<- Entry, done <-chan struct{}) error {
+	var key string
+	var data interface{}
+	if q.Dir == RightToLeft {
+		key, data = seekEnd(c, q.End)
+	} else {
+		key, data = seekStart(c, q.Start)
+	}
+	skip := q.Offset
+	n := 0
+	for ; key != ""; key, data = step(c, q.Dir) {
+		entry, ok := data.(Entry)
+		if !ok {
+			return e.New(ErrNotEntry)
+		}
+		in, stop := q.inRange(entry.Date())
+		if stop {
+			break
+		}
+		if !in {
+			continue
+		}
+		if q.Filter != nil && !q.Filter.Result(entry) {
+			continue
+		}
+		if skip > 0 {
+			skip--
+			continue
+		}
+		select {
+		case ch <- entry:
+		case <-done:
+			return nil
+		}
+		n++
+		if q.Limit > 0 && n >= q.Limit {
+			break
+		}
+	}
+	return nil
+}
+
+func seekStart(c Cursor, start time.Time) (string, interface{}) {
+	if start.IsZero() {
+		return c.First()
+	}
+	return c.Seek(timeKey(start))
+}
+
+func seekEnd(c Cursor, end time.Time) (string, interface{}) {
+	if end.IsZero() {
+		return c.Last()
+	}
+	key, data := c.Seek(timeKey(end))
+	if key == "" {
+		return c.Last()
+	}
+	return key, data
+}
+
+func step(c Cursor, d dir) (string, interface{}) {
+	if d == RightToLeft {
+		return c.Prev()
+	}
+	return c.Next()
+}
@@ -0,0 +1,184 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/fcavani/e"
+	"github.com/fcavani/tags"
+	"gopkg.in/mgo.v2/bson"
+)
+
+var queryBase = time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func queryStore(t *testing.T) Storer {
+	m, err := NewMap(10)
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	err = m.Tx(true, func(tx Transaction) error {
+		for i := 0; i < 10; i++ {
+			prio := InfoPrio
+			if i%2 == 0 {
+				prio = ErrorPrio
+			}
+			entry := &log{
+				Timestamp: queryBase.Add(time.Duration(i) * time.Minute),
+				Priority:  prio,
+				Labels:    &tags.Tags{},
+				Msg:       strconv.Itoa(i),
+			}
+			err := tx.Put(timeKey(entry.Timestamp), entry)
+			if err != nil {
+				return e.Forward(err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	return m
+}
+
+func queryResult(t *testing.T, s Storer, q QuerySpec) string {
+	it, err := Query(s, q)
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	defer it.Close()
+	str := ""
+	for it.Next() {
+		str += it.Entry().Message()
+	}
+	if it.Err() != nil {
+		t.Fatal(e.Trace(e.Forward(it.Err())))
+	}
+	return str
+}
+
+func TestQuery(t *testing.T) {
+	s := queryStore(t)
+	tests := []struct {
+		q      QuerySpec
+		result string
+	}{
+		{QuerySpec{}, "0123456789"},
+		{QuerySpec{Dir: RightToLeft}, "9876543210"},
+		{QuerySpec{Start: queryBase.Add(3 * time.Minute), End: queryBase.Add(6 * time.Minute)}, "345"},
+		{QuerySpec{Start: queryBase.Add(3 * time.Minute), End: queryBase.Add(6 * time.Minute), Dir: RightToLeft}, "543"},
+		{QuerySpec{Filter: Op(Eq, "level", ErrorPrio)}, "02468"},
+		{QuerySpec{Filter: Op(Eq, "level", ErrorPrio), Offset: 1, Limit: 2}, "24"},
+		{QuerySpec{Filter: Op(Eq, "level", InfoPrio), Limit: 2, Dir: RightToLeft}, "97"},
+		{QuerySpec{Start: queryBase.Add(time.Hour)}, ""},
+	}
+	for i, test := range tests {
+		r := queryResult(t, s, test.q)
+		if r != test.result {
+			t.Fatal("wrong result", i, r, test.result)
+		}
+	}
+
+	// The filter is checked before the query starts.
+	_, err := Query(s, QuerySpec{Filter: Op(Eq, "level", "error")})
+	if e.Find(err, ErrFilterNotCompiled) < 0 {
+		t.Fatal("wrong error", err)
+	}
+	// Rules that panic with the entries stop the query.
+	it, err := Query(s, QuerySpec{Filter: Op(Eq, "str", "a")})
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	for it.Next() {
+	}
+	if it.Err() == nil {
+		t.Fatal("nil error")
+	}
+	it.Close()
+}
+
+func TestQueryGeneric(t *testing.T) {
+	m, err := NewMap(10)
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	logger := New(NewGeneric(m).F(DefFormatter), false)
+	start := time.Now()
+	logger.Print("a")
+	logger.Print("b")
+	logger.Print("c")
+	r := queryResult(t, m, QuerySpec{Start: start, Dir: RightToLeft})
+	if r != "cba" {
+		t.Fatal("wrong result", r)
+	}
+}
+
+func TestQueryClose(t *testing.T) {
+	s := queryStore(t)
+	it, err := Query(s, QuerySpec{})
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	if !it.Next() || it.Entry().Message() != "0" {
+		t.Fatal("wrong entry")
+	}
+	err = it.Close()
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	if it.Next() || it.Close() != nil {
+		t.Fatal("iterator not closed")
+	}
+	// The read transaction was released.
+	err = s.Tx(true, func(tx Transaction) error {
+		return nil
+	})
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+
+	err = s.Tx(true, func(tx Transaction) error {
+		return tx.Put(timeKey(queryBase.Add(time.Hour)), "not an entry")
+	})
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	r := ""
+	it, err = Query(s, QuerySpec{})
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	defer it.Close()
+	for it.Next() {
+		r += it.Entry().Message()
+	}
+	if r != "0123456789" || !e.Equal(it.Err(), ErrNotEntry) {
+		t.Fatal("wrong result", r, it.Err())
+	}
+}
+
+func TestRulerToBson(t *testing.T) {
+	typ := reflect.TypeOf(&log{})
+	q, ok := rulerToBson(And(Op(Ge, "level", WarnPrio), Op(Pr, "domain", "api"), Op(Eq, "field.user_id", 42)), typ)
+	if !ok {
+		t.Fatal("can't translate")
+	}
+	expected := bson.M{"$and": []bson.M{
+		{"priority": bson.M{"$gte": WarnPrio}},
+		{"dom": bson.RegEx{Pattern: "^api"}},
+		{"fields.user_id": 42},
+	}}
+	if !reflect.DeepEqual(q, expected) {
+		t.Fatal("wrong query", q)
+	}
+	_, ok = rulerToBson(Op(Eq, "nofield", 1), typ)
+	if ok {
+		t.Fatal("translated an invalid field")
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"crypto/tls"
+	"net"
+	"time"
+
+	"github.com/fcavani/e"
+)
+
+// remoteConn is the connection of the backends that send the entries to a
+// server. It connects in the first write and again after an error. The
+// lock of the backend must be held to use it.
+type remoteConn struct {
+	network      string
+	addr         string
+	packet       bool
+	tls          *tls.Config
+	dialTimeout  time.Duration
+	writeTimeout time.Duration
+	conn         net.Conn
+}
+
+// newRemoteConn checks the options of the connection. The timeouts
+// default to 5s.
+func newRemoteConn(network, addr string, tlsConfig *tls.Config, dialTimeout, writeTimeout time.Duration) (*remoteConn, error) {
+	if addr == "" {
+		return nil, e.New("invalid address")
+	}
+	packet := isPacket(network)
+	if packet && tlsConfig != nil {
+		return nil, e.New("tls isn't supported with %v", network)
+	}
+	if dialTimeout < 0 || writeTimeout < 0 {
+		return nil, e.New("invalid timeout")
+	}
+	if dialTimeout == 0 {
+		dialTimeout = 5 * time.Second
+	}
+	if writeTimeout == 0 {
+		writeTimeout = 5 * time.Second
+	}
+	return &remoteConn{
+		network:      network,
+		addr:         addr,
+		packet:       packet,
+		tls:          tlsConfig,
+		dialTimeout:  dialTimeout,
+		writeTimeout: writeTimeout,
+	}, nil
+}
+
+func (c *remoteConn) dial() (net.Conn, error) {
+	dialer := &net.Dialer{
+		Timeout: c.dialTimeout,
+	}
+	if c.tls != nil {
+		return tls.DialWithDialer(dialer, c.network, c.addr, c.tls)
+	}
+	return dialer.Dial(c.network, c.addr)
+}
+
+// write sends the buffers, the datagrams or the message, with stream
+// networks it tries again with a new connection if nothing was written.
+// After a partial write the server has part of the message, so it isn't
+// sent again.
+func (c *remoteConn) write(bufs [][]byte) error {
+	n, err := c.send(bufs)
+	if err != nil && !c.packet && n == 0 {
+		// The server may have closed the connection, try again with a new
+		// one.
+		_, err = c.send(bufs)
+	}
+	return err
+}
+
+// send writes the buffers, connecting if needed, and returns the number of
+// bytes written.
+func (c *remoteConn) send(bufs [][]byte) (int, error) {
+	if c.conn == nil {
+		conn, err := c.dial()
+		if err != nil {
+			return 0, e.Forward(err)
+		}
+		c.conn = conn
+	}
+	var n int
+	err := c.conn.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+	for i := 0; i < len(bufs) && err == nil; i++ {
+		var m int
+		m, err = c.conn.Write(bufs[i])
+		n += m
+	}
+	if err != nil {
+		c.conn.Close()
+		c.conn = nil
+		return n, e.Forward(err)
+	}
+	return n, nil
+}
+
+func (c *remoteConn) close() error {
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn = nil
+	if err != nil {
+		return e.Forward(err)
+	}
+	return nil
+}
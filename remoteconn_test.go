@@ -0,0 +1,78 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/fcavani/e"
+)
+
+// brokenConn writes n bytes and fails.
+type brokenConn struct {
+	net.Conn
+	n int
+}
+
+func (b *brokenConn) SetWriteDeadline(t time.Time) error { return nil }
+func (b *brokenConn) Close() error                       { return nil }
+func (b *brokenConn) Write(p []byte) (int, error) {
+	if b.n > len(p) {
+		b.n = len(p)
+	}
+	return b.n, e.New("broken connection")
+}
+
+func TestRemoteConnRetry(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	defer l.Close()
+	c, err := newRemoteConn("tcp", l.Addr().String(), nil, 0, 0)
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	defer c.close()
+
+	// Nothing was written, the message is sent in a new connection.
+	c.conn = &brokenConn{}
+	err = c.write([][]byte{[]byte("one")})
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	c.close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf, err := ioutil.ReadAll(conn)
+	conn.Close()
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	if string(buf) != "one" {
+		t.Fatalf("wrong message %q", buf)
+	}
+
+	// Part of the message was written, it isn't sent again.
+	c.conn = &brokenConn{n: 1}
+	err = c.write([][]byte{[]byte("two")})
+	if err == nil {
+		t.Fatal("partial write not reported")
+	}
+	if c.conn != nil {
+		t.Fatal("connection not closed")
+	}
+	l.(*net.TCPListener).SetDeadline(time.Now().Add(50 * time.Millisecond))
+	if conn, err := l.Accept(); err == nil {
+		conn.Close()
+		t.Fatal("message sent again")
+	}
+}
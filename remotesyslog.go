@@ -0,0 +1,315 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"bytes"
+	"crypto/tls"
+	"log/syslog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fcavani/e"
+)
+
+const ErrRemoteSyslogClosed = "remote syslog backend is closed"
+
+// SyslogFormat is the format of the messages of the RemoteSyslog.
+type SyslogFormat uint8
+
+const (
+	// RFC5424 is the format of the RFC 5424, with structured data.
+	RFC5424 SyslogFormat = iota
+	// RFC3164 is the BSD format. It has no structured data, the data is
+	// in the begin of the message.
+	RFC3164
+)
+
+// RemoteSyslogOptions configures the RemoteSyslog backend.
+type RemoteSyslogOptions struct {
+	// Format of the messages.
+	Format SyslogFormat
+	// Facility is one of the facilities of log/syslog, like
+	// syslog.LOG_DAEMON. The default is syslog.LOG_USER.
+	Facility syslog.Priority
+	// Hostname is the HOSTNAME of the messages. Default is os.Hostname.
+	Hostname string
+	// AppName is the APP-NAME. Default is the name of the program.
+	AppName string
+	// ProcID is the PROCID. Default is the pid.
+	ProcID string
+	// MsgID is the MSGID, the default is empty.
+	MsgID string
+	// SDID is the SD-ID of the element with the data of the entries.
+	// Default is log@32473. The element of the fields has the same
+	// enterprise number, like fields@32473.
+	SDID string
+	// TLS enables TLS for tcp.
+	TLS *tls.Config
+	// DialTimeout and WriteTimeout are the timeouts of the connection.
+	// Default 5s.
+	DialTimeout  time.Duration
+	WriteTimeout time.Duration
+}
+
+// RemoteSyslog sends the entries to a syslog server. With stream
+// networks, like tcp, the messages are framed by octet counting, RFC
+// 6587, and with packet networks, like udp, each message is a datagram.
+type RemoteSyslog struct {
+	opts    RemoteSyslogOptions
+	fieldID string
+	r       ruleHolder
+	handlerHolder
+
+	lck    sync.Mutex
+	f      Formatter
+	conn   *remoteConn
+	closed bool
+}
+
+// syslogSafe replaces the chars not allowed in the header fields.
+func syslogSafe(s string, max int) string {
+	if s == "" {
+		return "-"
+	}
+	s = strings.Map(func(r rune) rune {
+		if r < 33 || r > 126 {
+			return '_'
+		}
+		return r
+	}, s)
+	if len(s) > max {
+		s = s[:max]
+	}
+	return s
+}
+
+// NewRemoteSyslog creates a backend that sends the entries to the syslog
+// server in addr. network is one of the networks of net.Dial, like udp,
+// tcp or unixgram. The connection is made in the first commit.
+func NewRemoteSyslog(network, addr string, opts RemoteSyslogOptions) (*RemoteSyslog, error) {
+	conn, err := newRemoteConn(network, addr, opts.TLS, opts.DialTimeout, opts.WriteTimeout)
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	if opts.Format > RFC3164 || opts.Facility > syslog.LOG_LOCAL7 ||
+		opts.Facility&7 != 0 {
+		return nil, e.New("invalid options")
+	}
+	if opts.Facility == 0 {
+		opts.Facility = syslog.LOG_USER
+	}
+	if opts.Hostname == "" {
+		opts.Hostname = hostname()
+	}
+	if opts.AppName == "" {
+		opts.AppName = filepath.Base(os.Args[0])
+	}
+	if opts.ProcID == "" {
+		opts.ProcID = strconv.Itoa(os.Getpid())
+	}
+	if opts.SDID == "" {
+		opts.SDID = "log@32473"
+	}
+	i := strings.Index(opts.SDID, "@")
+	if i < 1 || strings.ContainsAny(opts.SDID, ` ="]`) || len(opts.SDID) > 32 {
+		return nil, e.New("invalid sd-id %v", opts.SDID)
+	}
+	opts.Hostname = syslogSafe(opts.Hostname, 255)
+	opts.AppName = syslogSafe(opts.AppName, 48)
+	opts.ProcID = syslogSafe(opts.ProcID, 128)
+	opts.MsgID = syslogSafe(opts.MsgID, 32)
+	return &RemoteSyslog{
+		opts:    opts,
+		fieldID: "fields" + opts.SDID[i:],
+		conn:    conn,
+	}, nil
+}
+
+// F sets the formatter of the MSG part, nil sends only the message of the
+// entries. The header and the structured data don't use it.
+func (s *RemoteSyslog) F(f Formatter) LogBackend {
+	s.lck.Lock()
+	defer s.lck.Unlock()
+	s.f = f
+	return s
+}
+
+func (s *RemoteSyslog) GetF() Formatter {
+	s.lck.Lock()
+	defer s.lck.Unlock()
+	return s.f
+}
+
+func (s *RemoteSyslog) Filter(r Ruler) LogBackend {
+	s.r.Set(precompile(r))
+	return s
+}
+
+func (s *RemoteSyslog) GetFilter() Ruler {
+	return s.r.Get()
+}
+
+// severity maps the levels to the severities of syslog, like the Syslog
+// backend.
+func severity(l Level) syslog.Priority {
+	switch l {
+	case ProtoPrio, DebugPrio:
+		return syslog.LOG_DEBUG
+	case InfoPrio:
+		return syslog.LOG_INFO
+	case WarnPrio:
+		return syslog.LOG_WARNING
+	case ErrorPrio:
+		return syslog.LOG_ERR
+	case FatalPrio:
+		return syslog.LOG_CRIT
+	case PanicPrio:
+		return syslog.LOG_EMERG
+	default:
+		return syslog.LOG_NOTICE
+	}
+}
+
+var sdEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+// sdName replaces the chars not allowed in the SD-NAME.
+func sdName(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if r < 33 || r > 126 || r == '=' || r == ']' || r == '"' {
+			return '_'
+		}
+		return r
+	}, s)
+	if len(s) > 32 {
+		s = s[:32]
+	}
+	return s
+}
+
+func sdParam(buf *bytes.Buffer, name, val string) {
+	buf.WriteByte(' ')
+	buf.WriteString(sdName(name))
+	buf.WriteString(`="`)
+	buf.WriteString(sdEscaper.Replace(val))
+	buf.WriteByte('"')
+}
+
+// structured returns the STRUCTURED-DATA with the fields of the entry
+// that aren't in the header.
+func (s *RemoteSyslog) structured(entry Entry) []byte {
+	buf := bytes.NewBuffer(make([]byte, 0, 128))
+	buf.WriteByte('[')
+	buf.WriteString(s.opts.SDID)
+	if dom := entry.GetDomain(); dom != "" {
+		sdParam(buf, "domain", dom)
+	}
+	if t := entry.Tags(); t != nil && t.String() != "" {
+		sdParam(buf, "tags", t.String())
+	}
+	file, pkg, fn := entryCode(entry)
+	if file != "" {
+		sdParam(buf, "file", file)
+	}
+	if pkg != "" {
+		sdParam(buf, "pkg", pkg)
+	}
+	if fn != "" {
+		sdParam(buf, "func", fn)
+	}
+	buf.WriteByte(']')
+	if fields := entry.GetFields(); len(fields) > 0 {
+		keys := make([]string, 0, len(fields))
+		for k := range fields {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		buf.WriteByte('[')
+		buf.WriteString(s.fieldID)
+		for _, k := range keys {
+			sdParam(buf, k, fieldString(fields[k]))
+		}
+		buf.WriteByte(']')
+	}
+	return buf.Bytes()
+}
+
+// Message returns the syslog message of the entry, without framing.
+func (s *RemoteSyslog) Message(entry Entry) []byte {
+	buf := bytes.NewBuffer(make([]byte, 0, 256))
+	buf.WriteByte('<')
+	buf.WriteString(strconv.Itoa(int(s.opts.Facility | severity(entry.Level()))))
+	buf.WriteByte('>')
+	msg := entry.Message()
+	if f := s.GetF(); f != nil {
+		entry.Formatter(f)
+		msg = entry.String()
+	}
+	msg = strings.TrimRight(msg, "\r\n")
+	if s.opts.Format == RFC3164 {
+		buf.WriteString(entry.Date().Format(time.Stamp))
+		buf.WriteByte(' ')
+		buf.WriteString(s.opts.Hostname)
+		buf.WriteByte(' ')
+		buf.WriteString(s.opts.AppName)
+		buf.WriteString("[" + s.opts.ProcID + "]: ")
+		buf.Write(s.structured(entry))
+		buf.WriteByte(' ')
+		buf.WriteString(msg)
+		return buf.Bytes()
+	}
+	buf.WriteString("1 ")
+	buf.WriteString(entry.Date().Format("2006-01-02T15:04:05.000000Z07:00"))
+	for _, h := range []string{s.opts.Hostname, s.opts.AppName, s.opts.ProcID, s.opts.MsgID} {
+		buf.WriteByte(' ')
+		buf.WriteString(h)
+	}
+	buf.WriteByte(' ')
+	buf.Write(s.structured(entry))
+	if msg != "" {
+		buf.WriteString(" \xef\xbb\xbf")
+		buf.WriteString(msg)
+	}
+	return buf.Bytes()
+}
+
+func (s *RemoteSyslog) Commit(entry Entry) {
+	if !s.r.Pass(entry) {
+		syslogMetrics.filter()
+		return
+	}
+	var err error
+	defer syslogMetrics.commit(time.Now(), &err)
+	msg := s.Message(entry)
+	if !s.conn.packet {
+		msg = append([]byte(strconv.Itoa(len(msg))+" "), msg...)
+	}
+	s.lck.Lock()
+	if s.closed {
+		err = e.New(ErrRemoteSyslogClosed)
+	} else {
+		err = s.conn.write([][]byte{msg})
+	}
+	s.lck.Unlock()
+	if err != nil {
+		HandleError(s, entry, err)
+	}
+}
+
+func (s *RemoteSyslog) Close() error {
+	s.lck.Lock()
+	defer s.lck.Unlock()
+	s.closed = true
+	err := s.conn.close()
+	if err != nil {
+		return e.Forward(err)
+	}
+	return nil
+}
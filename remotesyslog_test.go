@@ -0,0 +1,228 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"bufio"
+	"crypto/tls"
+	"io"
+	"log/syslog"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fcavani/e"
+)
+
+// readOctetCounted reads one message framed by octet counting.
+func readOctetCounted(t *testing.T, r *bufio.Reader) string {
+	size, err := r.ReadString(' ')
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(size))
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	buf := make([]byte, n)
+	_, err = io.ReadFull(r, buf)
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	return string(buf)
+}
+
+func TestRemoteSyslogTCP(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	defer l.Close()
+
+	s, err := NewRemoteSyslog("tcp", l.Addr().String(), RemoteSyslogOptions{
+		Facility: syslog.LOG_LOCAL0,
+		Hostname: "host",
+		AppName:  "my app",
+		ProcID:   "42",
+		MsgID:    "ID1",
+	})
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	defer s.Close()
+	logger := New(s, true).Domain(`a"b]c\d`).Tag("db").With("user", "bob")
+	logger.Error("one")
+	logger.Println("two")
+
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(conn)
+
+	msg := readOctetCounted(t, r)
+	prefix := "<131>1 "
+	if !strings.HasPrefix(msg, prefix) {
+		t.Fatal("wrong header", msg)
+	}
+	parts := strings.SplitN(msg[len(prefix):], " ", 6)
+	if len(parts) != 6 {
+		t.Fatal("wrong message", msg)
+	}
+	date, err := time.Parse(time.RFC3339Nano, parts[0])
+	if err != nil || time.Since(date) > time.Minute {
+		t.Fatal("wrong date", parts[0], err)
+	}
+	if parts[1] != "host" || parts[2] != "my_app" || parts[3] != "42" || parts[4] != "ID1" {
+		t.Fatal("wrong header", msg)
+	}
+	sd := `[log@32473 domain="a\"b\]c\\d" tags="db" file="`
+	if !strings.HasPrefix(parts[5], sd) || !strings.Contains(parts[5], `remotesyslog_test.go:`) ||
+		!strings.Contains(parts[5], ` pkg="github.com/fcavani/log" func="github.com/fcavani/log.TestRemoteSyslogTCP"]`) {
+		t.Fatal("wrong structured data", parts[5])
+	}
+	if !strings.HasSuffix(parts[5], `][fields@32473 user="bob"] `+"\xef\xbb\xbfone") {
+		t.Fatal("wrong message", parts[5])
+	}
+
+	msg = readOctetCounted(t, r)
+	if !strings.HasPrefix(msg, "<133>1 ") || !strings.HasSuffix(msg, "\xef\xbb\xbftwo") {
+		t.Fatalf("wrong message %q", msg)
+	}
+}
+
+func TestRemoteSyslogUDP3164(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	defer conn.Close()
+	s, err := NewRemoteSyslog("udp", conn.LocalAddr().String(), RemoteSyslogOptions{
+		Format:   RFC3164,
+		Hostname: "host",
+		AppName:  "app",
+		ProcID:   "42",
+	})
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	defer s.Close()
+	New(s, false).Domain("test").WarnLevel().Print("one")
+
+	buf := make([]byte, 65536)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	msg := string(buf[:n])
+	if !strings.HasPrefix(msg, "<12>") || !strings.Contains(msg, " host app[42]: [log@32473 domain=\"test\"") ||
+		!strings.HasSuffix(msg, "] one") {
+		t.Fatal("wrong message", msg)
+	}
+	_, err = time.Parse(time.Stamp, msg[4:4+len(time.Stamp)])
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+
+	var failed error
+	s.SetErrorHandler(ErrorHandlerFunc(func(bak LogBackend, entry Entry, err error) {
+		failed = err
+	}))
+	err = s.Close()
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	New(s, false).Print("closed")
+	if !e.Equal(failed, ErrRemoteSyslogClosed) {
+		t.Fatal("wrong error", failed)
+	}
+}
+
+func TestRemoteSyslogTLS(t *testing.T) {
+	cert, pool := testCert(t)
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{cert},
+	})
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	defer l.Close()
+	s, err := NewRemoteSyslog("tcp", l.Addr().String(), RemoteSyslogOptions{
+		TLS: &tls.Config{RootCAs: pool},
+	})
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	defer s.Close()
+	ch := make(chan string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		ch <- readOctetCounted(t, bufio.NewReader(conn))
+	}()
+	New(s, false).Print("secret")
+	select {
+	case msg := <-ch:
+		if !strings.HasSuffix(msg, "secret") {
+			t.Fatal("wrong message", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout")
+	}
+
+	for _, opts := range []RemoteSyslogOptions{
+		{Facility: syslog.LOG_LOCAL7 + 8},
+		{Facility: syslog.LOG_ERR},
+		{SDID: "no enterprise number"},
+		{Format: RFC3164 + 1},
+	} {
+		_, err = NewRemoteSyslog("tcp", "127.0.0.1:1", opts)
+		if err == nil {
+			t.Fatalf("nil error for %+v", opts)
+		}
+	}
+}
+
+func TestRemoteSyslogF(t *testing.T) {
+	l, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	defer l.Close()
+	s, err := NewRemoteSyslog("udp", l.LocalAddr().String(), RemoteSyslogOptions{})
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	defer s.Close()
+	if s.GetF() != nil {
+		t.Fatal("formatter set")
+	}
+	form, err := NewStdFormatter("::", "::domain - ::msg", Log, map[string]interface{}{}, "")
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	if s.F(form).GetF() != form {
+		t.Fatal("formatter not set")
+	}
+	New(s, false).Domain("test").Error("one")
+
+	l.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 2048)
+	n, _, err := l.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	if msg := string(buf[:n]); !strings.HasSuffix(msg, "] \xef\xbb\xbftest - one") {
+		t.Fatalf("wrong message %q", msg)
+	}
+}
@@ -0,0 +1,206 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"sync"
+	"time"
+
+	"github.com/fcavani/e"
+)
+
+// Measurer is implemented by the transactions that know the size of the
+// stored data.
+type Measurer interface {
+	// Size returns the size in bytes of the data stored in key.
+	Size(key string) (int64, error)
+}
+
+// RetentionPolicy determines what entries are removed from the store.
+// Zero values disables the respective limit.
+type RetentionPolicy struct {
+	// MaxAge is the max age of the entries.
+	MaxAge time.Duration
+	// LevelMaxAge overrides MaxAge for the entries of one level.
+	LevelMaxAge map[Level]time.Duration
+	// MaxEntries is the max number of entries in the store.
+	MaxEntries uint
+	// MaxBytes is the max size of the entries in the store. It only works
+	// if the store transaction implements Measurer.
+	MaxBytes int64
+	// Hold selects the entries that are never removed. Entries held don't
+	// count for MaxEntries and MaxBytes.
+	Hold Ruler
+}
+
+func (p *RetentionPolicy) maxAge(l Level) time.Duration {
+	if age, found := p.LevelMaxAge[l]; found {
+		return age
+	}
+	return p.MaxAge
+}
+
+// RetentionReport reports what was removed from the store.
+type RetentionReport struct {
+	// Removed is the number of entries removed.
+	Removed uint
+	// ByAge is the number of entries removed because they are too old.
+	ByAge uint
+	// ByCount is the number of entries removed because of MaxEntries.
+	ByCount uint
+	// ByBytes is the number of entries removed because of MaxBytes.
+	ByBytes uint
+	// Held is the number of entries that would be removed but are held.
+	Held uint
+	// Bytes is the amount of bytes removed, if the store can measure it.
+	Bytes int64
+	// Oldest and Newest are the dates of the oldest and the newest entry
+	// removed.
+	Oldest, Newest time.Time
+}
+
+func (r *RetentionReport) add(date time.Time, size int64) {
+	r.Removed++
+	r.Bytes += size
+	if r.Oldest.IsZero() || date.Before(r.Oldest) {
+		r.Oldest = date
+	}
+	if r.Newest.IsZero() || date.After(r.Newest) {
+		r.Newest = date
+	}
+}
+
+// Prune removes from s the entries that don't fit in the policy p. The
+// entries are visited from the newest to the oldest, so the newest
+// entries are kept.
+func Prune(s Storer, p RetentionPolicy) (report RetentionReport, err error) {
+	p.Hold, err = checkRule(p.Hold)
+	if err != nil {
+		return report, e.Forward(err)
+	}
+	now := time.Now()
+	err = s.Tx(true, func(tx Transaction) error {
+		measurer, _ := tx.(Measurer)
+		var kept uint
+		var bytes int64
+		keys := make([]string, 0)
+		c := tx.Cursor()
+		for k, data := c.Last(); k != ""; k, data = c.Prev() {
+			entry, ok := data.(Entry)
+			if !ok {
+				return e.New(ErrNotEntry)
+			}
+			var size int64
+			if measurer != nil && p.MaxBytes > 0 {
+				var err error
+				size, err = measurer.Size(k)
+				if err != nil {
+					return e.Forward(err)
+				}
+			}
+			age := p.maxAge(entry.Level())
+			var byAge, byCount, byBytes bool
+			switch {
+			case age > 0 && now.Sub(entry.Date()) > age:
+				byAge = true
+			case p.MaxEntries > 0 && kept >= p.MaxEntries:
+				byCount = true
+			case measurer != nil && p.MaxBytes > 0 && bytes+size > p.MaxBytes:
+				byBytes = true
+			}
+			if p.Hold != nil && p.Hold.Result(entry) {
+				if byAge || byCount || byBytes {
+					report.Held++
+				}
+				continue
+			}
+			if !byAge && !byCount && !byBytes {
+				kept++
+				bytes += size
+				continue
+			}
+			if byAge {
+				report.ByAge++
+			} else if byCount {
+				report.ByCount++
+			} else {
+				report.ByBytes++
+			}
+			report.add(entry.Date(), size)
+			keys = append(keys, k)
+		}
+		for _, k := range keys {
+			err := tx.Del(k)
+			if err != nil {
+				return e.Forward(err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return RetentionReport{}, e.Forward(err)
+	}
+	return report, nil
+}
+
+// Retention runs Prune periodically.
+type Retention struct {
+	s       Storer
+	p       RetentionPolicy
+	report  func(r RetentionReport, err error)
+	chclose chan chan struct{}
+	once    sync.Once
+}
+
+// NewRetention starts one goroutine that prunes s every interval. If
+// report isn't nil it is called after each prune, otherwise only the
+// errors are reported.
+func NewRetention(s Storer, p RetentionPolicy, interval time.Duration, report func(r RetentionReport, err error)) (*Retention, error) {
+	if interval <= 0 {
+		return nil, e.New("invalid interval")
+	}
+	r := &Retention{
+		s:       s,
+		p:       p,
+		report:  report,
+		chclose: make(chan chan struct{}),
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				r.Prune()
+			case ch := <-r.chclose:
+				ch <- struct{}{}
+				return
+			}
+		}
+	}()
+	return r, nil
+}
+
+// Prune prunes the store now.
+func (r *Retention) Prune() {
+	report, err := Prune(r.s, r.p)
+	if r.report != nil {
+		r.report(report, err)
+		return
+	}
+	if err != nil {
+		Fail(err)
+	}
+}
+
+// Close stops the retention goroutine. It doesn't close the store.
+func (r *Retention) Close() error {
+	r.once.Do(func() {
+		ch := make(chan struct{})
+		r.chclose <- ch
+		<-ch
+	})
+	return nil
+}
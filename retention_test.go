@@ -0,0 +1,160 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/fcavani/e"
+	"github.com/fcavani/rand"
+	"github.com/fcavani/tags"
+	"github.com/fcavani/types"
+)
+
+func retentionStore(t *testing.T, s Storer, now time.Time) {
+	err := s.Tx(true, func(tx Transaction) error {
+		for i := 0; i < 10; i++ {
+			prio := DebugPrio
+			if i%2 == 0 {
+				prio = ErrorPrio
+			}
+			entry := &log{
+				Timestamp: now.Add(-time.Duration(i) * 24 * time.Hour),
+				Priority:  prio,
+				Labels:    &tags.Tags{},
+				Msg:       strconv.Itoa(i),
+			}
+			err := tx.Put(timeKey(entry.Timestamp), entry)
+			if err != nil {
+				return e.Forward(err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+}
+
+func TestPruneAge(t *testing.T) {
+	m, _ := NewMap(10)
+	retentionStore(t, m, time.Now())
+	report, err := Prune(m, RetentionPolicy{
+		MaxAge: 30 * 24 * time.Hour,
+		LevelMaxAge: map[Level]time.Duration{
+			DebugPrio: 36 * time.Hour,
+		},
+		Hold: Op(Eq, "msg", "9"),
+	})
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	if report.Removed != 3 || report.ByAge != 3 || report.Held != 1 {
+		t.Fatalf("wrong report %+v", report)
+	}
+	r := queryResult(t, m, QuerySpec{})
+	if r != "9864210" {
+		t.Fatal("wrong entries", r)
+	}
+
+	_, err = Prune(m, RetentionPolicy{MaxEntries: 1, Hold: Op(Eq, "msg", 9)})
+	if e.Find(err, ErrFilterNotCompiled) < 0 {
+		t.Fatal("wrong error", err)
+	}
+	if r := queryResult(t, m, QuerySpec{}); r != "9864210" {
+		t.Fatal("wrong entries", r)
+	}
+}
+
+func TestPruneCount(t *testing.T) {
+	m, _ := NewMap(10)
+	retentionStore(t, m, time.Now())
+	report, err := Prune(m, RetentionPolicy{
+		MaxEntries: 2,
+		Hold:       Op(Eq, "level", ErrorPrio),
+	})
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	if report.Removed != 3 || report.ByCount != 3 || report.Held != 3 {
+		t.Fatalf("wrong report %+v", report)
+	}
+	r := queryResult(t, m, QuerySpec{})
+	if r != "8643210" {
+		t.Fatal("wrong entries", r)
+	}
+}
+
+func TestPruneBytes(t *testing.T) {
+	name, err := rand.FileName("boltdb", ".db", 10)
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	name = os.TempDir() + "/" + name
+	defer os.Remove(name)
+	gob := &Gob{
+		TypeName: types.Name(&log{}),
+	}
+	bolt, err := NewBoltDb("test", name, 0600, nil, gob, gob)
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	defer bolt.Close()
+	retentionStore(t, bolt, time.Now())
+
+	var size int64
+	err = bolt.Tx(false, func(tx Transaction) error {
+		c := tx.Cursor()
+		k, _ := c.Last()
+		size, err = tx.(Measurer).Size(k)
+		return err
+	})
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+
+	report, err := Prune(bolt, RetentionPolicy{
+		MaxBytes: 3 * size,
+	})
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	if report.Removed != 7 || report.ByBytes != 7 || report.Bytes != 7*size {
+		t.Fatalf("wrong report %+v", report)
+	}
+	l, err := bolt.Len()
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	if l != 3 {
+		t.Fatal("wrong length", l)
+	}
+}
+
+func TestRetention(t *testing.T) {
+	m, _ := NewMap(10)
+	retentionStore(t, m, time.Now())
+	ch := make(chan RetentionReport, 1)
+	r, err := NewRetention(m, RetentionPolicy{MaxEntries: 5}, 10*time.Millisecond, func(report RetentionReport, err error) {
+		if err != nil {
+			t.Error(e.Trace(e.Forward(err)))
+		}
+		select {
+		case ch <- report:
+		default:
+		}
+	})
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	defer r.Close()
+	report := <-ch
+	if report.Removed != 5 {
+		t.Fatalf("wrong report %+v", report)
+	}
+}
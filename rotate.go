@@ -0,0 +1,333 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fcavani/e"
+)
+
+const (
+	// Hourly rotates the file every hour.
+	Hourly = time.Hour
+	// Daily rotates the file every day at midnight UTC.
+	Daily = 24 * time.Hour
+)
+
+// BackupTimeFormat is the time stamp appended to the name of the rotated
+// files.
+const BackupTimeFormat = "20060102T150405.000000000"
+
+const ErrInvPath = "invalid path"
+
+// RotateOptions controls when the log file will be rotated.
+type RotateOptions struct {
+	// MaxSize is the max size of the file in bytes. Zero disables the
+	// rotation by size.
+	MaxSize int64
+	// Interval rotates the file in the wall clock interval, like Hourly or
+	// Daily. The interval is aligned with UTC. Zero disables the rotation
+	// by time.
+	Interval time.Duration
+	// MaxBackups is the number of rotated files that are keeped. Zero keeps
+	// all files.
+	MaxBackups int
+	// Compress gzip the rotated files in background.
+	Compress bool
+	// Mode is the permissions of the new files. If zero 0600 is used.
+	Mode os.FileMode
+}
+
+type rotator struct {
+	path string
+	opts RotateOptions
+	file *os.File
+	size int64
+	next time.Time
+	now  func() time.Time
+	wg   sync.WaitGroup
+	lck  sync.Mutex
+	// bg serializes the compression and the cleanup.
+	bg sync.Mutex
+}
+
+func (r *rotator) open() error {
+	file, err := os.OpenFile(r.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, r.opts.Mode)
+	if err != nil {
+		return e.New(err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return e.New(err)
+	}
+	r.file = file
+	r.size = info.Size()
+	if r.opts.Interval > 0 {
+		r.next = r.now().Truncate(r.opts.Interval).Add(r.opts.Interval)
+	}
+	return nil
+}
+
+func (r *rotator) needRotate(n int) bool {
+	if r.opts.MaxSize > 0 && r.size > 0 && r.size+int64(n) > r.opts.MaxSize {
+		return true
+	}
+	if r.opts.Interval > 0 && !r.now().Before(r.next) {
+		return true
+	}
+	return false
+}
+
+func (r *rotator) Write(p []byte) (n int, err error) {
+	r.lck.Lock()
+	defer r.lck.Unlock()
+	if r.file == nil {
+		return 0, e.New("file is closed")
+	}
+	if r.needRotate(len(p)) {
+		err = r.rotate()
+		if err != nil && r.file == nil {
+			return 0, e.Forward(err)
+		} else if err != nil {
+			// The rotation failed but the file was reopened, report the
+			// error and don't lose the entry.
+			Fail(err)
+		}
+	}
+	n, err = r.file.Write(p)
+	r.size += int64(n)
+	if err != nil {
+		return n, e.New(err)
+	}
+	return n, nil
+}
+
+func (r *rotator) Rotate() error {
+	r.lck.Lock()
+	defer r.lck.Unlock()
+	if r.file == nil {
+		return e.New("file is closed")
+	}
+	return r.rotate()
+}
+
+// rotate renames the file and opens a new one. If it fails the file in
+// path is reopened, so the logger keeps working.
+func (r *rotator) rotate() (err error) {
+	err = r.file.Close()
+	r.file = nil
+	defer func() {
+		if r.file != nil {
+			return
+		}
+		errOpen := r.open()
+		if errOpen != nil {
+			err = e.Push(err, errOpen)
+		}
+	}()
+	if err != nil {
+		return e.New(err)
+	}
+	backup := r.path + "." + r.now().UTC().Format(BackupTimeFormat)
+	err = os.Rename(r.path, backup)
+	if err != nil {
+		return e.New(err)
+	}
+	err = r.open()
+	if err != nil {
+		return e.Forward(err)
+	}
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		r.bg.Lock()
+		defer r.bg.Unlock()
+		if r.opts.Compress {
+			err := compress(backup)
+			if err != nil {
+				Fail(err)
+			}
+		}
+		err := r.clean()
+		if err != nil {
+			Fail(err)
+		}
+	}()
+	return nil
+}
+
+func compress(name string) error {
+	in, err := os.Open(name)
+	if os.IsNotExist(err) {
+		// Already removed by the cleanup.
+		return nil
+	} else if err != nil {
+		return e.New(err)
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return e.New(err)
+	}
+	out, err := os.OpenFile(name+".gz", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode())
+	if err != nil {
+		return e.New(err)
+	}
+	gz := gzip.NewWriter(out)
+	_, err = io.Copy(gz, in)
+	if err != nil {
+		out.Close()
+		os.Remove(name + ".gz")
+		return e.New(err)
+	}
+	err = gz.Close()
+	if err != nil {
+		out.Close()
+		os.Remove(name + ".gz")
+		return e.New(err)
+	}
+	err = out.Close()
+	if err != nil {
+		os.Remove(name + ".gz")
+		return e.New(err)
+	}
+	err = os.Remove(name)
+	if err != nil {
+		return e.New(err)
+	}
+	return nil
+}
+
+// backups returns the rotated files from the oldest to the newest.
+func (r *rotator) backups() ([]string, error) {
+	dir := filepath.Dir(r.path)
+	prefix := filepath.Base(r.path) + "."
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, e.New(err)
+	}
+	files := make([]string, 0, len(infos))
+	for _, info := range infos {
+		name := info.Name()
+		if info.IsDir() || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		stamp := strings.TrimSuffix(strings.TrimPrefix(name, prefix), ".gz")
+		if _, err := time.Parse(BackupTimeFormat, stamp); err != nil {
+			continue
+		}
+		files = append(files, filepath.Join(dir, name))
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+func (r *rotator) clean() error {
+	if r.opts.MaxBackups <= 0 {
+		return nil
+	}
+	files, err := r.backups()
+	if err != nil {
+		return e.Forward(err)
+	}
+	// The uncompressed and the compressed file may coexist for a while.
+	seen := make(map[string]bool, len(files))
+	for i := len(files) - 1; i >= 0; i-- {
+		stamp := strings.TrimSuffix(files[i], ".gz")
+		if !seen[stamp] && len(seen) >= r.opts.MaxBackups {
+			err = os.Remove(files[i])
+			if err != nil && !os.IsNotExist(err) {
+				return e.New(err)
+			}
+			continue
+		}
+		seen[stamp] = true
+	}
+	return nil
+}
+
+func (r *rotator) Close() error {
+	r.lck.Lock()
+	defer r.lck.Unlock()
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	r.wg.Wait()
+	if err != nil {
+		return e.New(err)
+	}
+	return nil
+}
+
+// RotatingFile is a backend that logs to a file and rotates it by size or
+// by time.
+type RotatingFile struct {
+	*Writer
+	rf *rotator
+}
+
+// NewRotatingFile creates a backend that logs into the file in path.
+func NewRotatingFile(path string, opts RotateOptions) (LogBackend, error) {
+	if path == "" {
+		return nil, e.New(ErrInvPath)
+	}
+	if opts.Mode == 0 {
+		opts.Mode = 0600
+	}
+	rf := &rotator{
+		path: path,
+		opts: opts,
+		now:  time.Now,
+	}
+	err := rf.open()
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	return &RotatingFile{
+		Writer: NewWriter(rf).(*Writer),
+		rf:     rf,
+	}, nil
+}
+
+func (r *RotatingFile) F(f Formatter) LogBackend {
+	r.Writer.F(f)
+	return r
+}
+
+func (r *RotatingFile) Filter(rl Ruler) LogBackend {
+	r.Writer.Filter(rl)
+	return r
+}
+
+// Rotate forces the rotation of the file.
+func (r *RotatingFile) Rotate() error {
+	return e.Forward(r.rf.Rotate())
+}
+
+// Close stops the outer logger, closes the file and waits for the
+// compression of the rotated files.
+func (r *RotatingFile) Close() error {
+	err := r.Writer.Close()
+	if err != nil {
+		return e.Forward(err)
+	}
+	err = r.rf.Close()
+	if err != nil {
+		return e.Forward(err)
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fcavani/e"
+)
+
+func TestRotateBySize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rotate")
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "test.log")
+
+	back, err := NewRotatingFile(name, RotateOptions{
+		MaxSize:    200,
+		MaxBackups: 2,
+		Compress:   true,
+	})
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	logger := New(back.F(DefFormatter), false).Domain("test")
+	for i := 0; i < 20; i++ {
+		logger.Println("rotating file test")
+	}
+	err = back.Close()
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+
+	info, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	if info.Size() > 200 {
+		t.Fatal("file too big", info.Size())
+	}
+	files, err := back.(*RotatingFile).rf.backups()
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	if len(files) != 2 {
+		t.Fatal("wrong number of backups", files)
+	}
+	for _, f := range files {
+		if !strings.HasSuffix(f, ".gz") {
+			t.Fatal("backup not compressed", f)
+		}
+	}
+}
+
+func TestRotateByTime(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rotate")
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "test.log")
+
+	back, err := NewRotatingFile(name, RotateOptions{
+		Interval: Hourly,
+	})
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	rf := back.(*RotatingFile).rf
+	now := time.Now()
+	rf.now = func() time.Time { return now }
+
+	logger := New(back.F(DefFormatter), false).Domain("test")
+	logger.Println("first hour")
+	now = now.Add(time.Hour)
+	logger.Println("second hour")
+	err = back.Close()
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+
+	files, err := rf.backups()
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	if len(files) != 1 {
+		t.Fatal("wrong number of backups", files)
+	}
+	buf, err := ioutil.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	if !strings.Contains(string(buf), "first hour") || strings.Contains(string(buf), "second hour") {
+		t.Fatal("wrong backup content", string(buf))
+	}
+}
+
+func TestRotateRenameFails(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rotate")
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "test.log")
+
+	back, err := NewRotatingFile(name, RotateOptions{})
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	defer back.Close()
+	rf := back.(*RotatingFile).rf
+	now := time.Now()
+	rf.now = func() time.Time { return now }
+	// A directory with the name of the backup makes the rename fail.
+	backup := name + "." + now.UTC().Format(BackupTimeFormat)
+	err = os.MkdirAll(filepath.Join(backup, "dir"), 0700)
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+
+	logger := New(back.F(DefFormatter), false).Domain("test")
+	logger.Println("before")
+	err = back.(*RotatingFile).Rotate()
+	if err == nil {
+		t.Fatal("rotate didn't fail")
+	}
+	logger.Println("after")
+	buf, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	if !strings.Contains(string(buf), "before") || !strings.Contains(string(buf), "after") {
+		t.Fatal("wrong content", string(buf))
+	}
+
+	now = now.Add(time.Second)
+	err = back.(*RotatingFile).Rotate()
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+}
@@ -0,0 +1,515 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"reflect"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/fcavani/e"
+	"github.com/fcavani/types"
+)
+
+const ErrRulerExists = "ruler already registered"
+const ErrRulerNotFound = "ruler not registered"
+const ErrInvRule = "invalid encoded rule"
+
+// The encoded rules are a tree of nodes. The type of the node is the name
+// of the Ruler in the registry, the built in rules are op, and, or, not,
+// if, ifelse, select, true and false. Rulers registered with RegisterRuler
+// are encoded with encoding/json in the field custom. Example:
+//
+//	{"type":"and","rulers":[
+//		{"type":"op","op":"ge","field":"level","value":{"type":"level","value":"warning"}},
+//		{"type":"not","rulers":[{"type":"op","op":"n","field":"field.ok"}]}
+//	]}
+type ruleNode struct {
+	Type  string     `json:"type"`
+	Op    string     `json:"op,omitempty"`
+	Field string     `json:"field,omitempty"`
+	Value *ruleValue `json:"value,omitempty"`
+	// Rulers are the operands of and, or and not. For if and ifelse they are
+	// the condition, the rule and the else rule. For select they are pairs of
+	// condition and rule followed by the default rule.
+	Rulers []*ruleNode     `json:"rulers,omitempty"`
+	Custom json.RawMessage `json:"custom,omitempty"`
+}
+
+// ruleValue is the value of an op encoded as string.
+type ruleValue struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+var opNames = map[Operation]string{
+	Eq:   "eq",
+	Ne:   "ne",
+	Lt:   "lt",
+	Gt:   "gt",
+	Le:   "le",
+	Ge:   "ge",
+	N:    "n",
+	Ex:   "ex",
+	Cnts: "cnts",
+	Re:   "re",
+	Pr:   "pr",
+}
+
+var rulersLck sync.RWMutex
+var rulersByName = make(map[string]reflect.Type)
+var rulersByType = make(map[reflect.Type]string)
+
+// RegisterRuler registers a custom Ruler with name, so it can be encoded
+// with MarshalRuler and with gob. r must be encodable with encoding/json.
+func RegisterRuler(name string, r Ruler) error {
+	rulersLck.Lock()
+	defer rulersLck.Unlock()
+	if name == "" || r == nil {
+		return e.New("invalid ruler")
+	}
+	if builtinRule(name) {
+		return e.New(ErrRulerExists)
+	}
+	if _, found := rulersByName[name]; found {
+		return e.New(ErrRulerExists)
+	}
+	t := reflect.TypeOf(r)
+	if _, found := rulersByType[t]; found {
+		return e.New(ErrRulerExists)
+	}
+	rulersByName[name] = t
+	rulersByType[t] = name
+	return nil
+}
+
+// UnregisterRuler removes the custom Ruler from the registry.
+func UnregisterRuler(name string) {
+	rulersLck.Lock()
+	defer rulersLck.Unlock()
+	t, found := rulersByName[name]
+	if !found {
+		return
+	}
+	delete(rulersByName, name)
+	delete(rulersByType, t)
+}
+
+func builtinRule(name string) bool {
+	switch name {
+	case "op", "and", "or", "not", "if", "ifelse", "select", "true", "false":
+		return true
+	}
+	return false
+}
+
+// MarshalRuler encodes the rule in json.
+func MarshalRuler(r Ruler) ([]byte, error) {
+	n, err := ruleToNode(r)
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	b, err := json.Marshal(n)
+	if err != nil {
+		return nil, e.New(err)
+	}
+	return b, nil
+}
+
+// UnmarshalRuler decodes a rule encoded with MarshalRuler.
+func UnmarshalRuler(b []byte) (Ruler, error) {
+	n := new(ruleNode)
+	err := json.Unmarshal(b, n)
+	if err != nil {
+		return nil, e.Push(e.New(ErrInvRule), err)
+	}
+	r, err := nodeToRule(n)
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	return r, nil
+}
+
+func rulesToNodes(rulers ...Ruler) ([]*ruleNode, error) {
+	nodes := make([]*ruleNode, 0, len(rulers))
+	for _, r := range rulers {
+		n, err := ruleToNode(r)
+		if err != nil {
+			return nil, e.Forward(err)
+		}
+		nodes = append(nodes, n)
+	}
+	return nodes, nil
+}
+
+func ruleToNode(r Ruler) (*ruleNode, error) {
+	var err error
+	n := new(ruleNode)
+	switch v := r.(type) {
+	case compiled:
+		return ruleToNode(v.source())
+	case True, *True:
+		n.Type = "true"
+	case False, *False:
+		n.Type = "false"
+	case *op:
+		n.Type = "op"
+		name, found := opNames[v.op]
+		if !found {
+			return nil, e.New("invalid operation %v", v.op)
+		}
+		n.Op = name
+		n.Field = v.field
+		n.Value, err = encodeRuleValue(v.vright)
+		if err != nil {
+			return nil, e.Forward(err)
+		}
+	case *and:
+		n.Type = "and"
+		n.Rulers, err = rulesToNodes(v.rulers...)
+	case *or:
+		n.Type = "or"
+		n.Rulers, err = rulesToNodes(v.rulers...)
+	case *not:
+		n.Type = "not"
+		n.Rulers, err = rulesToNodes(v.Ruler)
+	case *apply:
+		n.Type = "if"
+		n.Rulers, err = rulesToNodes(v.condition, v.rule)
+	case *applyelse:
+		n.Type = "ifelse"
+		n.Rulers, err = rulesToNodes(v.condition, v.rule, v.el)
+	case *sel:
+		n.Type = "select"
+		rulers := make([]Ruler, 0, 2*len(v.Ifs)+1)
+		for _, cond := range v.Ifs {
+			rulers = append(rulers, cond.Condition, cond.Than)
+		}
+		rulers = append(rulers, v.Default)
+		n.Rulers, err = rulesToNodes(rulers...)
+	default:
+		rulersLck.RLock()
+		name, found := rulersByType[reflect.TypeOf(r)]
+		rulersLck.RUnlock()
+		if !found {
+			return nil, e.Push(e.New(ErrRulerNotFound), e.New("type %T", r))
+		}
+		n.Type = name
+		n.Custom, err = json.Marshal(r)
+		if err != nil {
+			return nil, e.New(err)
+		}
+	}
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	return n, nil
+}
+
+func nodesToRules(nodes []*ruleNode) ([]Ruler, error) {
+	rulers := make([]Ruler, 0, len(nodes))
+	for _, n := range nodes {
+		r, err := nodeToRule(n)
+		if err != nil {
+			return nil, e.Forward(err)
+		}
+		rulers = append(rulers, r)
+	}
+	return rulers, nil
+}
+
+func nodeToRule(n *ruleNode) (Ruler, error) {
+	if n == nil {
+		return nil, e.Push(e.New(ErrInvRule), e.New("nil rule"))
+	}
+	rulers, err := nodesToRules(n.Rulers)
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	switch n.Type {
+	case "true":
+		return True{}, nil
+	case "false":
+		return False{}, nil
+	case "op":
+		for o, name := range opNames {
+			if name != n.Op {
+				continue
+			}
+			if n.Value == nil {
+				return Op(o, n.Field), nil
+			}
+			val, err := decodeRuleValue(n.Value)
+			if err != nil {
+				return nil, e.Forward(err)
+			}
+			return Op(o, n.Field, val), nil
+		}
+		return nil, e.Push(e.New(ErrInvRule), e.New("invalid operation %v", n.Op))
+	case "and":
+		return And(rulers...), nil
+	case "or":
+		return Or(rulers...), nil
+	case "not":
+		if len(rulers) != 1 {
+			return nil, e.Push(e.New(ErrInvRule), e.New("not needs one rule"))
+		}
+		return Not(rulers[0]), nil
+	case "if":
+		if len(rulers) != 2 {
+			return nil, e.Push(e.New(ErrInvRule), e.New("if needs two rules"))
+		}
+		return ApplyRuleIf(rulers[0], rulers[1]), nil
+	case "ifelse":
+		if len(rulers) != 3 {
+			return nil, e.Push(e.New(ErrInvRule), e.New("ifelse needs three rules"))
+		}
+		return ApplyRuleIfElse(rulers[0], rulers[1], rulers[2]), nil
+	case "select":
+		if len(rulers)%2 != 1 {
+			return nil, e.Push(e.New(ErrInvRule), e.New("select needs pairs of rules and the default rule"))
+		}
+		ifs := make([]*If, 0, len(rulers)/2)
+		for i := 0; i < len(rulers)-1; i += 2 {
+			ifs = append(ifs, &If{
+				Condition: rulers[i],
+				Than:      rulers[i+1],
+			})
+		}
+		return Select(ifs, rulers[len(rulers)-1]), nil
+	}
+	rulersLck.RLock()
+	t, found := rulersByName[n.Type]
+	rulersLck.RUnlock()
+	if !found {
+		return nil, e.Push(e.New(ErrRulerNotFound), e.New("name %v", n.Type))
+	}
+	ptr := t.Kind() == reflect.Ptr
+	if ptr {
+		t = t.Elem()
+	}
+	val := reflect.New(t)
+	if len(n.Custom) > 0 {
+		err = json.Unmarshal(n.Custom, val.Interface())
+		if err != nil {
+			return nil, e.Push(e.New(ErrInvRule), err)
+		}
+	}
+	if !ptr {
+		val = val.Elem()
+	}
+	return val.Interface().(Ruler), nil
+}
+
+func encodeRuleValue(v reflect.Value) (*ruleValue, error) {
+	if !v.IsValid() {
+		return nil, nil
+	}
+	switch val := v.Interface().(type) {
+	case Level:
+		if val > NoPrio {
+			return nil, e.New("invalid level %v", uint8(val))
+		}
+		return &ruleValue{"level", val.String()}, nil
+	case time.Time:
+		return &ruleValue{"time", val.Format(time.RFC3339Nano)}, nil
+	case regexp.Regexp:
+		return &ruleValue{"regexp", val.String()}, nil
+	}
+	if v.Type().PkgPath() != "" {
+		return nil, e.New("can't encode the value of type %v", v.Type())
+	}
+	kind := v.Kind().String()
+	switch v.Kind() {
+	case reflect.String:
+		return &ruleValue{kind, v.String()}, nil
+	case reflect.Bool:
+		return &ruleValue{kind, strconv.FormatBool(v.Bool())}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &ruleValue{kind, strconv.FormatInt(v.Int(), 10)}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &ruleValue{kind, strconv.FormatUint(v.Uint(), 10)}, nil
+	case reflect.Float32, reflect.Float64:
+		return &ruleValue{kind, strconv.FormatFloat(v.Float(), 'g', -1, v.Type().Bits())}, nil
+	}
+	return nil, e.New("can't encode the value of type %v", v.Type())
+}
+
+var kindTypes = map[string]reflect.Type{
+	"int":     reflect.TypeOf(int(0)),
+	"int8":    reflect.TypeOf(int8(0)),
+	"int16":   reflect.TypeOf(int16(0)),
+	"int32":   reflect.TypeOf(int32(0)),
+	"int64":   reflect.TypeOf(int64(0)),
+	"uint":    reflect.TypeOf(uint(0)),
+	"uint8":   reflect.TypeOf(uint8(0)),
+	"uint16":  reflect.TypeOf(uint16(0)),
+	"uint32":  reflect.TypeOf(uint32(0)),
+	"uint64":  reflect.TypeOf(uint64(0)),
+	"float32": reflect.TypeOf(float32(0)),
+	"float64": reflect.TypeOf(float64(0)),
+}
+
+func decodeRuleValue(rv *ruleValue) (val interface{}, err error) {
+	switch rv.Type {
+	case "level":
+		val, err = ParseLevel(rv.Value)
+	case "time":
+		val, err = time.Parse(time.RFC3339Nano, rv.Value)
+	case "regexp":
+		val, err = regexp.Compile(rv.Value)
+	case "string":
+		val = rv.Value
+	case "bool":
+		val, err = strconv.ParseBool(rv.Value)
+	default:
+		t, found := kindTypes[rv.Type]
+		if !found {
+			return nil, e.Push(e.New(ErrInvRule), e.New("invalid value type %v", rv.Type))
+		}
+		v := reflect.New(t).Elem()
+		switch t.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			var i int64
+			i, err = strconv.ParseInt(rv.Value, 10, t.Bits())
+			v.SetInt(i)
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			var u uint64
+			u, err = strconv.ParseUint(rv.Value, 10, t.Bits())
+			v.SetUint(u)
+		default:
+			var f float64
+			f, err = strconv.ParseFloat(rv.Value, t.Bits())
+			v.SetFloat(f)
+		}
+		val = v.Interface()
+	}
+	if err != nil {
+		return nil, e.Push(e.New(ErrInvRule), err)
+	}
+	return val, nil
+}
+
+// decodeRule decodes the node tree in dst, that must be a pointer to the
+// same type of the decoded rule.
+func decodeRule(n *ruleNode, dst Ruler) error {
+	r, err := nodeToRule(n)
+	if err != nil {
+		return e.Forward(err)
+	}
+	vr := reflect.ValueOf(r)
+	vdst := reflect.ValueOf(dst)
+	if vr.Type() != vdst.Type() {
+		return e.Push(e.New(ErrInvRule), e.New("can't decode %v in %v", n.Type, vdst.Type()))
+	}
+	vdst.Elem().Set(vr.Elem())
+	return nil
+}
+
+func unmarshalRule(b []byte, dst Ruler) error {
+	n := new(ruleNode)
+	err := json.Unmarshal(b, n)
+	if err != nil {
+		return e.Push(e.New(ErrInvRule), err)
+	}
+	return decodeRule(n, dst)
+}
+
+func gobEncodeRule(r Ruler) ([]byte, error) {
+	n, err := ruleToNode(r)
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	buf := bytes.NewBuffer([]byte{})
+	err = gob.NewEncoder(buf).Encode(n)
+	if err != nil {
+		return nil, e.New(err)
+	}
+	return buf.Bytes(), nil
+}
+
+func gobDecodeRule(b []byte, dst Ruler) error {
+	n := new(ruleNode)
+	err := gob.NewDecoder(bytes.NewReader(b)).Decode(n)
+	if err != nil {
+		return e.Push(e.New(ErrInvRule), err)
+	}
+	return decodeRule(n, dst)
+}
+
+func (o *op) MarshalJSON() ([]byte, error)        { return MarshalRuler(o) }
+func (o *op) UnmarshalJSON(b []byte) error        { return unmarshalRule(b, o) }
+func (o *op) GobEncode() ([]byte, error)          { return gobEncodeRule(o) }
+func (o *op) GobDecode(b []byte) error            { return gobDecodeRule(b, o) }
+func (a *and) MarshalJSON() ([]byte, error)       { return MarshalRuler(a) }
+func (a *and) UnmarshalJSON(b []byte) error       { return unmarshalRule(b, a) }
+func (a *and) GobEncode() ([]byte, error)         { return gobEncodeRule(a) }
+func (a *and) GobDecode(b []byte) error           { return gobDecodeRule(b, a) }
+func (o *or) MarshalJSON() ([]byte, error)        { return MarshalRuler(o) }
+func (o *or) UnmarshalJSON(b []byte) error        { return unmarshalRule(b, o) }
+func (o *or) GobEncode() ([]byte, error)          { return gobEncodeRule(o) }
+func (o *or) GobDecode(b []byte) error            { return gobDecodeRule(b, o) }
+func (n *not) MarshalJSON() ([]byte, error)       { return MarshalRuler(n) }
+func (n *not) UnmarshalJSON(b []byte) error       { return unmarshalRule(b, n) }
+func (n *not) GobEncode() ([]byte, error)         { return gobEncodeRule(n) }
+func (n *not) GobDecode(b []byte) error           { return gobDecodeRule(b, n) }
+func (a *apply) MarshalJSON() ([]byte, error)     { return MarshalRuler(a) }
+func (a *apply) UnmarshalJSON(b []byte) error     { return unmarshalRule(b, a) }
+func (a *apply) GobEncode() ([]byte, error)       { return gobEncodeRule(a) }
+func (a *apply) GobDecode(b []byte) error         { return gobDecodeRule(b, a) }
+func (a *applyelse) MarshalJSON() ([]byte, error) { return MarshalRuler(a) }
+func (a *applyelse) UnmarshalJSON(b []byte) error { return unmarshalRule(b, a) }
+func (a *applyelse) GobEncode() ([]byte, error)   { return gobEncodeRule(a) }
+func (a *applyelse) GobDecode(b []byte) error     { return gobDecodeRule(b, a) }
+func (s *sel) MarshalJSON() ([]byte, error)       { return MarshalRuler(s) }
+func (s *sel) UnmarshalJSON(b []byte) error       { return unmarshalRule(b, s) }
+func (s *sel) GobEncode() ([]byte, error)         { return gobEncodeRule(s) }
+func (s *sel) GobDecode(b []byte) error           { return gobDecodeRule(b, s) }
+
+func (t True) MarshalJSON() ([]byte, error)  { return MarshalRuler(t) }
+func (f False) MarshalJSON() ([]byte, error) { return MarshalRuler(f) }
+
+// UnmarshalJSON decodes the rules of If.
+func (i *If) UnmarshalJSON(b []byte) error {
+	var raw struct {
+		Condition json.RawMessage
+		Than      json.RawMessage
+	}
+	err := json.Unmarshal(b, &raw)
+	if err != nil {
+		return e.Push(e.New(ErrInvRule), err)
+	}
+	i.Condition, err = UnmarshalRuler(raw.Condition)
+	if err != nil {
+		return e.Forward(err)
+	}
+	i.Than, err = UnmarshalRuler(raw.Than)
+	if err != nil {
+		return e.Forward(err)
+	}
+	return nil
+}
+
+func init() {
+	types.Insert(&op{})
+	types.Insert(&and{})
+	types.Insert(&or{})
+	types.Insert(&not{})
+	types.Insert(&apply{})
+	types.Insert(&applyelse{})
+	types.Insert(&sel{})
+	gob.Register(&op{})
+	gob.Register(&and{})
+	gob.Register(&or{})
+	gob.Register(&not{})
+	gob.Register(&apply{})
+	gob.Register(&applyelse{})
+	gob.Register(&sel{})
+}
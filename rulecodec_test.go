@@ -0,0 +1,191 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fcavani/e"
+)
+
+type msgLen struct {
+	Max int `json:"max"`
+}
+
+func (m msgLen) Result(entry Entry) bool {
+	return len(entry.Message()) <= m.Max
+}
+
+func (m msgLen) String() string {
+	return "msglen"
+}
+
+func codecRules() []Ruler {
+	return []Ruler{
+		True{},
+		False{},
+		Op(Ge, "level", WarnPrio),
+		Op(Eq, "date", time.Date(2015, 6, 1, 12, 0, 0, 0, time.UTC)),
+		Op(Re, "msg", regexp.MustCompile(`time\w+`)),
+		Op(N, "field.ok"),
+		Op(Eq, "field.tries", int8(-3)),
+		Op(Eq, "uint", uint16(3)),
+		Op(Lt, "float", float32(1.5)),
+		Op(Eq, "bool", true),
+		And(Op(Ex, "tags", "db"), Or(Op(Pr, "domain", "api"), Not(Op(Cnts, "msg", "x")))),
+		ApplyRuleIf(Op(Eq, "domain", "api"), Op(Eq, "level", DebugPrio)),
+		ApplyRuleIfElse(True{}, False{}, Op(Ne, "msg", "")),
+		Select([]*If{
+			{Condition: Op(Eq, "level", DebugPrio), Than: False{}},
+			{Condition: Op(Ex, "tags", "db"), Than: Op(Re, "msg", "conn")},
+		}, True{}),
+	}
+}
+
+func TestRulerJSON(t *testing.T) {
+	for i, r := range codecRules() {
+		b, err := json.Marshal(r)
+		if err != nil {
+			t.Fatal(i, e.Trace(e.Forward(err)))
+		}
+		r2, err := UnmarshalRuler(b)
+		if err != nil {
+			t.Fatal(i, e.Trace(e.Forward(err)))
+		}
+		b2, err := MarshalRuler(r2)
+		if err != nil {
+			t.Fatal(i, e.Trace(e.Forward(err)))
+		}
+		if !bytes.Equal(b, b2) {
+			t.Fatalf("%v: round trip fail %s %s", i, b, b2)
+		}
+		s1, _ := FormatRule(r)
+		s2, _ := FormatRule(r2)
+		if s1 != s2 {
+			t.Fatalf("%v: wrong rule %v %v", i, s1, s2)
+		}
+	}
+
+	and := And()
+	err := json.Unmarshal([]byte(`{"type":"and","rulers":[{"type":"true"},{"type":"op","op":"eq","field":"msg","value":{"type":"string","value":"a"}}]}`), and)
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	s, _ := FormatRule(and)
+	if s != "true && msg == a" {
+		t.Fatal("wrong rule", s)
+	}
+	err = json.Unmarshal([]byte(`{"type":"or"}`), and)
+	if err == nil {
+		t.Fatal("nil error")
+	}
+
+	var cond If
+	err = json.Unmarshal([]byte(`{"Condition":{"type":"true"},"Than":{"type":"false"}}`), &cond)
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	if cond.Condition != (True{}) || cond.Than != (False{}) {
+		t.Fatal("wrong if", cond)
+	}
+
+	invalid := []string{
+		`{"type":"op","op":"xx","field":"msg"}`,
+		`{"type":"op","op":"eq","field":"msg","value":{"type":"complex","value":"1"}}`,
+		`{"type":"op","op":"eq","field":"level","value":{"type":"level","value":"loud"}}`,
+		`{"type":"not"}`,
+		`{"type":"select","rulers":[{"type":"true"}, {"type":"true"}]}`,
+		`{"type":"nothing"}`,
+		`[]`,
+	}
+	for i, s := range invalid {
+		_, err = UnmarshalRuler([]byte(s))
+		if err == nil {
+			t.Fatal(i, "nil error")
+		}
+	}
+}
+
+func TestRulerGob(t *testing.T) {
+	type config struct {
+		Name  string
+		Rules []Ruler
+	}
+	in := config{Name: "test", Rules: codecRules()}
+	buf := bytes.NewBuffer([]byte{})
+	err := gob.NewEncoder(buf).Encode(&in)
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	var out config
+	err = gob.NewDecoder(buf).Decode(&out)
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	if out.Name != in.Name || len(out.Rules) != len(in.Rules) {
+		t.Fatal("wrong config", out)
+	}
+	for i := range in.Rules {
+		s1, _ := FormatRule(in.Rules[i])
+		s2, _ := FormatRule(out.Rules[i])
+		if s1 != s2 {
+			t.Fatalf("%v: wrong rule %v %v", i, s1, s2)
+		}
+	}
+}
+
+func TestRegisterRuler(t *testing.T) {
+	r := And(Op(Eq, "level", ErrorPrio), msgLen{Max: 10})
+	_, err := MarshalRuler(r)
+	if err != nil && e.Find(err, ErrRulerNotFound) < 0 {
+		t.Fatal(e.Trace(e.Forward(err)))
+	} else if err == nil {
+		t.Fatal("nil error")
+	}
+
+	err = RegisterRuler("msglen", msgLen{})
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	defer UnregisterRuler("msglen")
+	err = RegisterRuler("msglen", &msgLen{})
+	if err != nil && !e.Equal(err, ErrRulerExists) {
+		t.Fatal(e.Trace(e.Forward(err)))
+	} else if err == nil {
+		t.Fatal("nil error")
+	}
+	err = RegisterRuler("and", &msgLen{})
+	if err != nil && !e.Equal(err, ErrRulerExists) {
+		t.Fatal(e.Trace(e.Forward(err)))
+	} else if err == nil {
+		t.Fatal("nil error")
+	}
+
+	b, err := MarshalRuler(r)
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	if !strings.Contains(string(b), `"custom":{"max":10}`) {
+		t.Fatalf("wrong encoding %s", b)
+	}
+	r2, err := UnmarshalRuler(b)
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	entry := &log{Priority: ErrorPrio, Msg: "short"}
+	if !r2.Result(entry) {
+		t.Fatal("wrong result")
+	}
+	entry.Msg = "a long message"
+	if r2.Result(entry) {
+		t.Fatal("wrong result")
+	}
+}
@@ -0,0 +1,747 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/fcavani/e"
+)
+
+// The rule language is a text representation of the rules. Example:
+//
+//	level >= warning && (tags has db || domain ^= "api") && msg ~ /timeout/
+//
+// The operators are:
+//
+//	==        Eq
+//	!=        Ne
+//	<         Lt
+//	>         Gt
+//	<=        Le
+//	>=        Ge
+//	!field    N
+//	has       Ex
+//	contains  Cnts
+//	~         Re, the value is a /regexp/ or a string
+//	^=        Pr
+//
+// Rules are combined with &&, || and !(rule). true and false are the
+// rules True and False. ApplyRuleIf, ApplyRuleIfElse and Select are
+// written as:
+//
+//	if condition then rule
+//	if condition then rule else other
+//	select { condition => rule, condition => rule, default => rule }
+//
+// Values are strings between double quotes, identifiers, numbers, true,
+// false and regexps between slashes. The values of the field level are
+// the names of the levels and the values of the field date are strings
+// in the RFC3339 format.
+
+// SyntaxError is returned when the rule can't be parsed.
+type SyntaxError struct {
+	// Pos is the offset in bytes of the error.
+	Pos int
+	// Line and Col are the position of the error, starting in one.
+	Line, Col int
+	// Msg describes the error.
+	Msg string
+}
+
+func (s *SyntaxError) Error() string {
+	return fmt.Sprintf("rule:%v:%v: %v", s.Line, s.Col, s.Msg)
+}
+
+type tokenKind uint8
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokRegexp
+	tokSymbol
+)
+
+type token struct {
+	kind tokenKind
+	val  string
+	pos  int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of rule"
+	case tokString:
+		return strconv.Quote(t.val)
+	case tokRegexp:
+		return "/" + t.val + "/"
+	}
+	return "'" + t.val + "'"
+}
+
+var symbols = []string{"==", "!=", "<=", ">=", "^=", "&&", "||", "=>", "<", ">", "~", "!", "(", ")", "{", "}", ","}
+
+func isIdentRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '.'
+}
+
+type parser struct {
+	src  string
+	toks []token
+	i    int
+}
+
+func (p *parser) errorf(pos int, format string, a ...interface{}) error {
+	line := 1 + strings.Count(p.src[:pos], "\n")
+	col := pos + 1
+	if i := strings.LastIndex(p.src[:pos], "\n"); i > -1 {
+		col = pos - i
+	}
+	return &SyntaxError{
+		Pos:  pos,
+		Line: line,
+		Col:  col,
+		Msg:  fmt.Sprintf(format, a...),
+	}
+}
+
+func (p *parser) lex() error {
+	s := p.src
+	i := 0
+	for i < len(s) {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case unicode.IsSpace(r):
+			i += size
+		case r == '"':
+			j := i + 1
+			for ; j < len(s) && s[j] != '"'; j++ {
+				if s[j] == '\\' {
+					j++
+				}
+			}
+			if j >= len(s) {
+				return p.errorf(i, "unterminated string")
+			}
+			str, err := strconv.Unquote(s[i : j+1])
+			if err != nil {
+				return p.errorf(i, "invalid string: %v", err)
+			}
+			p.toks = append(p.toks, token{tokString, str, i})
+			i = j + 1
+		case r == '/':
+			var buf bytes.Buffer
+			j := i + 1
+			for ; j < len(s) && s[j] != '/'; j++ {
+				if s[j] == '\\' && j+1 < len(s) && s[j+1] == '/' {
+					j++
+				}
+				buf.WriteByte(s[j])
+			}
+			if j >= len(s) {
+				return p.errorf(i, "unterminated regexp")
+			}
+			p.toks = append(p.toks, token{tokRegexp, buf.String(), i})
+			i = j + 1
+		case unicode.IsDigit(r) || ((r == '-' || r == '+') && i+1 < len(s) && s[i+1] >= '0' && s[i+1] <= '9'):
+			j := i + 1
+			for j < len(s) && (s[j] >= '0' && s[j] <= '9' || s[j] == '.' || s[j] == 'e' || s[j] == 'E' ||
+				((s[j] == '-' || s[j] == '+') && (s[j-1] == 'e' || s[j-1] == 'E'))) {
+				j++
+			}
+			p.toks = append(p.toks, token{tokNumber, s[i:j], i})
+			i = j
+		case isIdentRune(r):
+			j := i
+			for j < len(s) {
+				r, size := utf8.DecodeRuneInString(s[j:])
+				if !isIdentRune(r) {
+					break
+				}
+				j += size
+			}
+			p.toks = append(p.toks, token{tokIdent, s[i:j], i})
+			i = j
+		default:
+			found := false
+			for _, sym := range symbols {
+				if strings.HasPrefix(s[i:], sym) {
+					p.toks = append(p.toks, token{tokSymbol, sym, i})
+					i += len(sym)
+					found = true
+					break
+				}
+			}
+			if !found {
+				return p.errorf(i, "invalid character %q", r)
+			}
+		}
+	}
+	p.toks = append(p.toks, token{tokEOF, "", len(s)})
+	return nil
+}
+
+func (p *parser) peek() token {
+	return p.toks[p.i]
+}
+
+func (p *parser) peekAt(n int) token {
+	if p.i+n >= len(p.toks) {
+		return p.toks[len(p.toks)-1]
+	}
+	return p.toks[p.i+n]
+}
+
+func (p *parser) next() token {
+	t := p.toks[p.i]
+	if t.kind != tokEOF {
+		p.i++
+	}
+	return t
+}
+
+func (p *parser) is(kind tokenKind, val string) bool {
+	t := p.peek()
+	return t.kind == kind && t.val == val
+}
+
+func (p *parser) expect(kind tokenKind, val string) error {
+	t := p.next()
+	if t.kind != kind || t.val != val {
+		return p.errorf(t.pos, "expected '%v' found %v", val, t)
+	}
+	return nil
+}
+
+var keywords = map[string]bool{
+	"if":       true,
+	"then":     true,
+	"else":     true,
+	"select":   true,
+	"default":  true,
+	"true":     true,
+	"false":    true,
+	"has":      true,
+	"contains": true,
+}
+
+var ruleOps = map[string]Operation{
+	"==":       Eq,
+	"!=":       Ne,
+	"<":        Lt,
+	">":        Gt,
+	"<=":       Le,
+	">=":       Ge,
+	"has":      Ex,
+	"contains": Cnts,
+	"~":        Re,
+	"^=":       Pr,
+}
+
+func isOperator(t token) bool {
+	if t.kind != tokSymbol && t.kind != tokIdent {
+		return false
+	}
+	_, found := ruleOps[t.val]
+	return found
+}
+
+// ParseRule compiles the text s into a Ruler.
+func ParseRule(s string) (Ruler, error) {
+	p := &parser{src: s}
+	err := p.lex()
+	if err != nil {
+		return nil, err
+	}
+	r, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, p.errorf(t.pos, "unexpected %v", t)
+	}
+	return r, nil
+}
+
+// MustParseRule is like ParseRule but panics if the rule is invalid.
+func MustParseRule(s string) Ruler {
+	r, err := ParseRule(s)
+	if err != nil {
+		panic("logger: " + err.Error())
+	}
+	return r
+}
+
+func (p *parser) or() (Ruler, error) {
+	r, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	rulers := []Ruler{r}
+	for p.is(tokSymbol, "||") {
+		p.next()
+		r, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		rulers = append(rulers, r)
+	}
+	if len(rulers) == 1 {
+		return rulers[0], nil
+	}
+	return Or(rulers...), nil
+}
+
+func (p *parser) and() (Ruler, error) {
+	r, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	rulers := []Ruler{r}
+	for p.is(tokSymbol, "&&") {
+		p.next()
+		r, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		rulers = append(rulers, r)
+	}
+	if len(rulers) == 1 {
+		return rulers[0], nil
+	}
+	return And(rulers...), nil
+}
+
+func (p *parser) unary() (Ruler, error) {
+	if !p.is(tokSymbol, "!") {
+		return p.primary()
+	}
+	p.next()
+	t := p.peek()
+	if t.kind == tokIdent && !keywords[t.val] && !isOperator(p.peekAt(1)) {
+		p.next()
+		return Op(N, t.val), nil
+	}
+	r, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	return Not(r), nil
+}
+
+func (p *parser) primary() (Ruler, error) {
+	t := p.peek()
+	switch {
+	case t.kind == tokSymbol && t.val == "(":
+		p.next()
+		r, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		err = p.expect(tokSymbol, ")")
+		if err != nil {
+			return nil, err
+		}
+		return r, nil
+	case t.kind == tokIdent && t.val == "true":
+		p.next()
+		return True{}, nil
+	case t.kind == tokIdent && t.val == "false":
+		p.next()
+		return False{}, nil
+	case t.kind == tokIdent && t.val == "if":
+		return p.ifRule()
+	case t.kind == tokIdent && t.val == "select":
+		return p.selectRule()
+	case t.kind == tokIdent && !keywords[t.val]:
+		return p.comparison()
+	}
+	return nil, p.errorf(t.pos, "unexpected %v", t)
+}
+
+func (p *parser) ifRule() (Ruler, error) {
+	p.next()
+	cond, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	err = p.expect(tokIdent, "then")
+	if err != nil {
+		return nil, err
+	}
+	rule, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if !p.is(tokIdent, "else") {
+		return ApplyRuleIf(cond, rule), nil
+	}
+	p.next()
+	el, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	return ApplyRuleIfElse(cond, rule, el), nil
+}
+
+func (p *parser) selectRule() (Ruler, error) {
+	p.next()
+	err := p.expect(tokSymbol, "{")
+	if err != nil {
+		return nil, err
+	}
+	ifs := make([]*If, 0)
+	for {
+		if p.is(tokIdent, "default") {
+			p.next()
+			err = p.expect(tokSymbol, "=>")
+			if err != nil {
+				return nil, err
+			}
+			def, err := p.or()
+			if err != nil {
+				return nil, err
+			}
+			if p.is(tokSymbol, ",") {
+				p.next()
+			}
+			err = p.expect(tokSymbol, "}")
+			if err != nil {
+				return nil, err
+			}
+			return Select(ifs, def), nil
+		}
+		cond, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		err = p.expect(tokSymbol, "=>")
+		if err != nil {
+			return nil, err
+		}
+		rule, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		ifs = append(ifs, &If{
+			Condition: cond,
+			Than:      rule,
+		})
+		err = p.expect(tokSymbol, ",")
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *parser) comparison() (Ruler, error) {
+	field := p.next()
+	t := p.next()
+	if !isOperator(t) {
+		return nil, p.errorf(t.pos, "expected operator after %v found %v", field.val, t)
+	}
+	o := ruleOps[t.val]
+	val, err := p.value(field.val)
+	if err != nil {
+		return nil, err
+	}
+	if o == Ex || o == Cnts || o == Pr {
+		if _, ok := val.(string); !ok {
+			return nil, p.errorf(t.pos, "operator %v needs a string", t.val)
+		}
+	}
+	return Op(o, field.val, val), nil
+}
+
+func (p *parser) value(field string) (interface{}, error) {
+	t := p.next()
+	switch t.kind {
+	case tokString:
+		switch field {
+		case "level":
+			l, err := ParseLevel(t.val)
+			if err != nil {
+				return nil, p.errorf(t.pos, "invalid level %v", t)
+			}
+			return l, nil
+		case "date":
+			date, err := time.Parse(time.RFC3339Nano, t.val)
+			if err != nil {
+				return nil, p.errorf(t.pos, "invalid date %v", t)
+			}
+			return date, nil
+		}
+		return t.val, nil
+	case tokIdent:
+		if keywords[t.val] && t.val != "true" && t.val != "false" {
+			return nil, p.errorf(t.pos, "unexpected %v", t)
+		}
+		if field == "level" {
+			l, err := ParseLevel(t.val)
+			if err != nil {
+				return nil, p.errorf(t.pos, "invalid level %v", t)
+			}
+			return l, nil
+		}
+		switch t.val {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		}
+		return t.val, nil
+	case tokNumber:
+		if i, err := strconv.ParseInt(t.val, 10, 64); err == nil {
+			if field == "level" {
+				if i < int64(ProtoPrio) || i > int64(NoPrio) {
+					return nil, p.errorf(t.pos, "invalid level %v", t)
+				}
+				return Level(i), nil
+			}
+			return int(i), nil
+		}
+		f, err := strconv.ParseFloat(t.val, 64)
+		if err != nil {
+			return nil, p.errorf(t.pos, "invalid number %v", t)
+		}
+		return f, nil
+	case tokRegexp:
+		re, err := regexp.Compile(t.val)
+		if err != nil {
+			return nil, p.errorf(t.pos, "invalid regexp: %v", err)
+		}
+		return re, nil
+	}
+	return nil, p.errorf(t.pos, "expected value found %v", t)
+}
+
+// Precedence of the rules when printed.
+const (
+	precIf = iota
+	precOr
+	precAnd
+	precUnary
+)
+
+// FormatRule prints the rule in the rule language. Rules that aren't
+// built in must implement fmt.Stringer.
+func FormatRule(r Ruler) (string, error) {
+	buf := bytes.NewBuffer([]byte{})
+	err := formatRule(buf, r, precIf)
+	if err != nil {
+		return "", e.Forward(err)
+	}
+	return buf.String(), nil
+}
+
+func formatChild(buf *bytes.Buffer, r Ruler, prec, min int) error {
+	if prec < min {
+		buf.WriteByte('(')
+	}
+	err := formatRule(buf, r, prec)
+	if err != nil {
+		return e.Forward(err)
+	}
+	if prec < min {
+		buf.WriteByte(')')
+	}
+	return nil
+}
+
+func rulePrec(r Ruler) int {
+	switch v := r.(type) {
+	case *or:
+		if len(v.rulers) > 1 {
+			return precOr
+		}
+		if len(v.rulers) == 1 {
+			return rulePrec(v.rulers[0])
+		}
+	case *and:
+		if len(v.rulers) > 1 {
+			return precAnd
+		}
+		if len(v.rulers) == 1 {
+			return rulePrec(v.rulers[0])
+		}
+	case *apply, *applyelse:
+		return precIf
+	}
+	return precUnary
+}
+
+func formatList(buf *bytes.Buffer, rulers []Ruler, sep string, min int, empty Ruler) error {
+	if len(rulers) == 0 {
+		return formatRule(buf, empty, precUnary)
+	}
+	for i, r := range rulers {
+		if i > 0 {
+			buf.WriteString(sep)
+		}
+		err := formatChild(buf, r, rulePrec(r), min)
+		if err != nil {
+			return e.Forward(err)
+		}
+	}
+	return nil
+}
+
+func formatRule(buf *bytes.Buffer, r Ruler, prec int) error {
+	switch v := r.(type) {
+	case compiled:
+		return formatRule(buf, v.source(), prec)
+	case True, *True:
+		buf.WriteString("true")
+	case False, *False:
+		buf.WriteString("false")
+	case *op:
+		return formatOp(buf, v)
+	case *and:
+		// And of nothing is true.
+		return formatList(buf, v.rulers, " && ", precAnd, True{})
+	case *or:
+		// Or of nothing is false.
+		return formatList(buf, v.rulers, " || ", precAnd, False{})
+	case *not:
+		buf.WriteString("!(")
+		err := formatRule(buf, v.Ruler, precIf)
+		if err != nil {
+			return e.Forward(err)
+		}
+		buf.WriteString(")")
+	case *apply:
+		buf.WriteString("if ")
+		err := formatChild(buf, v.condition, rulePrec(v.condition), precOr)
+		if err != nil {
+			return e.Forward(err)
+		}
+		buf.WriteString(" then ")
+		return formatChild(buf, v.rule, rulePrec(v.rule), precOr)
+	case *applyelse:
+		buf.WriteString("if ")
+		err := formatChild(buf, v.condition, rulePrec(v.condition), precOr)
+		if err != nil {
+			return e.Forward(err)
+		}
+		buf.WriteString(" then ")
+		err = formatChild(buf, v.rule, rulePrec(v.rule), precOr)
+		if err != nil {
+			return e.Forward(err)
+		}
+		buf.WriteString(" else ")
+		return formatChild(buf, v.el, rulePrec(v.el), precOr)
+	case *sel:
+		buf.WriteString("select { ")
+		for _, cond := range v.Ifs {
+			err := formatChild(buf, cond.Condition, rulePrec(cond.Condition), precOr)
+			if err != nil {
+				return e.Forward(err)
+			}
+			buf.WriteString(" => ")
+			err = formatChild(buf, cond.Than, rulePrec(cond.Than), precOr)
+			if err != nil {
+				return e.Forward(err)
+			}
+			buf.WriteString(", ")
+		}
+		buf.WriteString("default => ")
+		err := formatChild(buf, v.Default, rulePrec(v.Default), precOr)
+		if err != nil {
+			return e.Forward(err)
+		}
+		buf.WriteString(" }")
+	case fmt.Stringer:
+		buf.WriteString(v.String())
+	default:
+		return e.New("can't format the rule of type %T", r)
+	}
+	return nil
+}
+
+var opSymbols = map[Operation]string{
+	Eq:   "==",
+	Ne:   "!=",
+	Lt:   "<",
+	Gt:   ">",
+	Le:   "<=",
+	Ge:   ">=",
+	Ex:   "has",
+	Cnts: "contains",
+	Re:   "~",
+	Pr:   "^=",
+}
+
+func formatIdent(s string) string {
+	if s == "" || keywords[s] {
+		return strconv.Quote(s)
+	}
+	for i, r := range s {
+		if !isIdentRune(r) || (i == 0 && unicode.IsDigit(r)) {
+			return strconv.Quote(s)
+		}
+	}
+	return s
+}
+
+func formatOp(buf *bytes.Buffer, o *op) error {
+	if o.op == N {
+		buf.WriteString("!" + o.field)
+		return nil
+	}
+	sym, found := opSymbols[o.op]
+	if !found {
+		return e.New("invalid operation %v", o.op)
+	}
+	buf.WriteString(o.field + " " + sym + " ")
+	if !o.vright.IsValid() {
+		return e.New("operation without value")
+	}
+	switch v := o.vright.Interface().(type) {
+	case Level:
+		if v > NoPrio {
+			return e.New("invalid level %v", uint8(v))
+		}
+		buf.WriteString(formatIdent(v.String()))
+	case time.Time:
+		buf.WriteString(strconv.Quote(v.Format(time.RFC3339Nano)))
+	case regexp.Regexp:
+		buf.WriteString("/" + strings.Replace(v.String(), "/", `\/`, -1) + "/")
+	case string:
+		if o.field == "level" || o.field == "date" {
+			buf.WriteString(strconv.Quote(v))
+		} else {
+			buf.WriteString(formatIdent(v))
+		}
+	case bool:
+		buf.WriteString(strconv.FormatBool(v))
+	default:
+		switch o.vright.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			buf.WriteString(strconv.FormatInt(o.vright.Int(), 10))
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			buf.WriteString(strconv.FormatUint(o.vright.Uint(), 10))
+		case reflect.Float32, reflect.Float64:
+			s := strconv.FormatFloat(o.vright.Float(), 'g', -1, 64)
+			if !strings.ContainsAny(s, ".eEN") {
+				s += ".0"
+			}
+			buf.WriteString(s)
+		default:
+			return e.New("can't format the value of type %v", o.vright.Type())
+		}
+	}
+	return nil
+}
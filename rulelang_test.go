@@ -0,0 +1,168 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fcavani/e"
+	"github.com/fcavani/tags"
+)
+
+func ruleEntry(t *testing.T, level Level, tg, domain, msg string) Entry {
+	lbs, err := tags.NewTags(tg)
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	return &log{
+		Timestamp: time.Date(2015, 6, 1, 12, 0, 0, 0, time.UTC),
+		Priority:  level,
+		Labels:    lbs,
+		Msg:       msg,
+		Dom:       domain,
+		Flds:      FieldMap{"user": "felipe", "tries": 3, "ok": false},
+	}
+}
+
+type ruleTest struct {
+	rule   string
+	format string
+	result bool
+}
+
+var ruleTests = []ruleTest{
+	{`level >= warning && (tags has db || domain ^= "api") && msg ~ /timeout/`, `level >= warning && (tags has db || domain ^= api) && msg ~ /timeout/`, true},
+	{`level == error`, `level == error`, true},
+	{`level < error`, `level < error`, false},
+	{`level <= 4`, `level <= error`, true},
+	{`level > "no priority"`, `level > "no priority"`, false},
+	{`domain != "api/users"`, `domain != "api/users"`, true},
+	{`date < "2016-01-01T00:00:00Z"`, `date < "2016-01-01T00:00:00Z"`, true},
+	{`msg contains "time"`, `msg contains time`, true},
+	{`msg ~ "^conn.*out$"`, `msg ~ "^conn.*out$"`, true},
+	{`msg ~ /a\/b/`, `msg ~ /a\/b/`, false},
+	{`field.tries > 2 && field.user == felipe`, `field.tries > 2 && field.user == felipe`, true},
+	{`!field.ok`, `!field.ok`, true},
+	{`!(field.tries == 3)`, `!(field.tries == 3)`, false},
+	{`! ! (true)`, `!(!(true))`, true},
+	{`field.missing has x || false`, `field.missing has x || false`, false},
+	{`fields has user`, `fields has user`, true},
+	{`if domain == api then level == debug`, `if domain == api then level == debug`, true},
+	{`if domain ^= api then level == debug else msg contains out`, `if domain ^= api then level == debug else msg contains out`, true},
+	{`(if true then false) && true`, `(if true then false) && true`, false},
+	{`select { level == debug => false, tags has db => msg ~ /conn/, default => false }`, `select { level == debug => false, tags has db => msg ~ /conn/, default => false }`, true},
+	{`select { default => true }`, `select { default => true }`, true},
+	{`a == 1 || b == 2 && c == 1.5 || d == -2`, `a == 1 || b == 2 && c == 1.5 || d == -2`, false},
+}
+
+func TestParseRule(t *testing.T) {
+	entry := ruleEntry(t, ErrorPrio, "db,sql", "db", "connection timeout")
+	for i, test := range ruleTests {
+		if test.rule == `a == 1 || b == 2 && c == 1.5 || d == -2` {
+			// Only formatting, the fields don't exist.
+			continue
+		}
+		r, err := ParseRule(test.rule)
+		if err != nil {
+			t.Fatal(i, test.rule, e.Trace(e.Forward(err)))
+		}
+		if res := r.Result(entry); res != test.result {
+			t.Fatal(i, test.rule, "wrong result", res)
+		}
+	}
+}
+
+func TestFormatRule(t *testing.T) {
+	for i, test := range ruleTests {
+		r, err := ParseRule(test.rule)
+		if err != nil {
+			t.Fatal(i, e.Trace(e.Forward(err)))
+		}
+		s, err := FormatRule(r)
+		if err != nil {
+			t.Fatal(i, e.Trace(e.Forward(err)))
+		}
+		if s != test.format {
+			t.Fatalf("%v: wrong format %q", i, s)
+		}
+		r, err = ParseRule(s)
+		if err != nil {
+			t.Fatal(i, e.Trace(e.Forward(err)))
+		}
+		s2, err := FormatRule(r)
+		if err != nil {
+			t.Fatal(i, e.Trace(e.Forward(err)))
+		}
+		if s != s2 {
+			t.Fatalf("%v: round trip fail %q", i, s2)
+		}
+	}
+	rules := []Ruler{
+		And(),
+		Or(),
+		Or(And(Op(Eq, "a", 1), Op(Eq, "b", 2)), Not(Op(N, "c"))),
+		And(Or(Op(Eq, "a", uint(1)), Op(Eq, "b", 2.0)), ApplyRuleIf(True{}, False{})),
+		ApplyRuleIfElse(ApplyRuleIf(True{}, False{}), Op(Eq, "x", "if"), Op(Eq, "level", NoPrio)),
+	}
+	formats := []string{
+		`true`,
+		`false`,
+		`a == 1 && b == 2 || !(!c)`,
+		`(a == 1 || b == 2.0) && (if true then false)`,
+		`if (if true then false) then x == "if" else level == "no priority"`,
+	}
+	for i, r := range rules {
+		s, err := FormatRule(r)
+		if err != nil {
+			t.Fatal(i, e.Trace(e.Forward(err)))
+		}
+		if s != formats[i] {
+			t.Fatalf("%v: wrong format %q", i, s)
+		}
+		_, err = ParseRule(s)
+		if err != nil {
+			t.Fatal(i, e.Trace(e.Forward(err)))
+		}
+	}
+	_, err := FormatRule(Op(Eq, "a", struct{}{}))
+	if err == nil {
+		t.Fatal("nil error")
+	}
+}
+
+func TestParseRuleErrors(t *testing.T) {
+	tests := []struct {
+		rule      string
+		line, col int
+	}{
+		{``, 1, 1},
+		{`level >= `, 1, 10},
+		{`level`, 1, 6},
+		{`level >= foo`, 1, 10},
+		{`(msg == a`, 1, 10},
+		{`msg == a)`, 1, 9},
+		{"msg == a &&\n  domain ~ /(/", 2, 12},
+		{`msg == "abc`, 1, 8},
+		{`msg $ a`, 1, 5},
+		{`tags has 1`, 1, 6},
+		{`if a == 1 else b == 2`, 1, 11},
+		{`select { a == 1 => true }`, 1, 25},
+		{`date == "yesterday"`, 1, 9},
+	}
+	for i, test := range tests {
+		_, err := ParseRule(test.rule)
+		if err == nil {
+			t.Fatal(i, "nil error")
+		}
+		serr, ok := err.(*SyntaxError)
+		if !ok {
+			t.Fatal(i, "wrong error type", err)
+		}
+		if serr.Line != test.line || serr.Col != test.col {
+			t.Fatal(i, "wrong position", serr)
+		}
+	}
+}
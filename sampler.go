@@ -0,0 +1,228 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/fcavani/e"
+)
+
+// SamplerOptions configures the Sampler.
+type SamplerOptions struct {
+	// Interval is the duration of the sampling window of each key. Zero is
+	// one second.
+	Interval time.Duration
+	// First is the number of entries with the same key committed in each
+	// interval.
+	First int
+	// Thereafter, after the First entries, one in every Thereafter entries
+	// is committed. Zero drops all entries after the First.
+	Thereafter int
+	// Key returns the key of the entry. If nil SampleKey is used.
+	Key func(entry Entry) string
+	// NoSummary disables the summary entries.
+	NoSummary bool
+}
+
+// SampleKey is the default key of the Sampler. It is the domain, the level
+// and the message with the numbers replaced by #, so messages created
+// from the same template have the same key.
+func SampleKey(entry Entry) string {
+	buf := bytes.NewBufferString(entry.GetDomain())
+	buf.WriteByte(0)
+	buf.WriteString(entry.Level().String())
+	buf.WriteByte(0)
+	digit := false
+	for _, r := range entry.Message() {
+		if unicode.IsDigit(r) {
+			if !digit {
+				buf.WriteByte('#')
+			}
+			digit = true
+			continue
+		}
+		digit = false
+		buf.WriteRune(r)
+	}
+	return buf.String()
+}
+
+type sampleState struct {
+	start   time.Time
+	count   int
+	dropped int
+	entry   Entry
+}
+
+// Sampler commits to the backend only a sample of the entries with the
+// same key. The dropped entries are reported in one summary entry, like
+// "message repeated 4123 times in 10s", in the end of each interval.
+type Sampler struct {
+	bak  LogBackend
+	opts SamplerOptions
+	r    ruleHolder
+	handlerHolder
+
+	lck     sync.Mutex
+	states  map[string]*sampleState
+	now     func() time.Time
+	chclose chan chan struct{}
+	once    sync.Once
+}
+
+// NewSampler creates a Sampler that commits to bak.
+func NewSampler(bak LogBackend, opts SamplerOptions) (*Sampler, error) {
+	if bak == nil {
+		return nil, e.New("invalid backend")
+	}
+	if opts.Interval < 0 || opts.First < 0 || opts.Thereafter < 0 {
+		return nil, e.New("invalid options")
+	}
+	if opts.Interval == 0 {
+		opts.Interval = time.Second
+	}
+	if opts.Key == nil {
+		opts.Key = SampleKey
+	}
+	s := &Sampler{
+		bak:     bak,
+		opts:    opts,
+		states:  make(map[string]*sampleState),
+		now:     time.Now,
+		chclose: make(chan chan struct{}),
+	}
+	go func() {
+		ticker := time.NewTicker(opts.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.expire(false)
+			case ch := <-s.chclose:
+				ch <- struct{}{}
+				return
+			}
+		}
+	}()
+	return s, nil
+}
+
+func (s *Sampler) F(f Formatter) LogBackend {
+	s.bak.F(f)
+	return s
+}
+
+func (s *Sampler) GetF() Formatter {
+	return s.bak.GetF()
+}
+
+func (s *Sampler) Filter(r Ruler) LogBackend {
+	s.r.Set(precompile(r))
+	return s
+}
+
+func (s *Sampler) GetFilter() Ruler {
+	return s.r.Get()
+}
+
+func (s *Sampler) Backends() []LogBackend {
+	return []LogBackend{s.bak}
+}
+
+func (s *Sampler) Commit(entry Entry) {
+	if !s.r.Pass(entry) {
+		samplerMetrics.filter()
+		return
+	}
+	start := time.Now()
+	key := s.opts.Key(entry)
+	now := s.now()
+	var expired *sampleState
+	s.lck.Lock()
+	st, found := s.states[key]
+	if found && now.Sub(st.start) >= s.opts.Interval {
+		expired = st
+		found = false
+	}
+	if !found {
+		st = &sampleState{start: now}
+		s.states[key] = st
+	}
+	st.count++
+	commit := st.count <= s.opts.First
+	if !commit && s.opts.Thereafter > 0 {
+		commit = (st.count-s.opts.First)%s.opts.Thereafter == 0
+	}
+	if !commit {
+		st.dropped++
+		st.entry = entry
+	}
+	s.lck.Unlock()
+	if expired != nil {
+		s.summary(expired)
+	}
+	if commit {
+		s.bak.Commit(entry)
+	} else {
+		samplerMetrics.drop()
+	}
+	samplerMetrics.observe(start, commit)
+}
+
+// expire removes the keys with expired intervals and commits the
+// summaries. If all is true all keys are removed.
+func (s *Sampler) expire(all bool) {
+	now := s.now()
+	expired := make([]*sampleState, 0)
+	s.lck.Lock()
+	for key, st := range s.states {
+		if all || now.Sub(st.start) >= s.opts.Interval {
+			delete(s.states, key)
+			expired = append(expired, st)
+		}
+	}
+	s.lck.Unlock()
+	for _, st := range expired {
+		s.summary(st)
+	}
+}
+
+func (s *Sampler) summary(st *sampleState) {
+	if s.opts.NoSummary || st.dropped == 0 {
+		return
+	}
+	msg := fmt.Sprintf("message repeated %v times in %v: %v", st.dropped, s.opts.Interval, strings.TrimSpace(st.entry.Message()))
+	var logger Logger
+	if l, ok := st.entry.(*log); ok {
+		logger = l.SetStore(s.bak)
+	} else if f := s.bak.GetF(); f != nil {
+		logger = f.NewEntry(s.bak).EntryLevel(st.entry.Level()).Domain(st.entry.GetDomain())
+	} else {
+		return
+	}
+	logger.With("repeated", st.dropped).Tag("sampler").Println(msg)
+}
+
+// Close commits the pending summaries and closes the backend.
+func (s *Sampler) Close() error {
+	var err error
+	s.once.Do(func() {
+		ch := make(chan struct{})
+		s.chclose <- ch
+		<-ch
+		s.expire(true)
+		err = s.bak.Close()
+	})
+	if err != nil {
+		return e.Forward(err)
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fcavani/e"
+)
+
+func TestSampleKey(t *testing.T) {
+	l := New(nil, false).Domain("db").(*log)
+	l.Msg = "connection 10.0.0.1:5432 failed after 3 tries"
+	l.Priority = ErrorPrio
+	k1 := SampleKey(l)
+	l.Msg = "connection 10.0.0.2:5432 failed after 12 tries"
+	if k2 := SampleKey(l); k1 != k2 {
+		t.Fatal("keys are different", k1, k2)
+	}
+	l.Priority = WarnPrio
+	if k2 := SampleKey(l); k1 == k2 {
+		t.Fatal("keys are equal")
+	}
+}
+
+func TestSampler(t *testing.T) {
+	buf := bytes.NewBuffer([]byte{})
+	form, err := NewStdFormatter("::", "::level - ::tags - ::msg", Log, map[string]interface{}{}, "")
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	s, err := NewSampler(NewWriter(buf), SamplerOptions{
+		Interval:   10 * time.Second,
+		First:      2,
+		Thereafter: 3,
+	})
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	now := time.Now()
+	s.lck.Lock()
+	s.now = func() time.Time { return now }
+	s.lck.Unlock()
+	if s.F(form).GetF() != form {
+		t.Fatal("formatter not set")
+	}
+	logger := New(s, false).SetLevel("all", InfoPrio)
+
+	for i := 0; i < 10; i++ {
+		logger.Errorf("request %v failed", i)
+		logger.DebugLevel().Println("filtered")
+	}
+	logger.Println("other")
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 5 {
+		t.Fatalf("wrong number of lines %q", lines)
+	}
+	for i, n := range []string{"0", "1", "4", "7"} {
+		if lines[i] != "error - no tags - request "+n+" failed" {
+			t.Fatalf("wrong line %q", lines[i])
+		}
+	}
+	if s.GetFilter() == nil || s.Backends()[0] == nil {
+		t.Fatal("wrong backend")
+	}
+
+	buf.Reset()
+	now = now.Add(10 * time.Second)
+	logger.Error("request 10 failed")
+	lines = strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 ||
+		lines[0] != "error - sampler - message repeated 6 times in 10s: request 9 failed" ||
+		lines[1] != "error - no tags - request 10 failed" {
+		t.Fatalf("wrong lines %q", lines)
+	}
+
+	buf.Reset()
+	for i := 0; i < 3; i++ {
+		logger.Error("request failed")
+	}
+	err = s.Close()
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	err = s.Close()
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	lines = strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 || lines[2] != "error - sampler - message repeated 1 times in 10s: request failed" {
+		t.Fatalf("wrong lines %q", lines)
+	}
+}
+
+func TestSamplerExpire(t *testing.T) {
+	buf := bytes.NewBuffer([]byte{})
+	var lck sync.Mutex
+	s, err := NewSampler(NewWriter(&lockedWriter{w: buf, lck: &lck}).F(DefFormatter), SamplerOptions{
+		Interval: time.Hour,
+		First:    1,
+		Key: func(entry Entry) string {
+			return entry.GetDomain()
+		},
+	})
+	if err != nil {
+		t.Fatal(e.Trace(e.Forward(err)))
+	}
+	defer s.Close()
+	now := time.Now()
+	s.lck.Lock()
+	s.now = func() time.Time { return now }
+	s.lck.Unlock()
+	logger := New(s, false).Domain("test")
+	for i := 0; i < 5; i++ {
+		logger.Println("msg", i)
+	}
+	// The same sweep of the ticker, with the clock after the interval.
+	s.expire(false)
+	s.lck.Lock()
+	n := len(s.states)
+	s.lck.Unlock()
+	if n != 1 {
+		t.Fatal("key removed before the interval", n)
+	}
+	s.lck.Lock()
+	s.now = func() time.Time { return now.Add(time.Hour) }
+	s.lck.Unlock()
+	s.expire(false)
+	lck.Lock()
+	n = strings.Count(buf.String(), "\n")
+	lck.Unlock()
+	if n != 2 {
+		t.Fatal("wrong number of entries", n)
+	}
+	s.lck.Lock()
+	n = len(s.states)
+	s.lck.Unlock()
+	if n != 0 {
+		t.Fatal("keys not removed", n)
+	}
+}
@@ -7,7 +7,9 @@ package log
 import (
 	"bytes"
 	"encoding/gob"
+	"encoding/json"
 	"os"
+	"reflect"
 	"time"
 
 	"github.com/boltdb/bolt"
@@ -66,6 +68,37 @@ func (g *Gob) Decode(b []byte) (interface{}, error) {
 	return val.Interface(), nil
 }
 
+// JSON encodes with encoding/json. TypeName is the type of the values
+// decoded, registered with types.Insert.
+type JSON struct {
+	TypeName string
+}
+
+func (j *JSON) Encode(i interface{}) ([]byte, error) {
+	buf, err := json.Marshal(i)
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	return buf, nil
+}
+
+func (j *JSON) Decode(b []byte) (interface{}, error) {
+	t := types.Type(j.TypeName)
+	if t == nil {
+		return nil, e.New("type %v not registered", j.TypeName)
+	}
+	val := types.Make(t)
+	ptr := val
+	if val.Kind() != reflect.Ptr {
+		ptr = val.Addr()
+	}
+	err := json.Unmarshal(b, ptr.Interface())
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	return val.Interface(), nil
+}
+
 type BoltDb struct {
 	db      *bolt.DB
 	bucket  string
@@ -148,6 +181,15 @@ func (t *txBoltDb) Del(key string) error {
 	return nil
 }
 
+// Size returns the size of the encoded data.
+func (t *txBoltDb) Size(key string) (int64, error) {
+	buf := t.b.Get([]byte(key))
+	if buf == nil {
+		return 0, e.New(ErrKeyNotFound)
+	}
+	return int64(len(buf)), nil
+}
+
 type cursorBoltDb struct {
 	c   *bolt.Cursor
 	b   *bolt.Bucket
@@ -280,11 +322,13 @@ func (db *BoltDb) Tx(write bool, f func(tx Transaction) error) error {
 	if write {
 		trans.b, err = tx.CreateBucketIfNotExists([]byte(db.bucket))
 		if err != nil {
+			tx.Rollback()
 			return e.New(err)
 		}
 	} else {
 		trans.b = tx.Bucket([]byte(db.bucket))
 		if trans.b == nil {
+			tx.Rollback()
 			return e.New("error creating transaction")
 		}
 	}
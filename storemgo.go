@@ -7,6 +7,7 @@ package log
 import (
 	"net/url"
 	"reflect"
+	"regexp"
 	"strings"
 	"time"
 
@@ -304,3 +305,229 @@ func (m *MongoDb) Close() error {
 	m.session.Close()
 	return nil
 }
+
+func bsonName(f reflect.StructField) string {
+	name := strings.Split(f.Tag.Get("bson"), ",")[0]
+	if name == "-" {
+		return ""
+	}
+	if name != "" {
+		return name
+	}
+	return strings.ToLower(f.Name)
+}
+
+// bsonField finds the name of the document field for the field with the
+// log tag logtag.
+func bsonField(t reflect.Type, logtag string) (string, bool) {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return "", false
+	}
+	if strings.HasPrefix(logtag, FieldPrefix) {
+		name, ok := bsonField(t, "fields")
+		if !ok {
+			return "", false
+		}
+		return name + "." + logtag[len(FieldPrefix):], true
+	}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Tag.Get("log") != logtag {
+			continue
+		}
+		name := bsonName(f)
+		return name, name != ""
+	}
+	return "", false
+}
+
+// rulerToBson translate the ruler into a mongodb query. If the ruler can't
+// be translated ok is false.
+func rulerToBson(r Ruler, t reflect.Type) (q bson.M, ok bool) {
+	switch v := r.(type) {
+	case compiled:
+		return rulerToBson(v.source(), t)
+	case True:
+		return bson.M{}, true
+	case *op:
+		name, ok := bsonField(t, v.field)
+		if !ok || !v.vright.IsValid() {
+			return nil, false
+		}
+		val := v.vright.Interface()
+		switch v.op {
+		case Eq:
+			return bson.M{name: val}, true
+		case Ne:
+			return bson.M{name: bson.M{"$ne": val}}, true
+		case Lt:
+			return bson.M{name: bson.M{"$lt": val}}, true
+		case Gt:
+			return bson.M{name: bson.M{"$gt": val}}, true
+		case Le:
+			return bson.M{name: bson.M{"$lte": val}}, true
+		case Ge:
+			return bson.M{name: bson.M{"$gte": val}}, true
+		case Ex:
+			if v.field == "fields" {
+				s, ok := val.(string)
+				if !ok {
+					return nil, false
+				}
+				return bson.M{name + "." + s: bson.M{"$exists": true}}, true
+			}
+			return bson.M{name: val}, true
+		case Cnts, Pr, Re:
+			var pattern string
+			switch p := val.(type) {
+			case string:
+				pattern = p
+			case regexp.Regexp:
+				pattern = p.String()
+			default:
+				return nil, false
+			}
+			if v.op == Cnts {
+				pattern = regexp.QuoteMeta(pattern)
+			} else if v.op == Pr {
+				pattern = "^" + regexp.QuoteMeta(pattern)
+			}
+			return bson.M{name: bson.RegEx{Pattern: pattern}}, true
+		}
+		return nil, false
+	case *and:
+		return rulersToBson("$and", v.rulers, t)
+	case *or:
+		return rulersToBson("$or", v.rulers, t)
+	case *not:
+		q, ok := rulerToBson(v.Ruler, t)
+		if !ok {
+			return nil, false
+		}
+		return bson.M{"$nor": []bson.M{q}}, true
+	}
+	return nil, false
+}
+
+func rulersToBson(operator string, rulers []Ruler, t reflect.Type) (bson.M, bool) {
+	qs := make([]bson.M, 0, len(rulers))
+	for _, r := range rulers {
+		q, ok := rulerToBson(r, t)
+		if !ok {
+			return nil, false
+		}
+		qs = append(qs, q)
+	}
+	if len(qs) == 0 {
+		return bson.M{}, true
+	}
+	return bson.M{operator: qs}, true
+}
+
+type iterMongoDb struct {
+	iter   *mgo.Iter
+	tentry reflect.Type
+	filter Ruler
+	skip   int
+	limit  int
+	n      int
+	entry  Entry
+	err    error
+}
+
+func (i *iterMongoDb) Next() bool {
+	if i.err != nil || (i.limit > 0 && i.n >= i.limit) {
+		return false
+	}
+	for {
+		inter := types.Make(i.tentry).Interface()
+		if !i.iter.Next(inter) {
+			i.err = i.iter.Err()
+			return false
+		}
+		entry, ok := inter.(Entry)
+		if !ok {
+			i.err = e.New(ErrNotEntry)
+			return false
+		}
+		if i.filter != nil && !i.filter.Result(entry) {
+			continue
+		}
+		if i.skip > 0 {
+			i.skip--
+			continue
+		}
+		i.n++
+		i.entry = entry
+		return true
+	}
+}
+
+func (i *iterMongoDb) Entry() Entry {
+	return i.entry
+}
+
+func (i *iterMongoDb) Err() error {
+	if i.err != nil {
+		return e.New(i.err)
+	}
+	return nil
+}
+
+func (i *iterMongoDb) Close() error {
+	err := i.iter.Close()
+	if err != nil {
+		return e.New(err)
+	}
+	return nil
+}
+
+// Query runs the query in the mongodb server. The filter is translated to a
+// mongodb query, if it isn't possible the filter runs in the client.
+func (m *MongoDb) Query(q QuerySpec) (Iterator, error) {
+	if q.Limit < 0 || q.Offset < 0 {
+		return nil, e.New("invalid limit or offset")
+	}
+	date, ok := bsonField(m.tentry, "date")
+	if !ok {
+		return nil, e.New("entry don't have a date field")
+	}
+	query := bson.M{}
+	rng := bson.M{}
+	if !q.Start.IsZero() {
+		rng["$gte"] = q.Start
+	}
+	if !q.End.IsZero() {
+		rng["$lt"] = q.End
+	}
+	if len(rng) > 0 {
+		query[date] = rng
+	}
+	it := &iterMongoDb{
+		tentry: m.tentry,
+	}
+	if q.Filter != nil {
+		filter, ok := rulerToBson(q.Filter, m.tentry)
+		if ok {
+			query = bson.M{"$and": []bson.M{query, filter}}
+		} else {
+			it.filter = q.Filter
+		}
+	}
+	sort := date
+	if q.Dir == RightToLeft {
+		sort = "-" + date
+	}
+	mq := m.c.Find(query).Sort(sort)
+	if it.filter == nil {
+		mq = mq.Skip(q.Offset).Limit(q.Limit)
+	} else {
+		it.skip = q.Offset
+		it.limit = q.Limit
+	}
+	it.iter = mq.Iter()
+	return it, nil
+}
@@ -21,6 +21,10 @@ type TestStruct struct {
 	I   int
 }
 
+func (et *TestStruct) ID() string {
+	return ""
+}
+
 func (et *TestStruct) Date() time.Time {
 	return time.Time{}
 }
@@ -77,6 +81,18 @@ func (et *TestStruct) DebugInfo() Logger {
 	return nil
 }
 
+func (et *TestStruct) With(key string, val interface{}) Logger {
+	return nil
+}
+
+func (et *TestStruct) Fields(m map[string]interface{}) Logger {
+	return nil
+}
+
+func (et *TestStruct) GetFields() map[string]interface{} {
+	return nil
+}
+
 func init() {
 	types.Insert(&TestStruct{})
 }
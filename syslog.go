@@ -6,12 +6,14 @@ package log
 
 import (
 	"log/syslog"
+	"time"
 )
 
 // Syslog sends all messages to syslog.
 type Syslog struct {
 	w *syslog.Writer
-	r Ruler
+	r ruleHolder
+	handlerHolder
 }
 
 func NewSyslog(w *syslog.Writer) LogBackend {
@@ -31,59 +33,66 @@ func (s *Syslog) GetF() Formatter {
 }
 
 func (s *Syslog) Filter(r Ruler) LogBackend {
-	s.r = r
+	s.r.Set(r)
 	return s
 }
 
+func (s *Syslog) GetFilter() Ruler {
+	return s.r.Get()
+}
+
 func (s *Syslog) Commit(entry Entry) {
-	if s.r != nil && !s.r.Result(entry) {
+	if !s.r.Pass(entry) {
+		syslogMetrics.filter()
 		return
 	}
+	var err error
+	defer syslogMetrics.commit(time.Now(), &err)
 	switch entry.Level() {
 	case ProtoPrio:
-		err := s.w.Debug(entry.Message())
+		err = s.w.Debug(entry.Message())
 		if err != nil {
-			CommitFail(entry, err)
+			HandleError(s, entry, err)
 		}
 	case DebugPrio:
-		err := s.w.Debug(entry.Message())
+		err = s.w.Debug(entry.Message())
 		if err != nil {
-			CommitFail(entry, err)
+			HandleError(s, entry, err)
 		}
 	case InfoPrio:
-		err := s.w.Info(entry.Message())
+		err = s.w.Info(entry.Message())
 		if err != nil {
-			CommitFail(entry, err)
+			HandleError(s, entry, err)
 		}
 	case WarnPrio:
-		err := s.w.Warning(entry.Message())
+		err = s.w.Warning(entry.Message())
 		if err != nil {
-			CommitFail(entry, err)
+			HandleError(s, entry, err)
 		}
 	case ErrorPrio:
-		err := s.w.Err(entry.Message())
+		err = s.w.Err(entry.Message())
 		if err != nil {
-			CommitFail(entry, err)
+			HandleError(s, entry, err)
 		}
 	case FatalPrio:
-		err := s.w.Crit(entry.Message())
+		err = s.w.Crit(entry.Message())
 		if err != nil {
-			CommitFail(entry, err)
+			HandleError(s, entry, err)
 		}
 	case PanicPrio:
-		err := s.w.Emerg(entry.Message())
+		err = s.w.Emerg(entry.Message())
 		if err != nil {
-			CommitFail(entry, err)
+			HandleError(s, entry, err)
 		}
 	case NoPrio:
-		err := s.w.Notice(entry.Message())
+		err = s.w.Notice(entry.Message())
 		if err != nil {
-			CommitFail(entry, err)
+			HandleError(s, entry, err)
 		}
 	default:
-		err := s.w.Notice(entry.Message())
+		err = s.w.Notice(entry.Message())
 		if err != nil {
-			CommitFail(entry, err)
+			HandleError(s, entry, err)
 		}
 	}
 }